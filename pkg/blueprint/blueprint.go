@@ -125,6 +125,17 @@ func (b *Blueprint) Initialize() error {
 		}
 	}
 
+	if err := b.Customizations.CheckHostname(false); err != nil {
+		return err
+	}
+
+	// report template errors now instead of at build time. The rendered
+	// files are not stored, the templates must be rendered again when
+	// the blueprint changes
+	if _, err := b.RenderFileTemplates(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -161,7 +172,7 @@ func (b *Blueprint) GetPackagesEx(bootable bool) []string {
 	}
 
 	if bootable {
-		kc := b.Customizations.GetKernel()
+		kc := b.Customizations.kernel()
 		kpkg := Package{Name: kc.Name}
 		packages = append(packages, kpkg.ToNameVersion())
 	}
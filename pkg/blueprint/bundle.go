@@ -0,0 +1,557 @@
+package blueprint
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An offline bundle is a tar archive with the blueprint and all the local
+// files it references, so it can be built on a machine without access to
+// the original files. It contains:
+//
+//	manifest.json   the BundleManifest
+//	blueprint.json  the blueprint, references point into the bundle
+//	files/<n>/...   the referenced files and directory trees
+//
+// Bundled are the URIs of the File and DirectoryTree customizations and the
+// OpenSCAP JSON tailoring file. Paths that refer to the image, like the RPM
+// import key files or repository certificate paths, are left alone. The
+// ownership of directory trees with preserve_ownership is not carried over.
+const (
+	bundleManifestName  = "manifest.json"
+	bundleBlueprintName = "blueprint.json"
+	bundleFilesDir      = "files"
+
+	// BundleVersion is the version of the bundle format written by
+	// WriteBundle.
+	BundleVersion = 1
+
+	// MaxBundleSize is the maximum total size of the files extracted by
+	// ReadBundle.
+	MaxBundleSize = 4 * 1024 * 1024 * 1024
+)
+
+// BundleManifest describes the content of an offline bundle.
+type BundleManifest struct {
+	Version int `json:"version"`
+
+	// Files lists every regular file of the bundle except the manifest
+	// and the blueprint
+	Files []BundleFile `json:"files"`
+
+	// References lists the values in the blueprint that point into the
+	// bundle
+	References []BundleReference `json:"references"`
+}
+
+// BundleFile is a file in an offline bundle.
+type BundleFile struct {
+	// Path in the bundle, e.g. "files/0/motd"
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// BundleReference is a value in the blueprint that points to a file or
+// directory of the bundle.
+type BundleReference struct {
+	// JSON pointer (RFC 6901) of the value in the blueprint, e.g.
+	// "/customizations/files/0/uri"
+	Pointer string `json:"pointer"`
+	// Path in the bundle the value points to
+	Path string `json:"path"`
+	// Source is the original value in the blueprint
+	Source string `json:"source"`
+	// URI is set if the value is a file:// URI instead of a plain path
+	URI bool `json:"uri,omitempty"`
+}
+
+// localURIPath returns the local path of a file:// URI or plain path, or
+// false for other schemes.
+func localURIPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "" && u.Scheme != "file") {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// bundleWriter collects the content of a bundle.
+type bundleWriter struct {
+	tw       *tar.Writer
+	manifest BundleManifest
+	next     int
+}
+
+// nextDir returns a new directory in the bundle for a reference.
+func (bw *bundleWriter) nextDir() string {
+	dir := path.Join(bundleFilesDir, strconv.Itoa(bw.next))
+	bw.next++
+	return dir
+}
+
+func (bw *bundleWriter) writeDir(name string, mode fs.FileMode) error {
+	return bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(mode.Perm()),
+	})
+}
+
+func (bw *bundleWriter) writeFile(name, hostPath string) error {
+	fp, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	st, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if !st.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", hostPath)
+	}
+
+	if err := bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(st.Mode().Perm()),
+		Size:     st.Size(),
+	}); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(bw.tw, h), io.LimitReader(fp, st.Size())); err != nil {
+		return err
+	}
+	bw.manifest.Files = append(bw.manifest.Files, BundleFile{
+		Path:   name,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Size:   st.Size(),
+	})
+	return nil
+}
+
+// addFile bundles a single file referenced at pointer.
+func (bw *bundleWriter) addFile(pointer, source, hostPath string, uri bool) error {
+	dir := bw.nextDir()
+	if err := bw.writeDir(dir, 0755); err != nil {
+		return err
+	}
+	name := path.Join(dir, filepath.Base(hostPath))
+	if err := bw.writeFile(name, hostPath); err != nil {
+		return fmt.Errorf("cannot bundle %q: %w", source, err)
+	}
+	bw.manifest.References = append(bw.manifest.References, BundleReference{
+		Pointer: pointer,
+		Path:    name,
+		Source:  source,
+		URI:     uri,
+	})
+	return nil
+}
+
+// addTree bundles the content of a directory tree referenced at pointer.
+func (bw *bundleWriter) addTree(pointer string, tree DirectoryTreeCustomization) error {
+	root, err := tree.localPath()
+	if err != nil {
+		return err
+	}
+	dirs, files, err := tree.Expand()
+	if err != nil {
+		return err
+	}
+
+	dir := bw.nextDir()
+	// the root is written even if include patterns match nothing
+	st, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if err := bw.writeDir(dir, st.Mode()); err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		rel := strings.TrimPrefix(d.Path, tree.Path)
+		if rel == "" {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		if err := bw.writeDir(path.Join(dir, rel), st.Mode()); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		hostPath, _ := localURIPath(f.URI)
+		if err := bw.writeFile(path.Join(dir, strings.TrimPrefix(f.Path, tree.Path)), hostPath); err != nil {
+			return fmt.Errorf("cannot bundle %q: %w", hostPath, err)
+		}
+	}
+	bw.manifest.References = append(bw.manifest.References, BundleReference{
+		Pointer: pointer,
+		Path:    dir,
+		Source:  tree.URI,
+		URI:     true,
+	})
+	return nil
+}
+
+// addReferences bundles all local files referenced by the blueprint.
+func (bw *bundleWriter) addReferences(bp *Blueprint) error {
+	c := bp.Customizations
+	if c == nil {
+		return nil
+	}
+
+	for idx, file := range c.Files {
+		if file.URI == "" {
+			continue
+		}
+		hostPath, ok := localURIPath(file.URI)
+		if !ok {
+			continue
+		}
+		if err := bw.addFile(fmt.Sprintf("/customizations/files/%d/uri", idx), file.URI, hostPath, true); err != nil {
+			return err
+		}
+	}
+	for idx, tree := range c.DirectoryTrees {
+		if err := bw.addTree(fmt.Sprintf("/customizations/directory_trees/%d/uri", idx), tree); err != nil {
+			return err
+		}
+	}
+	if c.OpenSCAP != nil && c.OpenSCAP.JSONTailoring != nil && c.OpenSCAP.JSONTailoring.Filepath != "" {
+		tailoringPath := c.OpenSCAP.JSONTailoring.Filepath
+		if err := bw.addFile("/customizations/openscap/json_tailoring/filepath", tailoringPath, tailoringPath, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBundle writes the blueprint and all the local files it references as
+// an offline bundle to w.
+func WriteBundle(w io.Writer, bp *Blueprint) error {
+	bw := &bundleWriter{
+		tw:       tar.NewWriter(w),
+		manifest: BundleManifest{Version: BundleVersion},
+	}
+
+	if err := bw.writeDir(bundleFilesDir, 0755); err != nil {
+		return err
+	}
+	if err := bw.addReferences(bp); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+
+	// the blueprint in the bundle points to the bundled files
+	doc, err := blueprintJSONDocument(bp)
+	if err != nil {
+		return err
+	}
+	for _, ref := range bw.manifest.References {
+		if err := setJSONPointer(doc, ref.Pointer, ref.Path); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range []struct {
+		name  string
+		value any
+	}{
+		{bundleManifestName, bw.manifest},
+		{bundleBlueprintName, doc},
+	} {
+		data, err := json.MarshalIndent(entry.value, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := bw.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		}); err != nil {
+			return err
+		}
+		if _, err := bw.tw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return bw.tw.Close()
+}
+
+// blueprintJSONDocument returns the blueprint as generic JSON values.
+func blueprintJSONDocument(bp *Blueprint) (any, error) {
+	data, err := json.Marshal(bp)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// setJSONPointer sets the string at the JSON pointer in doc. Only pointers
+// to existing values are supported.
+func setJSONPointer(doc any, pointer string, value string) error {
+	if !strings.HasPrefix(pointer, "/") {
+		return fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	current := doc
+	for i, token := range tokens {
+		last := i == len(tokens)-1
+		switch node := current.(type) {
+		case map[string]any:
+			old, ok := node[token]
+			if !ok {
+				return fmt.Errorf("JSON pointer %q does not exist", pointer)
+			}
+			if last {
+				if _, ok := old.(string); !ok {
+					return fmt.Errorf("JSON pointer %q does not point to a string", pointer)
+				}
+				node[token] = value
+				return nil
+			}
+			current = old
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return fmt.Errorf("JSON pointer %q does not exist", pointer)
+			}
+			if last {
+				if _, ok := node[idx].(string); !ok {
+					return fmt.Errorf("JSON pointer %q does not point to a string", pointer)
+				}
+				node[idx] = value
+				return nil
+			}
+			current = node[idx]
+		default:
+			return fmt.Errorf("JSON pointer %q does not exist", pointer)
+		}
+	}
+	return fmt.Errorf("JSON pointer %q does not exist", pointer)
+}
+
+// bundleEntryPath returns the cleaned name of a tar entry, rejecting names
+// that would be extracted outside of the bundle directory.
+func bundleEntryPath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimSuffix(name, "/"))
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path %q in bundle", name)
+	}
+	return cleaned, nil
+}
+
+// ReadBundle extracts the offline bundle from r into dir, verifies the
+// files against the manifest and returns the blueprint with all references
+// pointing to the extracted files. dir must exist and should be empty. At
+// most MaxBundleSize bytes are extracted.
+func ReadBundle(r io.Reader, dir string) (*Blueprint, *BundleManifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifestData, blueprintData []byte
+	hashes := make(map[string]string)
+	sizes := make(map[string]int64)
+	dirModes := make(map[string]fs.FileMode)
+	var total int64
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read bundle: %w", err)
+		}
+		name, err := bundleEntryPath(hdr.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the reader never returns more than the size in the header, so
+		// checking it up front keeps the limit without extracting
+		// anything beyond it
+		total += hdr.Size
+		if hdr.Size < 0 || total > MaxBundleSize {
+			return nil, nil, fmt.Errorf("bundle exceeds the maximum size of %d bytes", int64(MaxBundleSize))
+		}
+
+		switch {
+		case hdr.Typeflag == tar.TypeReg && (name == bundleManifestName || name == bundleBlueprintName):
+			data, err := io.ReadAll(io.LimitReader(tr, MaxFileDataSize+1))
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot read bundle: %w", err)
+			}
+			if len(data) > MaxFileDataSize {
+				return nil, nil, fmt.Errorf("%s in bundle is too large", name)
+			}
+			if name == bundleManifestName {
+				manifestData = data
+			} else {
+				blueprintData = data
+			}
+		case name != bundleFilesDir && !strings.HasPrefix(name, bundleFilesDir+"/"):
+			return nil, nil, fmt.Errorf("unexpected entry %q in bundle", hdr.Name)
+		case hdr.Typeflag == tar.TypeDir:
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, nil, err
+			}
+			// applied after extraction, the directory might not be
+			// writable
+			// #nosec G115
+			dirModes[target] = fs.FileMode(hdr.Mode).Perm()
+		case hdr.Typeflag == tar.TypeReg:
+			if _, ok := hashes[name]; ok {
+				return nil, nil, fmt.Errorf("duplicate entry %q in bundle", hdr.Name)
+			}
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, nil, err
+			}
+			// #nosec G115
+			fp, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return nil, nil, err
+			}
+			h := sha256.New()
+			n, err := io.Copy(io.MultiWriter(fp, h), tr)
+			if closeErr := fp.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot extract %q: %w", name, err)
+			}
+			hashes[name] = hex.EncodeToString(h.Sum(nil))
+			sizes[name] = n
+		default:
+			return nil, nil, fmt.Errorf("unsupported entry %q in bundle", hdr.Name)
+		}
+	}
+
+	// deepest directories first, so parents stay writable until their
+	// children are done
+	dirs := make([]string, 0, len(dirModes))
+	for target := range dirModes {
+		dirs = append(dirs, target)
+	}
+	slices.Sort(dirs)
+	slices.Reverse(dirs)
+	for _, target := range dirs {
+		if err := os.Chmod(target, dirModes[target]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if manifestData == nil {
+		return nil, nil, fmt.Errorf("bundle has no %s", bundleManifestName)
+	}
+	if blueprintData == nil {
+		return nil, nil, fmt.Errorf("bundle has no %s", bundleBlueprintName)
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse bundle manifest: %w", err)
+	}
+	if manifest.Version != BundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	if err := manifest.verify(hashes, sizes); err != nil {
+		return nil, nil, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(blueprintData, &doc); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse bundled blueprint: %w", err)
+	}
+	for _, ref := range manifest.References {
+		name, err := bundleEntryPath(ref.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := filepath.Join(dir, filepath.FromSlash(name))
+		if ref.URI {
+			value = localFileURI(value)
+		}
+		if err := setJSONPointer(doc, ref.Pointer, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid bundle reference: %w", err)
+		}
+	}
+
+	// unmarshal after rewriting, the customizations check the referenced
+	// files when unmarshalling
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bp Blueprint
+	if err := json.Unmarshal(data, &bp); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse bundled blueprint: %w", err)
+	}
+
+	return &bp, &manifest, nil
+}
+
+// verify checks that the extracted files match the manifest exactly.
+func (m *BundleManifest) verify(hashes map[string]string, sizes map[string]int64) error {
+	var errs []error
+	listed := make(map[string]bool, len(m.Files))
+	for _, file := range m.Files {
+		listed[file.Path] = true
+		hash, ok := hashes[file.Path]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("file %q is missing", file.Path))
+		case hash != file.SHA256 || sizes[file.Path] != file.Size:
+			errs = append(errs, fmt.Errorf("file %q does not match the manifest: expected sha256 %s, got %s", file.Path, file.SHA256, hash))
+		}
+	}
+
+	var extra []string
+	for name := range hashes {
+		if !listed[name] {
+			extra = append(extra, name)
+		}
+	}
+	slices.Sort(extra)
+	for _, name := range extra {
+		errs = append(errs, fmt.Errorf("file %q is not listed in the manifest", name))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid bundle:\n%w", err)
+	}
+	return nil
+}
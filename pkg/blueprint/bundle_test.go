@@ -0,0 +1,289 @@
+package blueprint
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	hostDir := t.TempDir()
+	motd := filepath.Join(hostDir, "motd")
+	require.NoError(t, os.WriteFile(motd, []byte("hello world"), 0640))
+	require.NoError(t, os.Chmod(motd, 0640))
+	tailoring := filepath.Join(hostDir, "tailoring.json")
+	require.NoError(t, os.WriteFile(tailoring, []byte(`{"profiles": []}`), 0644))
+	tree := makeTestTree(t, map[string]os.FileMode{
+		"app.conf":       0644,
+		"conf.d/a.conf":  0600,
+		"docs/README.md": 0644,
+	})
+
+	sum := sha256.Sum256([]byte("hello world"))
+	bp := Blueprint{
+		Name: "bundled",
+		Customizations: &Customizations{
+			Files: []FileCustomization{
+				{Path: "/etc/motd", URI: "file://" + motd, Checksum: "sha256:" + hex.EncodeToString(sum[:])},
+				{Path: "/etc/issue", Data: "inline"},
+			},
+			DirectoryTrees: []DirectoryTreeCustomization{
+				{Path: "/etc/myapp", URI: tree, Exclude: []string{"docs"}},
+			},
+			OpenSCAP: &OpenSCAPCustomization{
+				ProfileID:     "xccdf_org.ssgproject.content_profile_cis",
+				JSONTailoring: &OpenSCAPJSONTailoringCustomizations{ProfileID: "custom", Filepath: tailoring},
+			},
+			RPM: &RPMCustomization{
+				ImportKeys: &RPMImportKeys{Files: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-fedora"}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteBundle(&buf, &bp))
+
+	// the builder has no access to the original files
+	require.NoError(t, os.RemoveAll(hostDir))
+	require.NoError(t, os.RemoveAll(tree))
+
+	dir := t.TempDir()
+	read, manifest, err := ReadBundle(bytes.NewReader(buf.Bytes()), dir)
+	require.NoError(t, err)
+
+	c := read.Customizations
+	assert.Equal(t, "file://"+filepath.Join(dir, "files/0/motd"), c.Files[0].URI)
+	assert.Equal(t, bp.Customizations.Files[0].Checksum, c.Files[0].Checksum)
+	assert.Equal(t, "inline", c.Files[1].Data)
+	assert.Equal(t, "file://"+filepath.Join(dir, "files/1"), c.DirectoryTrees[0].URI)
+	assert.Equal(t, filepath.Join(dir, "files/2/tailoring.json"), c.OpenSCAP.JSONTailoring.Filepath)
+	// paths in the image are not bundled
+	assert.Equal(t, []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-fedora"}, c.RPM.ImportKeys.Files)
+
+	st, err := os.Stat(filepath.Join(dir, "files/0/motd"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), st.Mode().Perm())
+
+	dirs, files, err := c.DirectoryTrees[0].Expand()
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryCustomization{
+		{Path: "/etc/myapp", Mode: "0755", EnsureParents: true},
+		{Path: "/etc/myapp/conf.d", Mode: "0755"},
+	}, dirs)
+	require.Len(t, files, 2)
+	assert.Equal(t, "/etc/myapp/conf.d/a.conf", files[1].Path)
+	assert.Equal(t, "0600", files[1].Mode)
+
+	assert.Equal(t, BundleVersion, manifest.Version)
+	assert.Len(t, manifest.Files, 4)
+	assert.Equal(t, BundleReference{
+		Pointer: "/customizations/files/0/uri",
+		Path:    "files/0/motd",
+		Source:  "file://" + motd,
+		URI:     true,
+	}, manifest.References[0])
+}
+
+func TestBundleSpecialNames(t *testing.T) {
+	hostDir := t.TempDir()
+	notes := filepath.Join(hostDir, "notes#1.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("hello world"), 0644))
+
+	bp := Blueprint{
+		Name: "bundled",
+		Customizations: &Customizations{
+			Files: []FileCustomization{
+				{Path: "/etc/notes", URI: localFileURI(notes)},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteBundle(&buf, &bp))
+
+	// both the bundled file name and the extraction directory contain
+	// characters that must be escaped in the URI
+	dir := filepath.Join(t.TempDir(), "bundle#1")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	read, _, err := ReadBundle(&buf, dir)
+	require.NoError(t, err)
+
+	file := read.Customizations.Files[0]
+	assert.Equal(t, "file://"+filepath.Join(filepath.Dir(dir), "bundle%231/files/0/notes%231.txt"), file.URI)
+	node, err := file.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "files/0/notes#1.txt"), node.URI())
+}
+
+func TestBundleWriteMissingFile(t *testing.T) {
+	bp := Blueprint{
+		Customizations: &Customizations{
+			OpenSCAP: &OpenSCAPCustomization{
+				JSONTailoring: &OpenSCAPJSONTailoringCustomizations{Filepath: "/nonexistent/tailoring.json"},
+			},
+		},
+	}
+	err := WriteBundle(io.Discard, &bp)
+	assert.EqualError(t, err, `cannot write bundle: cannot bundle "/nonexistent/tailoring.json": open /nonexistent/tailoring.json: no such file or directory`)
+}
+
+// testBundleEntry is an entry of a hand crafted bundle
+type testBundleEntry struct {
+	name     string
+	typeflag byte
+	data     string
+}
+
+func makeTestBundle(t *testing.T, entries []testBundleEntry) io.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: typeflag,
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+			Linkname: "/etc/passwd",
+		}))
+		_, err := tw.Write([]byte(entry.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestReadBundleErrors(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	manifest := func(files []BundleFile, refs []BundleReference) string {
+		data, err := json.Marshal(BundleManifest{Version: BundleVersion, Files: files, References: refs})
+		require.NoError(t, err)
+		return string(data)
+	}
+	validFiles := []BundleFile{{Path: "files/0/motd", SHA256: hex.EncodeToString(sum[:]), Size: 7}}
+
+	testCases := []struct {
+		Name    string
+		Entries []testBundleEntry
+		Error   string
+	}{
+		{
+			Name:    "traversal",
+			Entries: []testBundleEntry{{name: "files/../../etc/passwd", data: "x"}},
+			Error:   `invalid path "files/../../etc/passwd" in bundle`,
+		},
+		{
+			Name:    "absolute",
+			Entries: []testBundleEntry{{name: "/etc/passwd", data: "x"}},
+			Error:   `invalid path "/etc/passwd" in bundle`,
+		},
+		{
+			Name:    "outside-files",
+			Entries: []testBundleEntry{{name: "other/motd", data: "x"}},
+			Error:   `unexpected entry "other/motd" in bundle`,
+		},
+		{
+			Name:    "symlink",
+			Entries: []testBundleEntry{{name: "files/0/motd", typeflag: tar.TypeSymlink}},
+			Error:   `unsupported entry "files/0/motd" in bundle`,
+		},
+		{
+			Name:    "no-manifest",
+			Entries: []testBundleEntry{{name: "blueprint.json", data: "{}"}},
+			Error:   "bundle has no manifest.json",
+		},
+		{
+			Name: "bad-version",
+			Entries: []testBundleEntry{
+				{name: "manifest.json", data: `{"version": 2}`},
+				{name: "blueprint.json", data: "{}"},
+			},
+			Error: "unsupported bundle version 2",
+		},
+		{
+			Name: "modified-file",
+			Entries: []testBundleEntry{
+				{name: "files/0/motd", data: "changed"},
+				{name: "files/0/extra", data: "extra"},
+				{name: "manifest.json", data: manifest(append(validFiles, BundleFile{Path: "files/1/missing"}), nil)},
+				{name: "blueprint.json", data: "{}"},
+			},
+			Error: "invalid bundle:\n" +
+				`file "files/0/motd" does not match the manifest: expected sha256 ` + hex.EncodeToString(sum[:]) + `, got ` + func() string { s := sha256.Sum256([]byte("changed")); return hex.EncodeToString(s[:]) }() + "\n" +
+				`file "files/1/missing" is missing` + "\n" +
+				`file "files/0/extra" is not listed in the manifest`,
+		},
+		{
+			Name: "bad-reference",
+			Entries: []testBundleEntry{
+				{name: "files/0/motd", data: "content"},
+				{name: "manifest.json", data: manifest(validFiles, []BundleReference{{Pointer: "/customizations/files/0/uri", Path: "files/0/motd", URI: true}})},
+				{name: "blueprint.json", data: `{"customizations": {}}`},
+			},
+			Error: `invalid bundle reference: JSON pointer "/customizations/files/0/uri" does not exist`,
+		},
+		{
+			Name: "reference-outside",
+			Entries: []testBundleEntry{
+				{name: "files/0/motd", data: "content"},
+				{name: "manifest.json", data: manifest(validFiles, []BundleReference{{Pointer: "/customizations/files/0/uri", Path: "../motd", URI: true}})},
+				{name: "blueprint.json", data: `{"customizations": {"files": [{"path": "/etc/motd", "uri": "files/0/motd"}]}}`},
+			},
+			Error: `invalid path "../motd" in bundle`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, _, err := ReadBundle(makeTestBundle(t, tc.Entries), t.TempDir())
+			assert.EqualError(t, err, tc.Error)
+		})
+	}
+}
+
+func TestReadBundleMaxSize(t *testing.T) {
+	// the size limit is checked against the headers before any content is
+	// read, so the content of the last entry can be left out
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "files/0/a", Mode: 0644, Size: 7}))
+	_, err := tw.Write([]byte("content"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "files/1/b", Mode: 0644, Size: MaxBundleSize - 6}))
+
+	dir := t.TempDir()
+	_, _, err = ReadBundle(&buf, dir)
+	assert.EqualError(t, err, "bundle exceeds the maximum size of 4294967296 bytes")
+	// the first file fits and was extracted, the second one is not
+	assert.FileExists(t, filepath.Join(dir, "files/0/a"))
+	assert.NoFileExists(t, filepath.Join(dir, "files/1/b"))
+}
+
+func TestSetJSONPointer(t *testing.T) {
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(`{"a": [{"b": "x", "c/d": "y"}], "n": 1}`), &doc))
+
+	require.NoError(t, setJSONPointer(doc, "/a/0/b", "new"))
+	require.NoError(t, setJSONPointer(doc, "/a/0/c~1d", "new"))
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":[{"b":"new","c/d":"new"}],"n":1}`, string(data))
+
+	assert.EqualError(t, setJSONPointer(doc, "a", "new"), `invalid JSON pointer "a"`)
+	assert.EqualError(t, setJSONPointer(doc, "/a/1/b", "new"), `JSON pointer "/a/1/b" does not exist`)
+	assert.EqualError(t, setJSONPointer(doc, "/n", "new"), `JSON pointer "/n" does not point to a string`)
+	assert.ErrorContains(t, setJSONPointer(doc, "/a/0/b/c", "new"), "does not exist")
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/osbuild/images/pkg/cert"
 	"github.com/osbuild/images/pkg/customizations/anaconda"
+	"github.com/osbuild/images/pkg/customizations/fsnode"
 )
 
 type Customizations struct {
@@ -28,6 +29,9 @@ type Customizations struct {
 	Ignition           *IgnitionCustomization         `json:"ignition,omitempty" toml:"ignition,omitempty"`
 	Directories        []DirectoryCustomization       `json:"directories,omitempty" toml:"directories,omitempty"`
 	Files              []FileCustomization            `json:"files,omitempty" toml:"files,omitempty"`
+	Symlinks           []SymlinkCustomization         `json:"symlinks,omitempty" toml:"symlinks,omitempty"`
+	DirectoryTrees     []DirectoryTreeCustomization   `json:"directory_trees,omitempty" toml:"directory_trees,omitempty"`
+	Remove             []RemoveCustomization          `json:"remove,omitempty" toml:"remove,omitempty"`
 	Repositories       []RepositoryCustomization      `json:"repositories,omitempty" toml:"repositories,omitempty"`
 	FIPS               *bool                          `json:"fips,omitempty" toml:"fips,omitempty"`
 	Installer          *InstallerCustomization        `json:"installer,omitempty" toml:"installer,omitempty"`
@@ -39,6 +43,11 @@ type Customizations struct {
 	DNF                *DNFCustomization              `json:"dnf,omitempty" toml:"dnf,omitempty"`
 	ISO                *ISOCustomization              `json:"iso,omitempty" toml:"iso,omitempty"`
 	Sshd               *SshdCustomization             `json:"sshd,omitempty" toml:"sshd,omitempty"`
+	Systemd            *SystemdCustomization          `json:"systemd,omitempty" toml:"systemd,omitempty"`
+	Hosts              HostsCustomization             `json:"hosts,omitempty" toml:"hosts,omitempty"`
+	DNS                *DNSCustomization              `json:"dns,omitempty" toml:"dns,omitempty"`
+	Sysctl             SysctlCustomization            `json:"sysctl,omitempty" toml:"sysctl,omitempty"`
+	Network            *NetworkCustomization          `json:"network,omitempty" toml:"network,omitempty"`
 }
 
 type IgnitionCustomization struct {
@@ -65,11 +74,6 @@ type FDOCustomization struct {
 	DiMfgStringTypeMacIface string `json:"di_mfg_string_type_mac_iface,omitempty" toml:"di_mfg_string_type_mac_iface,omitempty"`
 }
 
-type KernelCustomization struct {
-	Name   string `json:"name,omitempty" toml:"name,omitempty"`
-	Append string `json:"append,omitempty" toml:"append,omitempty"`
-}
-
 type SSHKeyCustomization struct {
 	User string `json:"user" toml:"user"`
 	Key  string `json:"key" toml:"key"`
@@ -94,38 +98,6 @@ type GroupCustomization struct {
 	GID  *int   `json:"gid,omitempty" toml:"gid,omitempty"`
 }
 
-type TimezoneCustomization struct {
-	Timezone   *string  `json:"timezone,omitempty" toml:"timezone,omitempty"`
-	NTPServers []string `json:"ntpservers,omitempty" toml:"ntpservers,omitempty"`
-}
-
-type LocaleCustomization struct {
-	Languages []string `json:"languages,omitempty" toml:"languages,omitempty"`
-	Keyboard  *string  `json:"keyboard,omitempty" toml:"keyboard,omitempty"`
-}
-
-type FirewallCustomization struct {
-	Ports    []string                       `json:"ports,omitempty" toml:"ports,omitempty"`
-	Services *FirewallServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
-	Zones    []FirewallZoneCustomization    `json:"zones,omitempty" toml:"zones,omitempty"`
-}
-
-type FirewallZoneCustomization struct {
-	Name    *string  `json:"name,omitempty" toml:"name,omitempty"`
-	Sources []string `json:"sources,omitempty" toml:"sources,omitempty"`
-}
-
-type FirewallServicesCustomization struct {
-	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
-	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
-}
-
-type ServicesCustomization struct {
-	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
-	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
-	Masked   []string `json:"masked,omitempty" toml:"masked,omitempty"`
-}
-
 type OpenSCAPCustomization struct {
 	DataStream    string                               `json:"datastream,omitempty" toml:"datastream,omitempty"`
 	ProfileID     string                               `json:"profile_id,omitempty" toml:"profile_id,omitempty"`
@@ -155,14 +127,6 @@ type ContainerStorageCustomization struct {
 	StoragePath *string `json:"destination-path,omitempty" toml:"destination-path,omitempty"`
 }
 
-type DNFCustomization struct {
-	Config *DNFConfigCustomization `json:"config,omitempty" toml:"config,omitempty"`
-}
-
-type DNFConfigCustomization struct {
-	SetReleaseVer bool `json:"set_releasever,omitempty" toml:"set_releasever,omitempty"`
-}
-
 type CustomizationError struct {
 	Message string
 }
@@ -197,7 +161,7 @@ func (c *Customizations) CheckAllowed(allowed ...string) error {
 			if field.String() == "" {
 				empty = true
 			}
-		case reflect.Array, reflect.Slice:
+		case reflect.Array, reflect.Slice, reflect.Map:
 			if field.Len() == 0 {
 				empty = true
 			}
@@ -225,6 +189,65 @@ func (c *Customizations) GetHostname() *string {
 	return c.Hostname
 }
 
+// CheckHostname returns an error if the hostname customization is set but
+// not valid, see [ValidateHostname].
+func (c *Customizations) CheckHostname(fqdn bool) error {
+	if c == nil || c.Hostname == nil {
+		return nil
+	}
+	return ValidateHostname(*c.Hostname, fqdn)
+}
+
+func (c *Customizations) GetHosts() (HostsCustomization, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	if err := c.Hosts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Hosts, nil
+}
+
+func (c *Customizations) GetDNS() (*DNSCustomization, error) {
+	if c == nil || c.DNS == nil {
+		return nil, nil
+	}
+
+	if err := c.DNS.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.DNS, nil
+}
+
+func (c *Customizations) GetNetwork() (*NetworkCustomization, error) {
+	if c == nil || c.Network == nil {
+		return nil, nil
+	}
+
+	if err := c.Network.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Network, nil
+}
+
+// GetSysctl returns the validated sysctl settings. Conflicts with the OpenSCAP
+// profile are not checked here, see [SysctlCustomization.CheckOpenSCAP].
+func (c *Customizations) GetSysctl() (SysctlCustomization, error) {
+	if c == nil || len(c.Sysctl) == 0 {
+		return nil, nil
+	}
+
+	if err := c.Sysctl.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Sysctl, nil
+}
+
 func (c *Customizations) GetPrimaryLocale() (*string, *string) {
 	if c == nil {
 		return nil, nil
@@ -238,6 +261,20 @@ func (c *Customizations) GetPrimaryLocale() (*string, *string) {
 	return &c.Locale.Languages[0], c.Locale.Keyboard
 }
 
+// GetLocale returns the validated locale customization, including the X11
+// keyboard settings that GetPrimaryLocale does not expose.
+func (c *Customizations) GetLocale() (*LocaleCustomization, error) {
+	if c == nil || c.Locale == nil {
+		return nil, nil
+	}
+
+	if err := c.Locale.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Locale, nil
+}
+
 func (c *Customizations) GetTimezoneSettings() (*string, []string) {
 	if c == nil {
 		return nil, nil
@@ -248,37 +285,78 @@ func (c *Customizations) GetTimezoneSettings() (*string, []string) {
 	return c.Timezone.Timezone, c.Timezone.NTPServers
 }
 
-func (c *Customizations) GetKernel() *KernelCustomization {
-	var kernelName, kernelAppend string
-	if c != nil && c.Kernel != nil {
-		kernelName = c.Kernel.Name
-		kernelAppend = c.Kernel.Append
+// GetTimezone returns the validated timezone customization, including the
+// chrony specific settings that GetTimezoneSettings does not expose.
+func (c *Customizations) GetTimezone() (*TimezoneCustomization, error) {
+	if c == nil || c.Timezone == nil {
+		return nil, nil
+	}
+
+	if err := c.Timezone.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Timezone, nil
+}
+
+// GetKernel returns the validated kernel customization. The kernel package
+// name defaults to "kernel".
+func (c *Customizations) GetKernel() (*KernelCustomization, error) {
+	kernel := c.kernel()
+	if err := kernel.Validate(); err != nil {
+		return nil, err
 	}
 
-	if kernelName == "" {
-		kernelName = "kernel"
+	return kernel, nil
+}
+
+// kernel returns a copy of the kernel customization with the default
+// package name.
+func (c *Customizations) kernel() *KernelCustomization {
+	var kernel KernelCustomization
+	if c != nil && c.Kernel != nil {
+		kernel = *c.Kernel
 	}
 
-	return &KernelCustomization{
-		Name:   kernelName,
-		Append: kernelAppend,
+	if kernel.Name == "" {
+		kernel.Name = "kernel"
 	}
+
+	return &kernel
 }
 
-func (c *Customizations) GetFirewall() *FirewallCustomization {
+func (c *Customizations) GetFirewall() (*FirewallCustomization, error) {
 	if c == nil {
-		return nil
+		return nil, nil
+	}
+	if err := c.Firewall.Validate(); err != nil {
+		return nil, err
 	}
 
-	return c.Firewall
+	return c.Firewall, nil
 }
 
-func (c *Customizations) GetServices() *ServicesCustomization {
+func (c *Customizations) GetServices() (*ServicesCustomization, error) {
 	if c == nil {
-		return nil
+		return nil, nil
+	}
+	if err := c.Services.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.Services, nil
+}
+
+func (c *Customizations) GetSystemd() (*SystemdCustomization, error) {
+	if c == nil || c.Systemd == nil {
+		return nil, nil
+	}
+
+	if err := c.Systemd.Validate(); err != nil {
+		return nil, err
 	}
 
-	return c.Services
+	return c.Systemd, nil
 }
 
 func (c *Customizations) GetFilesystems() []FilesystemCustomization {
@@ -304,6 +382,8 @@ func (c *Customizations) GetFilesystemsMinSize() uint64 {
 	return agg
 }
 
+// GetPartitioning returns a validated copy of the disk customization with
+// the partition type aliases resolved to partition type IDs.
 func (c *Customizations) GetPartitioning() (*DiskCustomization, error) {
 	if c == nil {
 		return nil, nil
@@ -311,8 +391,12 @@ func (c *Customizations) GetPartitioning() (*DiskCustomization, error) {
 	if err := c.Disk.Validate(); err != nil {
 		return nil, err
 	}
+	if c.Disk == nil {
+		return nil, nil
+	}
 
-	return c.Disk, nil
+	// consumers get the partition type IDs, not the aliases
+	return c.Disk.resolvePartitionTypeIDs()
 }
 
 func (c *Customizations) GetInstallationDevice() string {
@@ -362,6 +446,27 @@ func (c *Customizations) GetFiles() []FileCustomization {
 	return c.Files
 }
 
+func (c *Customizations) GetSymlinks() []SymlinkCustomization {
+	if c == nil {
+		return nil
+	}
+	return c.Symlinks
+}
+
+func (c *Customizations) GetDirectoryTrees() []DirectoryTreeCustomization {
+	if c == nil {
+		return nil
+	}
+	return c.DirectoryTrees
+}
+
+func (c *Customizations) GetRemove() []RemoveCustomization {
+	if c == nil {
+		return nil
+	}
+	return c.Remove
+}
+
 func (c *Customizations) GetRepositories() ([]RepositoryCustomization, error) {
 	if c == nil {
 		return nil, nil
@@ -377,6 +482,16 @@ func (c *Customizations) GetRepositories() ([]RepositoryCustomization, error) {
 	return c.Repositories, nil
 }
 
+// CheckRepositories validates the custom repositories as a set, see
+// ValidateRepositorySet. reservedIDs are the IDs of the base repositories of
+// the target distribution.
+func (c *Customizations) CheckRepositories(reservedIDs ...string) error {
+	if c == nil {
+		return nil
+	}
+	return ValidateRepositorySet(c.Repositories, reservedIDs)
+}
+
 func (c *Customizations) GetFIPS() bool {
 	if c == nil || c.FIPS == nil {
 		return false
@@ -448,6 +563,17 @@ func (c *Customizations) GetRPM() *RPMCustomization {
 	return c.RPM
 }
 
+// GetRPMImportKeys returns the paths of the keys to import into the RPM
+// database and the files for the inline keys, cross-checked against the
+// inline keys of the custom repositories.
+func (c *Customizations) GetRPMImportKeys() ([]string, []*fsnode.File, error) {
+	rpm := c.GetRPM()
+	if rpm == nil {
+		return nil, nil, nil
+	}
+	return rpm.ImportKeys.ImportKeyFiles(c.Repositories)
+}
+
 func (c *Customizations) GetRHSM() *RHSMCustomization {
 	if c == nil {
 		return nil
@@ -488,3 +614,11 @@ func (c *Customizations) GetDNF() *DNFCustomization {
 	}
 	return c.DNF
 }
+
+// CheckDNF returns an error if the DNF customization is set but not valid.
+func (c *Customizations) CheckDNF() error {
+	if c == nil {
+		return nil
+	}
+	return c.DNF.Validate()
+}
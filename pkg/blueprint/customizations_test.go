@@ -73,9 +73,14 @@ func TestGetKernel(t *testing.T) {
 		Kernel: &expectedKernel,
 	}
 
-	retKernel := TestCustomizations.GetKernel()
+	retKernel, err := TestCustomizations.GetKernel()
+	assert.NoError(t, err)
 
 	assert.Equal(t, &expectedKernel, retKernel)
+
+	TestCustomizations.Kernel = &KernelCustomization{Append: "quiet \"unterminated"}
+	_, err = TestCustomizations.GetKernel()
+	assert.ErrorContains(t, err, "invalid kernel customizations:\n")
 }
 
 func TestGetTimezoneSettings(t *testing.T) {
@@ -121,7 +126,7 @@ func TestGetPrimaryLocale(t *testing.T) {
 }
 
 func TestGetFirewall(t *testing.T) {
-	expectedPorts := []string{"22", "9090"}
+	expectedPorts := []string{"22:tcp", "9090:tcp"}
 
 	expectedServices := FirewallServicesCustomization{
 		Enabled:  []string{"cockpit", "osbuild-composer"},
@@ -137,7 +142,8 @@ func TestGetFirewall(t *testing.T) {
 		Firewall: &expectedFirewall,
 	}
 
-	retFirewall := TestCustomizations.GetFirewall()
+	retFirewall, err := TestCustomizations.GetFirewall()
+	assert.NoError(t, err)
 
 	assert.ElementsMatch(t, expectedFirewall.Ports, retFirewall.Ports)
 	assert.ElementsMatch(t, expectedFirewall.Services.Enabled, retFirewall.Services.Enabled)
@@ -155,13 +161,27 @@ func TestGetServices(t *testing.T) {
 		Services: &expectedServices,
 	}
 
-	retServices := TestCustomizations.GetServices()
+	retServices, err := TestCustomizations.GetServices()
+	assert.NoError(t, err)
 
 	assert.ElementsMatch(t, expectedServices.Enabled, retServices.Enabled)
 	assert.ElementsMatch(t, expectedServices.Disabled, retServices.Disabled)
 	assert.ElementsMatch(t, expectedServices.Masked, retServices.Masked)
 }
 
+func TestGetServicesValidates(t *testing.T) {
+	c := Customizations{
+		Services: &ServicesCustomization{
+			Enabled: []string{"sshd"},
+			Masked:  []string{"sshd.service"},
+		},
+	}
+
+	services, err := c.GetServices()
+	assert.Nil(t, services)
+	assert.EqualError(t, err, "invalid services customizations:\nunit \"sshd.service\" is both enabled and masked")
+}
+
 func TestError(t *testing.T) {
 	expectedError := CustomizationError{
 		Message: "test error",
@@ -183,9 +203,15 @@ func TestNoCustomizationsInBlueprint(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, groups)
 
-	assert.Equal(t, &KernelCustomization{Name: "kernel"}, TestBP.Customizations.GetKernel())
-	assert.Nil(t, TestBP.Customizations.GetFirewall())
-	assert.Nil(t, TestBP.Customizations.GetServices())
+	kernel, err := TestBP.Customizations.GetKernel()
+	assert.NoError(t, err)
+	assert.Equal(t, &KernelCustomization{Name: "kernel"}, kernel)
+	firewall, err := TestBP.Customizations.GetFirewall()
+	assert.NoError(t, err)
+	assert.Nil(t, firewall)
+	services, err := TestBP.Customizations.GetServices()
+	assert.NoError(t, err)
+	assert.Nil(t, services)
 
 	nilLanguage, nilKeyboard := TestBP.Customizations.GetPrimaryLocale()
 	assert.Nil(t, nilLanguage)
@@ -543,7 +569,7 @@ func TestGetIgnition(t *testing.T) {
 func TestGetImportRPMGPGKey(t *testing.T) {
 	expectedRPM := RPMCustomization{
 		ImportKeys: &RPMImportKeys{
-			[]string{
+			Files: []string{
 				"/etc/pki/rpm-gpg/RPM-GPG-KEY",
 			},
 		},
@@ -0,0 +1,314 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+// DirectoryTreeCustomization represents a directory on the build host that
+// is copied recursively into the image
+type DirectoryTreeCustomization struct {
+	// Absolute path of the directory in the image
+	Path string `json:"path" toml:"path"`
+	// URI of the local directory to copy, only file:// URIs and plain
+	// paths are supported
+	URI string `json:"uri" toml:"uri"`
+	// Owner of all files and directories specified as a string (user
+	// name), int64 (UID) or nil
+	User any `json:"user,omitempty" toml:"user,omitempty"`
+	// Group of all files and directories specified as a string (group
+	// name), int64 (GID) or nil
+	Group any `json:"group,omitempty" toml:"group,omitempty"`
+	// PreserveOwnership takes the UID and GID from the files on the build
+	// host instead of defaulting to root, cannot be combined with User
+	// or Group
+	PreserveOwnership bool `json:"preserve_ownership,omitempty" toml:"preserve_ownership,omitempty"`
+	// Permissions of all files specified as an octal number, the
+	// permissions of the files on the build host are kept if empty
+	Mode string `json:"mode,omitempty" toml:"mode,omitempty"`
+	// Permissions of all directories specified as an octal number, the
+	// permissions of the directories on the build host are kept if empty
+	DirMode string `json:"dir_mode,omitempty" toml:"dir_mode,omitempty"`
+	// Include lists glob patterns of the files to copy, all files are
+	// copied if empty
+	Include []string `json:"include,omitempty" toml:"include,omitempty"`
+	// Exclude lists glob patterns of files and directories not to copy
+	Exclude []string `json:"exclude,omitempty" toml:"exclude,omitempty"`
+}
+
+func (t *DirectoryTreeCustomization) UnmarshalTOML(data any) error {
+	return unmarshalTOMLviaJSON(t, data)
+}
+
+// Custom JSON unmarshalling for DirectoryTreeCustomization with validation
+func (t *DirectoryTreeCustomization) UnmarshalJSON(data []byte) error {
+	type directoryTreeCustomization DirectoryTreeCustomization
+
+	var treePrivate directoryTreeCustomization
+	if err := json.Unmarshal(data, &treePrivate); err != nil {
+		return err
+	}
+
+	tree := DirectoryTreeCustomization(treePrivate)
+	if uid, ok := tree.User.(float64); ok {
+		// check if uid can be converted to int64
+		if uid != float64(int64(uid)) {
+			return fmt.Errorf("invalid user %f: must be an integer", uid)
+		}
+		tree.User = int64(uid)
+	}
+	if gid, ok := tree.Group.(float64); ok {
+		// check if gid can be converted to int64
+		if gid != float64(int64(gid)) {
+			return fmt.Errorf("invalid group %f: must be an integer", gid)
+		}
+		tree.Group = int64(gid)
+	}
+	if err := tree.Validate(); err != nil {
+		return err
+	}
+
+	*t = tree
+	return nil
+}
+
+// localPath returns the path of the directory on the build host.
+func (t DirectoryTreeCustomization) localPath() (string, error) {
+	uri, err := url.Parse(t.URI)
+	if err != nil {
+		return "", err
+	}
+	switch uri.Scheme {
+	case "", "file":
+	default:
+		return "", fmt.Errorf("unsupported scheme for %v (try file://)", uri)
+	}
+	if !filepath.IsAbs(uri.Path) {
+		return "", fmt.Errorf("%q must be an absolute path", uri.Path)
+	}
+	return uri.Path, nil
+}
+
+// Validate checks the tree customization without accessing the build host.
+func (t DirectoryTreeCustomization) Validate() error {
+	if _, err := fsnode.NewDirectory(t.Path, nil, t.User, t.Group, false); err != nil {
+		return fmt.Errorf("invalid directory tree %q: %w", t.Path, err)
+	}
+	if t.URI == "" {
+		return fmt.Errorf("invalid directory tree %q: uri is required", t.Path)
+	}
+	if _, err := t.localPath(); err != nil {
+		return fmt.Errorf("invalid directory tree %q: %w", t.Path, err)
+	}
+	if t.PreserveOwnership && (t.User != nil || t.Group != nil) {
+		return fmt.Errorf("invalid directory tree %q: preserve_ownership cannot be combined with user or group", t.Path)
+	}
+	for _, mode := range []string{t.Mode, t.DirMode} {
+		if mode == "" {
+			continue
+		}
+		if err := validateModeString(mode); err != nil {
+			return fmt.Errorf("invalid directory tree %q: %w", t.Path, err)
+		}
+	}
+	for _, pattern := range append(append([]string{}, t.Include...), t.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid directory tree %q: invalid pattern %q", t.Path, pattern)
+		}
+	}
+	return nil
+}
+
+// matchesTreePattern returns true if the relative path rel matches any of
+// the patterns. Patterns without a slash also match the base name, so "*.md"
+// matches in every directory.
+func matchesTreePattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// owner returns the user and group for a node of the tree.
+func (t DirectoryTreeCustomization) owner(info fs.FileInfo) (any, any) {
+	if !t.PreserveOwnership {
+		return t.User, t.Group
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Uid), int64(st.Gid)
+	}
+	return nil, nil
+}
+
+// Expand walks the directory on the build host and returns the directories
+// and files to create in the image. Directories excluded by a pattern are
+// skipped with their content. When include patterns are set only the
+// directories leading to included files are returned. Symbolic links and
+// special files on the build host are not supported.
+func (t DirectoryTreeCustomization) Expand() ([]DirectoryCustomization, []FileCustomization, error) {
+	if err := t.Validate(); err != nil {
+		return nil, nil, err
+	}
+	root, err := t.localPath()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot include directory tree: %w", err)
+	}
+	if !st.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	var dirs []DirectoryCustomization
+	var files []FileCustomization
+	// the directories of the tree, created only once they are needed
+	// if include patterns are set
+	pending := make(map[string]DirectoryCustomization)
+	created := make(map[string]bool)
+
+	var addDir func(target string)
+	addDir = func(target string) {
+		if created[target] {
+			return
+		}
+		if target != t.Path {
+			addDir(path.Dir(target))
+		}
+		dirs = append(dirs, pending[target])
+		created[target] = true
+	}
+
+	err = filepath.WalkDir(root, func(hostPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, hostPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		target := t.Path
+		if rel != "." {
+			target = path.Join(t.Path, rel)
+			if matchesTreePattern(t.Exclude, rel) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		user, group := t.owner(info)
+
+		switch {
+		case entry.IsDir():
+			mode := t.DirMode
+			if mode == "" {
+				mode = fmt.Sprintf("%04o", info.Mode().Perm())
+			}
+			pending[target] = DirectoryCustomization{
+				Path:          target,
+				User:          user,
+				Group:         group,
+				Mode:          mode,
+				EnsureParents: rel == ".",
+			}
+			if len(t.Include) == 0 {
+				addDir(target)
+			}
+		case info.Mode().IsRegular():
+			if len(t.Include) > 0 && !matchesTreePattern(t.Include, rel) {
+				return nil
+			}
+			mode := t.Mode
+			if mode == "" {
+				mode = fmt.Sprintf("%04o", info.Mode().Perm())
+			}
+			addDir(path.Dir(target))
+			files = append(files, FileCustomization{
+				Path:  target,
+				User:  user,
+				Group: group,
+				Mode:  mode,
+				URI:   localFileURI(hostPath),
+			})
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", hostPath, info.Mode().Type())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot include directory tree %q: %w", t.Path, err)
+	}
+
+	return dirs, files, nil
+}
+
+// localFileURI returns the file:// URI of a path on the host. The path is
+// escaped, "#", "?" and "%" in file names would otherwise be taken for a
+// fragment, a query or an escape sequence when the URI is parsed.
+func localFileURI(hostPath string) string {
+	return (&url.URL{Scheme: "file", Path: hostPath}).String()
+}
+
+// ExpandDirectoryTreeCustomizations expands the directory trees and returns
+// them merged with the given Directory and File customizations. The result
+// is checked with ValidateDirFileCustomizations and, if pathPolicy is not
+// nil, against the path policy.
+func ExpandDirectoryTreeCustomizations(trees []DirectoryTreeCustomization, dirs []DirectoryCustomization, files []FileCustomization, pathPolicy *pathpolicy.PathPolicies) ([]DirectoryCustomization, []FileCustomization, error) {
+	allDirs := append([]DirectoryCustomization{}, dirs...)
+	allFiles := append([]FileCustomization{}, files...)
+
+	var errs []error
+	for _, tree := range trees {
+		treeDirs, treeFiles, err := tree.Expand()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		allDirs = append(allDirs, treeDirs...)
+		allFiles = append(allFiles, treeFiles...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, fmt.Errorf("invalid directory tree customizations:\n%w", err)
+	}
+
+	if err := ValidateDirFileCustomizations(allDirs, allFiles); err != nil {
+		return nil, nil, err
+	}
+	if pathPolicy != nil {
+		if err := CheckDirectoryCustomizationsPolicy(allDirs, pathPolicy); err != nil {
+			return nil, nil, err
+		}
+		if err := CheckFileCustomizationsPolicy(allFiles, pathPolicy); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return allDirs, allFiles, nil
+}
@@ -0,0 +1,242 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+// makeTestTree creates the files (with their parent directories) below a
+// new temporary directory and returns it.
+func makeTestTree(t *testing.T, files map[string]os.FileMode) string {
+	t.Helper()
+
+	root := t.TempDir()
+	require.NoError(t, os.Chmod(root, 0755))
+	for name, mode := range files {
+		hostPath := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(hostPath), 0755))
+		require.NoError(t, os.Chmod(filepath.Dir(hostPath), 0755))
+		require.NoError(t, os.WriteFile(hostPath, []byte(name), mode))
+		require.NoError(t, os.Chmod(hostPath, mode))
+	}
+	return root
+}
+
+func TestDirectoryTreeCustomizationValidate(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Tree  DirectoryTreeCustomization
+		Error string
+	}{
+		{
+			Name: "valid",
+			Tree: DirectoryTreeCustomization{Path: "/etc/myapp", URI: "file:///srv/myapp", Mode: "0640", DirMode: "0750", Include: []string{"*.conf"}},
+		},
+		{
+			Name:  "relative-path",
+			Tree:  DirectoryTreeCustomization{Path: "etc/myapp", URI: "/srv/myapp"},
+			Error: `invalid directory tree "etc/myapp": path must be absolute`,
+		},
+		{
+			Name:  "no-uri",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp"},
+			Error: `invalid directory tree "/etc/myapp": uri is required`,
+		},
+		{
+			Name:  "remote-uri",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp", URI: "https://example.com/myapp"},
+			Error: `invalid directory tree "/etc/myapp": unsupported scheme for https://example.com/myapp (try file://)`,
+		},
+		{
+			Name:  "relative-uri",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp", URI: "srv/myapp"},
+			Error: `invalid directory tree "/etc/myapp": "srv/myapp" must be an absolute path`,
+		},
+		{
+			Name:  "preserve-and-user",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp", URI: "/srv/myapp", User: "root", PreserveOwnership: true},
+			Error: `invalid directory tree "/etc/myapp": preserve_ownership cannot be combined with user or group`,
+		},
+		{
+			Name:  "bad-mode",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp", URI: "/srv/myapp", DirMode: "rwx"},
+			Error: `invalid directory tree "/etc/myapp": invalid mode rwx: must be an octal number`,
+		},
+		{
+			Name:  "bad-pattern",
+			Tree:  DirectoryTreeCustomization{Path: "/etc/myapp", URI: "/srv/myapp", Exclude: []string{"[a-"}},
+			Error: `invalid directory tree "/etc/myapp": invalid pattern "[a-"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Tree.Validate()
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDirectoryTreeCustomizationExpand(t *testing.T) {
+	root := makeTestTree(t, map[string]os.FileMode{
+		"app.conf":         0644,
+		"secret.key":       0600,
+		"conf.d/a.conf":    0644,
+		"conf.d/README.md": 0644,
+		"docs/index.md":    0644,
+	})
+
+	tree := DirectoryTreeCustomization{Path: "/etc/myapp", URI: "file://" + root}
+	dirs, files, err := tree.Expand()
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryCustomization{
+		{Path: "/etc/myapp", Mode: "0755", EnsureParents: true},
+		{Path: "/etc/myapp/conf.d", Mode: "0755"},
+		{Path: "/etc/myapp/docs", Mode: "0755"},
+	}, dirs)
+	assert.Equal(t, []FileCustomization{
+		{Path: "/etc/myapp/app.conf", Mode: "0644", URI: "file://" + root + "/app.conf"},
+		{Path: "/etc/myapp/conf.d/README.md", Mode: "0644", URI: "file://" + root + "/conf.d/README.md"},
+		{Path: "/etc/myapp/conf.d/a.conf", Mode: "0644", URI: "file://" + root + "/conf.d/a.conf"},
+		{Path: "/etc/myapp/docs/index.md", Mode: "0644", URI: "file://" + root + "/docs/index.md"},
+		{Path: "/etc/myapp/secret.key", Mode: "0600", URI: "file://" + root + "/secret.key"},
+	}, files)
+
+	// overridden mode and ownership, filtered content
+	tree = DirectoryTreeCustomization{
+		Path:    "/etc/myapp",
+		URI:     root,
+		User:    "myapp",
+		Group:   int64(1000),
+		Mode:    "0640",
+		DirMode: "0750",
+		Include: []string{"*.conf", "*.key"},
+		Exclude: []string{"docs", "secret.*"},
+	}
+	dirs, files, err = tree.Expand()
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryCustomization{
+		{Path: "/etc/myapp", User: "myapp", Group: int64(1000), Mode: "0750", EnsureParents: true},
+		{Path: "/etc/myapp/conf.d", User: "myapp", Group: int64(1000), Mode: "0750"},
+	}, dirs)
+	assert.Equal(t, []FileCustomization{
+		{Path: "/etc/myapp/app.conf", User: "myapp", Group: int64(1000), Mode: "0640", URI: "file://" + root + "/app.conf"},
+		{Path: "/etc/myapp/conf.d/a.conf", User: "myapp", Group: int64(1000), Mode: "0640", URI: "file://" + root + "/conf.d/a.conf"},
+	}, files)
+
+	// every resulting file can be converted
+	_, err = FileCustomizationsToFsNodeFiles(files)
+	assert.NoError(t, err)
+
+	// preserved ownership
+	tree = DirectoryTreeCustomization{Path: "/etc/myapp", URI: root, PreserveOwnership: true, Include: []string{"app.conf"}}
+	_, files, err = tree.Expand()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, int64(os.Getuid()), files[0].User)
+	assert.Equal(t, int64(os.Getgid()), files[0].Group)
+
+	_, _, err = DirectoryTreeCustomization{Path: "/etc/myapp", URI: root + "/app.conf"}.Expand()
+	assert.EqualError(t, err, root+"/app.conf is not a directory")
+
+	require.NoError(t, os.Symlink("app.conf", filepath.Join(root, "link.conf")))
+	_, _, err = DirectoryTreeCustomization{Path: "/etc/myapp", URI: root}.Expand()
+	assert.EqualError(t, err, `cannot include directory tree "/etc/myapp": `+root+"/link.conf: unsupported file type L---------")
+}
+
+func TestDirectoryTreeCustomizationExpandSpecialNames(t *testing.T) {
+	root := makeTestTree(t, map[string]os.FileMode{
+		"notes#1.txt": 0644,
+		"what?.txt":   0644,
+		"a%20b":       0644,
+	})
+
+	tree := DirectoryTreeCustomization{Path: "/etc/myapp", URI: root}
+	_, files, err := tree.Expand()
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.Equal(t, "file://"+root+"/a%2520b", files[0].URI)
+	assert.Equal(t, "file://"+root+"/notes%231.txt", files[1].URI)
+	assert.Equal(t, "file://"+root+"/what%3F.txt", files[2].URI)
+
+	// the URIs point to the files on the host
+	nodes, err := FileCustomizationsToFsNodeFiles(files)
+	require.NoError(t, err)
+	for idx, name := range []string{"a%20b", "notes#1.txt", "what?.txt"} {
+		assert.Equal(t, "/etc/myapp/"+name, nodes[idx].Path())
+		assert.Equal(t, filepath.Join(root, name), nodes[idx].URI())
+	}
+}
+
+func TestExpandDirectoryTreeCustomizations(t *testing.T) {
+	root := makeTestTree(t, map[string]os.FileMode{
+		"app.conf":      0644,
+		"conf.d/a.conf": 0644,
+	})
+	pathPolicy := pathpolicy.NewPathPolicies(map[string]pathpolicy.PathPolicy{
+		"/":    {Deny: true},
+		"/etc": {},
+	})
+
+	trees := []DirectoryTreeCustomization{{Path: "/etc/myapp", URI: root}}
+	dirs, files, err := ExpandDirectoryTreeCustomizations(trees, []DirectoryCustomization{{Path: "/etc/other"}}, []FileCustomization{{Path: "/etc/motd"}}, pathPolicy)
+	require.NoError(t, err)
+	assert.Len(t, dirs, 3)
+	assert.Len(t, files, 3)
+	assert.Equal(t, "/etc/other", dirs[0].Path)
+	assert.Equal(t, "/etc/motd", files[0].Path)
+
+	// a customized file conflicts with a file of the tree
+	_, _, err = ExpandDirectoryTreeCustomizations(trees, nil, []FileCustomization{{Path: "/etc/myapp/app.conf"}}, pathPolicy)
+	assert.EqualError(t, err, "duplicate files / directory customization paths: [/etc/myapp/app.conf]")
+
+	_, _, err = ExpandDirectoryTreeCustomizations([]DirectoryTreeCustomization{{Path: "/usr/share/myapp", URI: root}}, nil, nil, pathPolicy)
+	assert.EqualError(t, err, `the following custom directories are not allowed: ["/usr/share/myapp" "/usr/share/myapp/conf.d"]`)
+
+	// without a policy only the nodes are validated
+	_, _, err = ExpandDirectoryTreeCustomizations([]DirectoryTreeCustomization{{Path: "/usr/share/myapp", URI: root}}, nil, nil, nil)
+	assert.NoError(t, err)
+
+	_, _, err = ExpandDirectoryTreeCustomizations([]DirectoryTreeCustomization{{Path: "/etc/myapp", URI: root + "/missing"}}, nil, nil, pathPolicy)
+	assert.EqualError(t, err, "invalid directory tree customizations:\ncannot include directory tree: stat "+root+"/missing: no such file or directory")
+}
+
+func TestDirectoryTreeCustomizationUnmarshal(t *testing.T) {
+	var bp struct {
+		Trees []DirectoryTreeCustomization `json:"directory_trees" toml:"directory_trees"`
+	}
+
+	err := json.Unmarshal([]byte(`{"directory_trees": [{"path": "/etc/myapp", "uri": "file:///srv/myapp", "user": 1000, "exclude": ["*.md"]}]}`), &bp)
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryTreeCustomization{
+		{Path: "/etc/myapp", URI: "file:///srv/myapp", User: int64(1000), Exclude: []string{"*.md"}},
+	}, bp.Trees)
+
+	bp.Trees = nil
+	_, err = toml.Decode(`
+[[directory_trees]]
+path = "/etc/myapp"
+uri = "file:///srv/myapp"
+group = 1000
+dir_mode = "0750"
+`, &bp)
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryTreeCustomization{
+		{Path: "/etc/myapp", URI: "file:///srv/myapp", Group: int64(1000), DirMode: "0750"},
+	}, bp.Trees)
+
+	err = json.Unmarshal([]byte(`{"directory_trees": [{"path": "/etc/myapp"}]}`), &bp)
+	assert.EqualError(t, err, `invalid directory tree "/etc/myapp": uri is required`)
+}
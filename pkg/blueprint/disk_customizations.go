@@ -62,6 +62,8 @@ func (dc *DiskCustomization) UnmarshalTOML(data any) error {
 //     extra fields
 //   - btrfs: the payload will be a btrfs volume. See
 //     [BtrfsVolumeCustomization] for extra fields.
+//
+// The payload of any type can be encrypted with LUKS by setting Encryption.
 type PartitionCustomization struct {
 	// The type of payload for the partition (optional, defaults to "plain").
 	Type string `json:"type,omitempty" toml:"type,omitempty"`
@@ -77,6 +79,8 @@ type PartitionCustomization struct {
 
 	// The partition type GUID for GPT partitions. For DOS partitions, this
 	// field can be used to set the (2 hex digit) partition type.
+	// Well-known types can also be set by name (e.g. "esp", "swap" or
+	// "root-x86_64"), see [PartitionTypeAliases].
 	// If not set, the type will be automatically set based on the mountpoint
 	// or the payload type.
 	PartType string `json:"part_type,omitempty" toml:"part_type,omitempty"`
@@ -89,6 +93,9 @@ type PartitionCustomization struct {
 	// Note: This is the unique uuid, not the type guid, that is PartType
 	PartUUID string `json:"part_uuid,omitempty" toml:"part_uuid,omitempty"`
 
+	// Encrypt the payload of the partition with LUKS (optional).
+	Encryption *LUKSCustomization `json:"encryption,omitempty" toml:"encryption,omitempty"`
+
 	BtrfsVolumeCustomization
 
 	VGCustomization
@@ -193,11 +200,12 @@ type BtrfsSubvolumeCustomization struct {
 func (v *PartitionCustomization) UnmarshalJSON(data []byte) error {
 	errPrefix := "JSON unmarshal:"
 	var typeSniffer struct {
-		Type      string `json:"type"`
-		MinSize   any    `json:"minsize"`
-		PartType  string `json:"part_type"`
-		PartLabel string `json:"part_label"`
-		PartUUID  string `json:"part_uuid"`
+		Type       string             `json:"type"`
+		MinSize    any                `json:"minsize"`
+		PartType   string             `json:"part_type"`
+		PartLabel  string             `json:"part_label"`
+		PartUUID   string             `json:"part_uuid"`
+		Encryption *LUKSCustomization `json:"encryption"`
 	}
 	if err := json.Unmarshal(data, &typeSniffer); err != nil {
 		return fmt.Errorf("%s %w", errPrefix, err)
@@ -229,6 +237,7 @@ func (v *PartitionCustomization) UnmarshalJSON(data []byte) error {
 	v.PartType = typeSniffer.PartType
 	v.PartLabel = typeSniffer.PartLabel
 	v.PartUUID = typeSniffer.PartUUID
+	v.Encryption = typeSniffer.Encryption
 
 	if typeSniffer.MinSize == nil {
 		return fmt.Errorf("minsize is required")
@@ -248,13 +257,15 @@ func (v *PartitionCustomization) UnmarshalJSON(data []byte) error {
 // the type is "plain", none of the fields for btrfs or lvm are used.
 func decodePlain(v *PartitionCustomization, data []byte) error {
 	var plain struct {
-		// Type, minsize, and part_* are handled by the caller. These are added here to
-		// satisfy "DisallowUnknownFields" when decoding.
-		Type      string `json:"type"`
-		MinSize   any    `json:"minsize"`
-		PartType  string `json:"part_type"`
-		PartLabel string `json:"part_label"`
-		PartUUID  string `json:"part_uuid"`
+		// Type, minsize, part_*, and encryption are handled by the caller.
+		// These are added here to satisfy "DisallowUnknownFields" when
+		// decoding.
+		Type       string             `json:"type"`
+		MinSize    any                `json:"minsize"`
+		PartType   string             `json:"part_type"`
+		PartLabel  string             `json:"part_label"`
+		PartUUID   string             `json:"part_uuid"`
+		Encryption *LUKSCustomization `json:"encryption"`
 		FilesystemTypedCustomization
 	}
 
@@ -274,13 +285,15 @@ func decodePlain(v *PartitionCustomization, data []byte) error {
 // the type is btrfs, none of the fields for plain or lvm are used.
 func decodeBtrfs(v *PartitionCustomization, data []byte) error {
 	var btrfs struct {
-		// Type, minsize, and part_* are handled by the caller. These are added here to
-		// satisfy "DisallowUnknownFields" when decoding.
-		Type      string `json:"type"`
-		MinSize   any    `json:"minsize"`
-		PartType  string `json:"part_type"`
-		PartLabel string `json:"part_label"`
-		PartUUID  string `json:"part_uuid"`
+		// Type, minsize, part_*, and encryption are handled by the caller.
+		// These are added here to satisfy "DisallowUnknownFields" when
+		// decoding.
+		Type       string             `json:"type"`
+		MinSize    any                `json:"minsize"`
+		PartType   string             `json:"part_type"`
+		PartLabel  string             `json:"part_label"`
+		PartUUID   string             `json:"part_uuid"`
+		Encryption *LUKSCustomization `json:"encryption"`
 		BtrfsVolumeCustomization
 	}
 
@@ -300,13 +313,15 @@ func decodeBtrfs(v *PartitionCustomization, data []byte) error {
 // is lvm, none of the fields for plain or btrfs are used.
 func decodeLVM(v *PartitionCustomization, data []byte) error {
 	var vg struct {
-		// Type, minsize, and part_* are handled by the caller. These are added here to
-		// satisfy "DisallowUnknownFields" when decoding.
-		Type      string `json:"type"`
-		MinSize   any    `json:"minsize"`
-		PartType  string `json:"part_type"`
-		PartLabel string `json:"part_label"`
-		PartUUID  string `json:"part_uuid"`
+		// Type, minsize, part_*, and encryption are handled by the caller.
+		// These are added here to satisfy "DisallowUnknownFields" when
+		// decoding.
+		Type       string             `json:"type"`
+		MinSize    any                `json:"minsize"`
+		PartType   string             `json:"part_type"`
+		PartLabel  string             `json:"part_label"`
+		PartUUID   string             `json:"part_uuid"`
+		Encryption *LUKSCustomization `json:"encryption"`
 		VGCustomization
 	}
 
@@ -365,6 +380,14 @@ func (v *PartitionCustomization) UnmarshalTOML(data any) error {
 
 	v.Type = partType
 
+	var encryption struct {
+		Encryption *LUKSCustomization `json:"encryption"`
+	}
+	if err := json.Unmarshal(dataJSON, &encryption); err != nil {
+		return fmt.Errorf("%s error decoding encryption for partition: %w", errPrefix, err)
+	}
+	v.Encryption = encryption.Encryption
+
 	minsizeField, ok := d["minsize"]
 	if !ok {
 		return fmt.Errorf("minsize is required")
@@ -393,6 +416,8 @@ func (v *PartitionCustomization) UnmarshalTOML(data any) error {
 //   - All non-empty properties are valid for the partition type (e.g.
 //     LogicalVolumes is empty when the type is "plain" or "btrfs")
 //   - Filesystems with FSType set to "swap" do not specify a mountpoint.
+//   - Encryption parameters are valid and /boot and /boot/efi are not
+//     encrypted.
 //
 // Note that in *addition* consumers should also call
 // ValidateLayoutConstraints() to validate that the policy for disk
@@ -434,6 +459,9 @@ func (p *DiskCustomization) Validate() error {
 		if err := part.ValidatePartitionLabel(p.Type); err != nil {
 			errs = append(errs, err)
 		}
+		if err := part.validateEncryption(); err != nil {
+			errs = append(errs, err)
+		}
 		switch part.Type {
 		case "plain", "":
 			errs = append(errs, part.validatePlain(mountpoints))
@@ -471,7 +499,9 @@ func validateMountpoint(path string) error {
 
 // ValidateLayoutConstraints checks that at most one LVM Volume Group or btrfs
 // volume is defined. Returns an error if both LVM and btrfs are set and if
-// either has more than one element.
+// either has more than one element. If the root filesystem is encrypted, a
+// separate, unencrypted /boot partition must be defined so the bootloader can
+// load the kernel and initrd.
 //
 // Note that this is a *policy* validation, in theory the "disk" code
 // does support the constraints but we choose not to allow them for
@@ -483,6 +513,7 @@ func (p *DiskCustomization) ValidateLayoutConstraints() error {
 	}
 
 	var btrfsVols, lvmVGs uint
+	var encryptedRoot, plainBoot bool
 	for _, part := range p.Partitions {
 		switch part.Type {
 		case "lvm":
@@ -493,6 +524,18 @@ func (p *DiskCustomization) ValidateLayoutConstraints() error {
 		if lvmVGs > 0 && btrfsVols > 0 {
 			return fmt.Errorf("btrfs and lvm partitioning cannot be combined")
 		}
+		for _, mp := range part.mountpoints() {
+			switch {
+			case mp == "/" && part.Encryption != nil:
+				encryptedRoot = true
+			case mp == "/boot" && part.Encryption == nil:
+				plainBoot = true
+			}
+		}
+	}
+
+	if encryptedRoot && !plainBoot {
+		return fmt.Errorf("an encrypted root filesystem requires a separate unencrypted /boot partition")
 	}
 
 	if btrfsVols > 1 {
@@ -555,12 +598,30 @@ func isPowerOfTwo(n uint64) bool {
 // valid given the partition table type. If the partition table type is an
 // empty string, the function returns an error only if the partition type ID is
 // invalid for both gpt and dos partition tables.
+// The partition type ID can also be one of the aliases returned by
+// [PartitionTypeAliases], which must be supported by the partition table type.
+// Aliases require the partition table type, the default depends on the distro
+// and image type and is not known here.
 func (p *PartitionCustomization) ValidatePartitionTypeID(ptType string) error {
 	// Empty PartType is fine, it will be selected automatically
 	if p.PartType == "" {
 		return nil
 	}
 
+	if isPartitionTypeAlias(p.PartType) {
+		ids, err := lookupPartitionTypeAlias(p.PartType)
+		if err != nil {
+			return err
+		}
+		if ptType == "" {
+			return fmt.Errorf("partition part_type alias %q requires the partition table type to be set", p.PartType)
+		}
+		if ptType == "dos" && ids.DOS == "" {
+			return fmt.Errorf("partition part_type alias %q is not supported for partition table type %q", p.PartType, ptType)
+		}
+		return nil
+	}
+
 	_, uuidErr := uuid.Parse(p.PartType)
 	validDosType := validDosPartitionType.MatchString(p.PartType)
 
@@ -1146,6 +1146,163 @@ func TestPartitioningValidation(t *testing.T) {
 			},
 			expectedMsg: "invalid partitioning customizations:\ninvalid partition part_type \"93a9549d-cae1-4024-b95c-e09d77b34c60\" for partition table type \"dos\" (must be a 2-digit hex number)",
 		},
+		"happy-partition-part_type-alias-gpt": {
+			partitioning: &blueprint.DiskCustomization{
+				Type: "gpt",
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						PartType: "bios-boot",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType: "none",
+						},
+					},
+					{
+						PartType: "root-x86_64",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/",
+						},
+					},
+				},
+			},
+		},
+		"happy-partition-part_type-alias-dos": {
+			partitioning: &blueprint.DiskCustomization{
+				Type: "dos",
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						PartType: "swap",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType: "swap",
+						},
+					},
+					{
+						PartType: "linux",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/",
+						},
+					},
+				},
+			},
+		},
+		"unhappy-partition-part_type-alias-no-table-type": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						PartType: "home",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/home",
+						},
+					},
+				},
+			},
+			expectedMsg: "invalid partitioning customizations:\npartition part_type alias \"home\" requires the partition table type to be set",
+		},
+		"unhappy-partition-part_type-alias-unknown": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						PartType: "root",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/",
+						},
+					},
+				},
+			},
+			expectedMsg: "invalid partitioning customizations:\nunknown partition part_type alias \"root\" (valid aliases: bios-boot, esp, home, linux, lvm, root-aarch64, root-ppc64le, root-riscv64, root-s390x, root-x86_64, srv, swap, var, xbootldr)",
+		},
+		"unhappy-partition-part_type-alias-dos": {
+			partitioning: &blueprint.DiskCustomization{
+				Type: "dos",
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						PartType: "bios-boot",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType: "none",
+						},
+					},
+				},
+			},
+			expectedMsg: "invalid partitioning customizations:\npartition part_type alias \"bios-boot\" is not supported for partition table type \"dos\"",
+		},
+		"happy-encrypted-lvm": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/boot",
+						},
+					},
+					{
+						Type: "lvm",
+						Encryption: &blueprint.LUKSCustomization{
+							Passphrase: "env:LUKS_PASSPHRASE",
+							Clevis: &blueprint.ClevisCustomization{
+								TPM2: &blueprint.ClevisTPM2Pin{},
+							},
+						},
+						VGCustomization: blueprint.VGCustomization{
+							LogicalVolumes: []blueprint.LVCustomization{
+								{
+									FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+										FSType:     "xfs",
+										Mountpoint: "/",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"unhappy-encrypted-boot": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						Encryption: &blueprint.LUKSCustomization{
+							Passphrase: "env:LUKS_PASSPHRASE",
+						},
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/boot",
+						},
+					},
+					{
+						Type: "btrfs",
+						Encryption: &blueprint.LUKSCustomization{
+							Passphrase: "env:LUKS_PASSPHRASE",
+						},
+						BtrfsVolumeCustomization: blueprint.BtrfsVolumeCustomization{
+							Subvolumes: []blueprint.BtrfsSubvolumeCustomization{
+								{Name: "root", Mountpoint: "/"},
+								{Name: "boot", Mountpoint: "/boot/efi"},
+							},
+						},
+					},
+				},
+			},
+			expectedMsg: "invalid partitioning customizations:\nmountpoint \"/boot\" must not be on an encrypted partition\nmountpoint \"/boot/efi\" must not be on an encrypted partition\ninvalid mountpoint \"/boot/efi\" for btrfs subvolume",
+		},
+		"unhappy-encryption-params": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						Encryption: &blueprint.LUKSCustomization{
+							Version: "luks3",
+						},
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							FSType:     "xfs",
+							Mountpoint: "/",
+						},
+					},
+				},
+			},
+			expectedMsg: "invalid partitioning customizations:\ninvalid encryption for partition: unknown LUKS version \"luks3\" (valid: luks1, luks2)\nLUKS passphrase secret reference is required",
+		},
 		"happy-partition-part_uuid": {
 			partitioning: &blueprint.DiskCustomization{
 				Partitions: []blueprint.PartitionCustomization{
@@ -1265,6 +1422,50 @@ func TestPartitioningLayoutConstraints(t *testing.T) {
 	}
 
 	testCases := map[string]testCase{
+		"happy-encrypted-root-plain-boot": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{Mountpoint: "/boot"},
+					},
+					{
+						Encryption:                   &blueprint.LUKSCustomization{Passphrase: "env:PW"},
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{Mountpoint: "/"},
+					},
+				},
+			},
+		},
+		"happy-encrypted-data": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{Mountpoint: "/"},
+					},
+					{
+						Encryption:                   &blueprint.LUKSCustomization{Passphrase: "env:PW"},
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{Mountpoint: "/data"},
+					},
+				},
+			},
+		},
+		"unhappy-encrypted-root-no-boot": {
+			partitioning: &blueprint.DiskCustomization{
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						Type:       "lvm",
+						Encryption: &blueprint.LUKSCustomization{Passphrase: "env:PW"},
+						VGCustomization: blueprint.VGCustomization{
+							LogicalVolumes: []blueprint.LVCustomization{
+								{
+									FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{Mountpoint: "/"},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedMsg: "an encrypted root filesystem requires a separate unencrypted /boot partition",
+		},
 		"unhappy-btrfs+lvm": {
 			partitioning: &blueprint.DiskCustomization{
 				Partitions: []blueprint.PartitionCustomization{
@@ -1476,6 +1677,59 @@ func TestPartitionCustomizationUnmarshalJSON(t *testing.T) {
 				},
 			},
 		},
+		"plain-encrypted": {
+			input: `{
+				"type": "plain",
+				"minsize": "1 GiB",
+				"mountpoint": "/data",
+				"fs_type": "xfs",
+				"encryption": {
+					"version": "luks2",
+					"cipher": "aes-xts-plain64",
+					"pbkdf": {"type": "argon2id", "iterations": 4, "memory": 32768, "parallelism": 1},
+					"passphrase": "file:/run/secrets/luks",
+					"clevis": {
+						"tpm2": {"pcr_bank": "sha256", "pcr_ids": [7]},
+						"tang": [{"url": "https://tang.example.com", "thumbprint": "abc"}],
+						"threshold": 2,
+						"remove_passphrase": true
+					}
+				}
+			}`,
+			expected: &blueprint.PartitionCustomization{
+				Type:    "plain",
+				MinSize: 1 * datasizes.GiB,
+				Encryption: &blueprint.LUKSCustomization{
+					Version: "luks2",
+					Cipher:  "aes-xts-plain64",
+					PBKDF: &blueprint.PBKDFCustomization{
+						Type:        "argon2id",
+						Iterations:  4,
+						Memory:      32768,
+						Parallelism: 1,
+					},
+					Passphrase: "file:/run/secrets/luks",
+					Clevis: &blueprint.ClevisCustomization{
+						TPM2:             &blueprint.ClevisTPM2Pin{PCRBank: "sha256", PCRIDs: []int{7}},
+						Tang:             []blueprint.ClevisTangPin{{URL: "https://tang.example.com", Thumbprint: "abc"}},
+						Threshold:        2,
+						RemovePassphrase: true,
+					},
+				},
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					Mountpoint: "/data",
+					FSType:     "xfs",
+				},
+			},
+		},
+		"lvm-encrypted-unknown-field": {
+			input: `{
+				"type": "lvm",
+				"minsize": "1 GiB",
+				"encryption": {"passphrase": "env:PW", "key": "secret"}
+			}`,
+			errorMsg: `JSON unmarshal: error decoding partition with type "lvm": json: unknown field "key"`,
+		},
 		"plain-with-int": {
 			input: `{
 				"type": "plain",
@@ -1789,6 +2043,41 @@ func TestPartitionCustomizationUnmarshalTOML(t *testing.T) {
 				},
 			},
 		},
+		"lvm-encrypted": {
+			input: `type = "lvm"
+					minsize = "2 GiB"
+
+					[encryption]
+					passphrase = "env:LUKS_PASSPHRASE"
+					[encryption.clevis.tpm2]
+					pcr_ids = [0, 7]
+
+					[[logical_volumes]]
+					minsize = "1 GiB"
+					mountpoint = "/"
+					fs_type = "xfs"`,
+			expected: &blueprint.PartitionCustomization{
+				Type:    "lvm",
+				MinSize: 2 * datasizes.GiB,
+				Encryption: &blueprint.LUKSCustomization{
+					Passphrase: "env:LUKS_PASSPHRASE",
+					Clevis: &blueprint.ClevisCustomization{
+						TPM2: &blueprint.ClevisTPM2Pin{PCRIDs: []int{0, 7}},
+					},
+				},
+				VGCustomization: blueprint.VGCustomization{
+					LogicalVolumes: []blueprint.LVCustomization{
+						{
+							MinSize: 1 * datasizes.GiB,
+							FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+								Mountpoint: "/",
+								FSType:     "xfs",
+							},
+						},
+					},
+				},
+			},
+		},
 		"plain-with-int": {
 			input: `type = "plain"
 					minsize = 1073741824
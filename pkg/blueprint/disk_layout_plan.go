@@ -0,0 +1,358 @@
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/osbuild/images/pkg/datasizes"
+)
+
+const (
+	// defaultDiskSectorSize is the sector size used when
+	// DiskCustomization.SectorSize is not set.
+	defaultDiskSectorSize = 512
+
+	// Partition starts and sizes are aligned to 1 MiB (or the sector size if
+	// that is larger).
+	diskGrainBytes = 1 * datasizes.MiB
+
+	// Logical volume sizes are rounded up to whole physical extents.
+	lvmExtentSize = 4 * datasizes.MiB
+
+	// Space reserved at the start of an LVM physical volume for the LVM
+	// label and metadata area, i.e. the start of the first physical extent.
+	lvmMetadataSize = 1 * datasizes.MiB
+
+	// A GPT partition table always reserves space for at least 128 entries
+	// of 128 bytes each.
+	gptMinPartitionEntries = 128
+	gptPartitionEntrySize  = 128
+)
+
+// DiskLayoutPlan is the partition table that results from a
+// [DiskCustomization]. It contains the concrete offsets and sizes of all
+// customized partitions, taking alignment, the start offset, the partition
+// table header and footer, LVM metadata and LUKS headers into account.
+//
+// The plan only covers the partitions defined in the customization. Image
+// types can add partitions of their own (e.g. a BIOS boot partition or an
+// ESP), which will move the customized partitions and grow the disk.
+type DiskLayoutPlan struct {
+	// Partition table type (gpt or dos). Unset table types are planned as
+	// gpt.
+	Type string `json:"type"`
+
+	SectorSize  uint64 `json:"sector_size"`
+	StartOffset uint64 `json:"start_offset,omitempty"`
+
+	// The requested minimum size of the disk.
+	MinSize uint64 `json:"minsize,omitempty"`
+
+	// The size needed to fit all partitions, regardless of MinSize.
+	RequiredSize uint64 `json:"required_size"`
+
+	// The final size of the disk.
+	Size uint64 `json:"size"`
+
+	Partitions []PartitionPlan `json:"partitions"`
+
+	// Problems with the customization that do not prevent building the
+	// image, e.g. a disk minsize that is too small for its partitions.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PartitionPlan is a single partition of a [DiskLayoutPlan]. Offsets are in
+// bytes from the start of the disk and End is exclusive.
+type PartitionPlan struct {
+	// Partition number in the partition table (starting at 1).
+	Number int `json:"number"`
+
+	// The payload type: plain, lvm, or btrfs.
+	Type string `json:"type"`
+
+	// The partition type ID with aliases resolved.
+	PartType  string `json:"part_type,omitempty"`
+	PartLabel string `json:"part_label,omitempty"`
+
+	Mountpoint string `json:"mountpoint,omitempty"`
+	FSType     string `json:"fs_type,omitempty"`
+
+	MinSize uint64 `json:"minsize"`
+	Start   uint64 `json:"start"`
+	End     uint64 `json:"end"`
+	Size    uint64 `json:"size"`
+
+	// Root is true for the partition that holds the root filesystem. It is
+	// placed after all other partitions and grows to fill the disk.
+	Root bool `json:"root,omitempty"`
+
+	// Space taken by the LUKS header if the partition is encrypted. It is
+	// added on top of the payload, the minimum size applies to the
+	// decrypted volume.
+	LUKSHeaderSize uint64 `json:"luks_header_size,omitempty"`
+
+	// Volume group name and its logical volumes for lvm partitions.
+	VGName         string              `json:"vg_name,omitempty"`
+	MetadataSize   uint64              `json:"metadata_size,omitempty"`
+	LogicalVolumes []LogicalVolumePlan `json:"logical_volumes,omitempty"`
+
+	// Subvolumes for btrfs partitions. Subvolumes share the space of the
+	// volume and have no size of their own.
+	Subvolumes []BtrfsSubvolumeCustomization `json:"subvolumes,omitempty"`
+}
+
+// LogicalVolumePlan is a single logical volume of an lvm [PartitionPlan].
+type LogicalVolumePlan struct {
+	Name       string `json:"name,omitempty"`
+	Mountpoint string `json:"mountpoint,omitempty"`
+	FSType     string `json:"fs_type,omitempty"`
+	MinSize    uint64 `json:"minsize"`
+	Size       uint64 `json:"size"`
+}
+
+// Plan computes the layout of the partition table described by the disk
+// customization. The customization is validated first. The partition holding
+// the root filesystem is placed last and grows to fill the disk; the disk is
+// grown when MinSize is too small for the partitions, which is reported in
+// the plan's warnings.
+func (p *DiskCustomization) Plan() (*DiskLayoutPlan, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return planDiskLayout(p, p.MinSize)
+}
+
+// PlanDiskLayout computes the layout of the disk customization. The size of
+// the filesystem customizations (see GetFilesystemsMinSize) is used as a lower
+// bound for the size of the disk. Returns nil if there is no disk
+// customization.
+func (c *Customizations) PlanDiskLayout() (*DiskLayoutPlan, error) {
+	if c == nil || c.Disk == nil {
+		return nil, nil
+	}
+
+	minSize := c.Disk.MinSize
+	if fsMinSize := c.GetFilesystemsMinSize(); fsMinSize > minSize {
+		minSize = fsMinSize
+	}
+	return planDiskLayout(c.Disk, minSize)
+}
+
+func planDiskLayout(disk *DiskCustomization, minSize uint64) (*DiskLayoutPlan, error) {
+	if err := disk.Validate(); err != nil {
+		return nil, err
+	}
+
+	plan := &DiskLayoutPlan{
+		Type:        disk.Type,
+		SectorSize:  disk.SectorSize,
+		StartOffset: disk.StartOffset,
+		MinSize:     minSize,
+		Partitions:  make([]PartitionPlan, 0, len(disk.Partitions)),
+	}
+	if plan.Type == "" {
+		plan.Type = "gpt"
+	}
+	if plan.SectorSize == 0 {
+		plan.SectorSize = defaultDiskSectorSize
+	}
+
+	grain := uint64(diskGrainBytes)
+	if plan.SectorSize > grain {
+		grain = plan.SectorSize
+	}
+
+	header := plan.SectorSize // protective MBR or DOS partition table
+	var footer uint64
+	if plan.Type == "gpt" {
+		entries := uint64(len(disk.Partitions))
+		if entries < gptMinPartitionEntries {
+			entries = gptMinPartitionEntries
+		}
+		header += entries * gptPartitionEntrySize
+		// the backup GPT header lives at the end of the disk
+		footer = header
+	}
+
+	rootIdx := -1
+	for idx, part := range disk.Partitions {
+		pp := planPartition(part, grain)
+		pp.Number = idx + 1
+		partType, err := part.ResolvePartitionTypeID(plan.Type)
+		if err != nil {
+			return nil, err
+		}
+		pp.PartType = partType
+		if pp.Root {
+			rootIdx = idx
+		}
+		plan.Partitions = append(plan.Partitions, pp)
+	}
+
+	start := alignUpTo(header, grain) + plan.StartOffset
+	for idx := range plan.Partitions {
+		if idx == rootIdx {
+			continue
+		}
+		pp := &plan.Partitions[idx]
+		pp.Start = start
+		pp.End = start + pp.Size
+		start = pp.End
+	}
+
+	var root *PartitionPlan
+	if rootIdx >= 0 {
+		root = &plan.Partitions[rootIdx]
+		root.Start = start
+		start += root.Size
+	}
+
+	plan.RequiredSize = alignUpTo(start+footer, grain)
+	plan.Size = alignUpTo(minSize, grain)
+	if plan.RequiredSize > plan.Size {
+		if minSize > 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("disk minsize %s is too small for its partitions: the disk will be grown to %s", formatDiskSize(minSize), formatDiskSize(plan.RequiredSize)))
+		}
+		plan.Size = plan.RequiredSize
+	}
+
+	if root != nil {
+		// the root partition takes up all remaining space up to the footer
+		root.Size = plan.Size - footer - root.Start
+		root.End = root.Start + root.Size
+	} else if len(plan.Partitions) > 0 {
+		plan.Warnings = append(plan.Warnings, "no partition contains the root filesystem: the remaining space on the disk is left unallocated")
+	}
+
+	return plan, nil
+}
+
+// planPartition computes the (aligned) size of a single partition and its
+// logical volumes. The start offset is set by the caller.
+func planPartition(part PartitionCustomization, grain uint64) PartitionPlan {
+	pp := PartitionPlan{
+		Type:      part.Type,
+		PartLabel: part.PartLabel,
+		MinSize:   part.MinSize,
+	}
+	if pp.Type == "" {
+		pp.Type = "plain"
+	}
+
+	// the minimum size and the volume group hold the payload, the LUKS
+	// header comes on top of it for all payload types
+	payloadSize := part.MinSize
+	switch pp.Type {
+	case "plain":
+		pp.Mountpoint = part.Mountpoint
+		pp.FSType = part.FSType
+		pp.Root = part.Mountpoint == "/"
+	case "lvm":
+		pp.VGName = part.Name
+		pp.MetadataSize = lvmMetadataSize
+		vgSize := uint64(lvmMetadataSize)
+		for _, lv := range part.LogicalVolumes {
+			lvSize := alignUpTo(lv.MinSize, lvmExtentSize)
+			pp.LogicalVolumes = append(pp.LogicalVolumes, LogicalVolumePlan{
+				Name:       lv.Name,
+				Mountpoint: lv.Mountpoint,
+				FSType:     lv.FSType,
+				MinSize:    lv.MinSize,
+				Size:       lvSize,
+			})
+			vgSize += lvSize
+			if lv.Mountpoint == "/" {
+				pp.Root = true
+			}
+		}
+		payloadSize = max(payloadSize, vgSize)
+	case "btrfs":
+		pp.Subvolumes = part.Subvolumes
+		for _, subvol := range part.Subvolumes {
+			if subvol.Mountpoint == "/" {
+				pp.Root = true
+			}
+		}
+	}
+
+	pp.LUKSHeaderSize = part.Encryption.HeaderSize()
+	pp.Size = alignUpTo(pp.LUKSHeaderSize+payloadSize, grain)
+	return pp
+}
+
+// WriteTable writes the plan as a human readable table.
+func (plan *DiskLayoutPlan) WriteTable(w io.Writer) error {
+	var buf strings.Builder
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTYPE\tSTART\tEND\tSIZE\tMOUNTPOINT\tFSTYPE\tNAME\t")
+	for _, pp := range plan.Partitions {
+		partType := pp.Type
+		if pp.LUKSHeaderSize > 0 {
+			partType += "+luks"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", pp.Number, partType, pp.Start, pp.End, formatDiskSize(pp.Size), pp.Mountpoint, pp.FSType, pp.VGName)
+		for _, lv := range pp.LogicalVolumes {
+			fmt.Fprintf(tw, "\t  lv\t\t\t%s\t%s\t%s\t%s\t\n", formatDiskSize(lv.Size), lv.Mountpoint, lv.FSType, lv.Name)
+		}
+		for _, subvol := range pp.Subvolumes {
+			fmt.Fprintf(tw, "\t  subvol\t\t\t\t%s\t\t%s\t\n", subvol.Mountpoint, subvol.Name)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Disk: %s (%d bytes), %s partition table, %d byte sectors\n", formatDiskSize(plan.Size), plan.Size, plan.Type, plan.SectorSize)
+	// every cell is tab terminated to keep the columns aligned, drop the
+	// resulting padding at the end of the lines
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " \n")); err != nil {
+			return err
+		}
+	}
+	for _, warning := range plan.Warnings {
+		if _, err := fmt.Fprintf(w, "WARNING: %s\n", warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the plan rendered as a table (see WriteTable).
+func (plan *DiskLayoutPlan) String() string {
+	var sb strings.Builder
+	_ = plan.WriteTable(&sb)
+	return sb.String()
+}
+
+func alignUpTo(size, alignment uint64) uint64 {
+	if size%alignment == 0 {
+		return size
+	}
+	return (size/alignment + 1) * alignment
+}
+
+// formatDiskSize formats a size using the largest binary unit that represents
+// it exactly.
+func formatDiskSize(size uint64) string {
+	units := []struct {
+		name string
+		size uint64
+	}{
+		{"TiB", datasizes.TiB},
+		{"GiB", datasizes.GiB},
+		{"MiB", datasizes.MiB},
+		{"KiB", datasizes.KiB},
+	}
+	for _, unit := range units {
+		if size >= unit.size && size%unit.size == 0 {
+			return fmt.Sprintf("%d %s", size/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d B", size)
+}
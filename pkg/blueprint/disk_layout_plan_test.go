@@ -0,0 +1,427 @@
+package blueprint_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/datasizes"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+)
+
+// size of the primary and backup GPT header with 128 partition entries on
+// 512 byte sectors
+const gptHeaderSize = 512 + 128*128
+
+func testLVMDisk(minsize uint64) *blueprint.DiskCustomization {
+	return &blueprint.DiskCustomization{
+		MinSize: minsize,
+		Partitions: []blueprint.PartitionCustomization{
+			{
+				MinSize: 500 * datasizes.MiB,
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					Mountpoint: "/boot",
+					FSType:     "xfs",
+				},
+			},
+			{
+				Type:    "lvm",
+				MinSize: 1 * datasizes.GiB,
+				VGCustomization: blueprint.VGCustomization{
+					Name: "rootvg",
+					LogicalVolumes: []blueprint.LVCustomization{
+						{
+							Name:    "rootlv",
+							MinSize: 2 * datasizes.GiB,
+							FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+								Mountpoint: "/",
+								FSType:     "xfs",
+							},
+						},
+						{
+							Name:    "homelv",
+							MinSize: 1*datasizes.GiB + 1,
+							FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+								Mountpoint: "/home",
+								FSType:     "ext4",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testLVMPlan(size uint64, warnings ...string) *blueprint.DiskLayoutPlan {
+	minsize := size
+	if len(warnings) > 0 {
+		minsize = 1 * datasizes.GiB
+	}
+	if size == 0 {
+		size = 3579 * datasizes.MiB
+	}
+	return &blueprint.DiskLayoutPlan{
+		Type:         "gpt",
+		SectorSize:   512,
+		MinSize:      minsize,
+		RequiredSize: 3579 * datasizes.MiB,
+		Size:         size,
+		Partitions: []blueprint.PartitionPlan{
+			{
+				Number:     1,
+				Type:       "plain",
+				Mountpoint: "/boot",
+				FSType:     "xfs",
+				MinSize:    500 * datasizes.MiB,
+				Start:      1 * datasizes.MiB,
+				End:        501 * datasizes.MiB,
+				Size:       500 * datasizes.MiB,
+			},
+			{
+				Number:       2,
+				Type:         "lvm",
+				MinSize:      1 * datasizes.GiB,
+				Start:        501 * datasizes.MiB,
+				End:          size - gptHeaderSize,
+				Size:         size - gptHeaderSize - 501*datasizes.MiB,
+				Root:         true,
+				VGName:       "rootvg",
+				MetadataSize: 1 * datasizes.MiB,
+				LogicalVolumes: []blueprint.LogicalVolumePlan{
+					{
+						Name:       "rootlv",
+						Mountpoint: "/",
+						FSType:     "xfs",
+						MinSize:    2 * datasizes.GiB,
+						Size:       2 * datasizes.GiB,
+					},
+					{
+						Name:       "homelv",
+						Mountpoint: "/home",
+						FSType:     "ext4",
+						MinSize:    1*datasizes.GiB + 1,
+						Size:       1*datasizes.GiB + 4*datasizes.MiB,
+					},
+				},
+			},
+		},
+		Warnings: warnings,
+	}
+}
+
+func TestDiskCustomizationPlan(t *testing.T) {
+	type testCase struct {
+		disk     *blueprint.DiskCustomization
+		expected *blueprint.DiskLayoutPlan
+	}
+
+	testCases := map[string]testCase{
+		"nil": {
+			disk:     nil,
+			expected: nil,
+		},
+		"lvm": {
+			disk:     testLVMDisk(0),
+			expected: testLVMPlan(0),
+		},
+		"lvm-grow-root": {
+			disk:     testLVMDisk(10 * datasizes.GiB),
+			expected: testLVMPlan(10 * datasizes.GiB),
+		},
+		"lvm-minsize-too-small": {
+			disk:     testLVMDisk(1 * datasizes.GiB),
+			expected: testLVMPlan(0, "disk minsize 1 GiB is too small for its partitions: the disk will be grown to 3579 MiB"),
+		},
+		"dos-offset-4k-sectors": {
+			disk: &blueprint.DiskCustomization{
+				Type:        "dos",
+				StartOffset: 8 * datasizes.MiB,
+				SectorSize:  4096,
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						MinSize: 1 * datasizes.GiB,
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							Mountpoint: "/",
+							FSType:     "ext4",
+						},
+					},
+					{
+						MinSize:  100*datasizes.MiB + 1,
+						PartType: "linux",
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							Mountpoint: "/data",
+							FSType:     "ext4",
+						},
+					},
+				},
+			},
+			expected: &blueprint.DiskLayoutPlan{
+				Type:         "dos",
+				SectorSize:   4096,
+				StartOffset:  8 * datasizes.MiB,
+				RequiredSize: 1134 * datasizes.MiB,
+				Size:         1134 * datasizes.MiB,
+				Partitions: []blueprint.PartitionPlan{
+					{
+						Number:     1,
+						Type:       "plain",
+						Mountpoint: "/",
+						FSType:     "ext4",
+						MinSize:    1 * datasizes.GiB,
+						Start:      110 * datasizes.MiB,
+						End:        1134 * datasizes.MiB,
+						Size:       1 * datasizes.GiB,
+						Root:       true,
+					},
+					{
+						Number:     2,
+						Type:       "plain",
+						PartType:   "83",
+						Mountpoint: "/data",
+						FSType:     "ext4",
+						MinSize:    100*datasizes.MiB + 1,
+						Start:      9 * datasizes.MiB,
+						End:        110 * datasizes.MiB,
+						Size:       101 * datasizes.MiB,
+					},
+				},
+			},
+		},
+		"btrfs": {
+			disk: &blueprint.DiskCustomization{
+				MinSize: 4 * datasizes.GiB,
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						Type:    "btrfs",
+						MinSize: 2 * datasizes.GiB,
+						BtrfsVolumeCustomization: blueprint.BtrfsVolumeCustomization{
+							Subvolumes: []blueprint.BtrfsSubvolumeCustomization{
+								{Name: "root", Mountpoint: "/"},
+								{Name: "home", Mountpoint: "/home"},
+							},
+						},
+					},
+				},
+			},
+			expected: &blueprint.DiskLayoutPlan{
+				Type:         "gpt",
+				SectorSize:   512,
+				MinSize:      4 * datasizes.GiB,
+				RequiredSize: 2050 * datasizes.MiB,
+				Size:         4 * datasizes.GiB,
+				Partitions: []blueprint.PartitionPlan{
+					{
+						Number:  1,
+						Type:    "btrfs",
+						MinSize: 2 * datasizes.GiB,
+						Start:   1 * datasizes.MiB,
+						End:     4*datasizes.GiB - gptHeaderSize,
+						Size:    4*datasizes.GiB - gptHeaderSize - 1*datasizes.MiB,
+						Root:    true,
+						Subvolumes: []blueprint.BtrfsSubvolumeCustomization{
+							{Name: "root", Mountpoint: "/"},
+							{Name: "home", Mountpoint: "/home"},
+						},
+					},
+				},
+			},
+		},
+		"no-root": {
+			disk: &blueprint.DiskCustomization{
+				MinSize: 2 * datasizes.GiB,
+				Partitions: []blueprint.PartitionCustomization{
+					{
+						MinSize: 1 * datasizes.GiB,
+						FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+							Mountpoint: "/data",
+							FSType:     "xfs",
+						},
+					},
+				},
+			},
+			expected: &blueprint.DiskLayoutPlan{
+				Type:         "gpt",
+				SectorSize:   512,
+				MinSize:      2 * datasizes.GiB,
+				RequiredSize: 1026 * datasizes.MiB,
+				Size:         2 * datasizes.GiB,
+				Partitions: []blueprint.PartitionPlan{
+					{
+						Number:     1,
+						Type:       "plain",
+						Mountpoint: "/data",
+						FSType:     "xfs",
+						MinSize:    1 * datasizes.GiB,
+						Start:      1 * datasizes.MiB,
+						End:        1025 * datasizes.MiB,
+						Size:       1 * datasizes.GiB,
+					},
+				},
+				Warnings: []string{
+					"no partition contains the root filesystem: the remaining space on the disk is left unallocated",
+				},
+			},
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			plan, err := tc.disk.Plan()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, plan)
+		})
+	}
+}
+
+func TestDiskCustomizationPlanEncrypted(t *testing.T) {
+	disk := &blueprint.DiskCustomization{
+		Partitions: []blueprint.PartitionCustomization{
+			{
+				MinSize: 1 * datasizes.GiB,
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					Mountpoint: "/boot",
+					FSType:     "xfs",
+				},
+			},
+			{
+				MinSize: 1 * datasizes.GiB,
+				Encryption: &blueprint.LUKSCustomization{
+					Version:    "luks1",
+					Passphrase: "env:LUKS_PASSPHRASE",
+				},
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					Mountpoint: "/home",
+					FSType:     "xfs",
+				},
+			},
+			{
+				Type:    "btrfs",
+				MinSize: 1 * datasizes.GiB,
+				Encryption: &blueprint.LUKSCustomization{
+					Passphrase: "env:LUKS_PASSPHRASE",
+				},
+				BtrfsVolumeCustomization: blueprint.BtrfsVolumeCustomization{
+					Subvolumes: []blueprint.BtrfsSubvolumeCustomization{
+						{Name: "srv", Mountpoint: "/srv"},
+					},
+				},
+			},
+			{
+				Type:    "lvm",
+				MinSize: 1 * datasizes.GiB,
+				Encryption: &blueprint.LUKSCustomization{
+					Passphrase: "env:LUKS_PASSPHRASE",
+				},
+				VGCustomization: blueprint.VGCustomization{
+					LogicalVolumes: []blueprint.LVCustomization{
+						{
+							Name:    "rootlv",
+							MinSize: 2 * datasizes.GiB,
+							FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+								Mountpoint: "/",
+								FSType:     "xfs",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	plan, err := disk.Plan()
+	require.NoError(t, err)
+	require.Len(t, plan.Partitions, 4)
+
+	// the LUKS header is added on top of the minimum size for every
+	// payload type: LUKS1 header + plain filesystem
+	plain := plan.Partitions[1]
+	assert.Equal(t, uint64(2*datasizes.MiB), plain.LUKSHeaderSize)
+	assert.Equal(t, uint64(1025*datasizes.MiB), plain.Start)
+	assert.Equal(t, uint64(1026*datasizes.MiB), plain.Size)
+
+	// LUKS2 header + btrfs volume
+	btrfs := plan.Partitions[2]
+	assert.Equal(t, uint64(16*datasizes.MiB), btrfs.LUKSHeaderSize)
+	assert.Equal(t, uint64(2051*datasizes.MiB), btrfs.Start)
+	assert.Equal(t, uint64(1040*datasizes.MiB), btrfs.Size)
+
+	// LUKS2 header + LVM metadata + logical volume
+	lvm := plan.Partitions[3]
+	assert.Equal(t, uint64(16*datasizes.MiB), lvm.LUKSHeaderSize)
+	assert.Equal(t, uint64(3091*datasizes.MiB), lvm.Start)
+	assert.Equal(t, uint64(5157*datasizes.MiB), plan.RequiredSize)
+	assert.Equal(t, plan.Size-gptHeaderSize, lvm.End)
+	assert.Contains(t, plan.String(), "2  plain+luks")
+	assert.Contains(t, plan.String(), "3  btrfs+luks")
+	assert.Contains(t, plan.String(), "4  lvm+luks")
+}
+
+func TestDiskCustomizationPlanInvalid(t *testing.T) {
+	disk := &blueprint.DiskCustomization{
+		Type: "gpt",
+		Partitions: []blueprint.PartitionCustomization{
+			{
+				MinSize: 1 * datasizes.GiB,
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					Mountpoint: "/boot",
+					FSType:     "vfat",
+				},
+			},
+		},
+	}
+	_, err := disk.Plan()
+	assert.EqualError(t, err, "invalid partitioning customizations:\nunsupported filesystem type for \"/boot\": vfat")
+}
+
+func TestCustomizationsPlanDiskLayout(t *testing.T) {
+	var c *blueprint.Customizations
+	plan, err := c.PlanDiskLayout()
+	require.NoError(t, err)
+	assert.Nil(t, plan)
+
+	c = &blueprint.Customizations{
+		Disk: testLVMDisk(1 * datasizes.GiB),
+		Filesystem: []blueprint.FilesystemCustomization{
+			{Mountpoint: "/", MinSize: 5*datasizes.GiB + 1},
+		},
+	}
+	plan, err = c.PlanDiskLayout()
+	require.NoError(t, err)
+	// the filesystem min size is rounded up to the sector size and then
+	// aligned to the next MiB
+	assert.Equal(t, uint64(5*datasizes.GiB+512), plan.MinSize)
+	assert.Equal(t, uint64(5*datasizes.GiB+1*datasizes.MiB), plan.Size)
+	assert.Empty(t, plan.Warnings)
+}
+
+func TestDiskLayoutPlanRender(t *testing.T) {
+	plan, err := testLVMDisk(1 * datasizes.GiB).Plan()
+	require.NoError(t, err)
+
+	expectedTable := `Disk: 3579 MiB (3752853504 bytes), gpt partition table, 512 byte sectors
+#  TYPE   START      END         SIZE          MOUNTPOINT  FSTYPE  NAME
+1  plain  1048576    525336576   500 MiB       /boot       xfs
+2  lvm    525336576  3752836608  3227500032 B                      rootvg
+     lv                          2 GiB         /           xfs     rootlv
+     lv                          1028 MiB      /home       ext4    homelv
+WARNING: disk minsize 1 GiB is too small for its partitions: the disk will be grown to 3579 MiB
+`
+	assert.Equal(t, expectedTable, plan.String())
+
+	data, err := json.Marshal(plan)
+	require.NoError(t, err)
+	var decoded blueprint.DiskLayoutPlan
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, plan, &decoded)
+
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "gpt", fields["type"])
+	assert.Equal(t, float64(3579*datasizes.MiB), fields["size"])
+	assert.Len(t, fields["partitions"], 2)
+}
@@ -0,0 +1,177 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type DNFCustomization struct {
+	Config *DNFConfigCustomization `json:"config,omitempty" toml:"config,omitempty"`
+}
+
+// DNFConfigCustomization holds the dnf.conf(5) options that affect the
+// content of the built image.
+type DNFConfigCustomization struct {
+	SetReleaseVer bool `json:"set_releasever,omitempty" toml:"set_releasever,omitempty"`
+
+	// Package name globs that are never installed
+	Exclude []string `json:"exclude,omitempty" toml:"exclude,omitempty"`
+
+	// Install packages that are only weak dependencies (Recommends:)
+	InstallWeakDeps *bool `json:"install_weak_deps,omitempty" toml:"install_weak_deps,omitempty"`
+
+	// Number of installonly packages (e.g. kernels) kept installed, 0 for
+	// no limit or at least 2
+	InstallOnlyLimit *int `json:"installonly_limit,omitempty" toml:"installonly_limit,omitempty"`
+
+	// RPM transaction flags, e.g. "nodocs"
+	TSFlags []string `json:"tsflags,omitempty" toml:"tsflags,omitempty"`
+
+	// URL of the proxy server, e.g. "http://proxy.example.com:3128"
+	Proxy         string `json:"proxy,omitempty" toml:"proxy,omitempty"`
+	ProxyUsername string `json:"proxy_username,omitempty" toml:"proxy_username,omitempty"`
+	ProxyPassword string `json:"proxy_password,omitempty" toml:"proxy_password,omitempty"`
+
+	// Number of parallel downloads, between 1 and 20
+	MaxParallelDownloads *int `json:"max_parallel_downloads,omitempty" toml:"max_parallel_downloads,omitempty"`
+
+	// Languages whose translations are installed, set as the RPM macro
+	// %_install_langs, e.g. ["en_US", "de_DE"] or ["all"]
+	InstallLangs []string `json:"install_langs,omitempty" toml:"install_langs,omitempty"`
+}
+
+// DNFInstallLangsMacroPath is the RPM macro file setting %_install_langs
+// in the image.
+const DNFInstallLangsMacroPath = "/etc/rpm/macros.image-language-conf"
+
+const (
+	dnfMaxParallelDownloads = 20
+	// an installonly_limit of 1 would remove the running kernel
+	dnfMinInstallOnlyLimit = 2
+)
+
+var dnfTSFlags = []string{"justdb", "nocaps", "nocontexts", "nocrypto", "nodocs", "noscripts", "notriggers", "test"}
+
+var dnfProxySchemes = []string{"http", "https", "socks4", "socks4a", "socks5", "socks5h"}
+
+// Validate checks the option values: the proxy must be a URL with a scheme
+// supported by librepo, numeric options must be within the bounds accepted
+// by dnf and the transaction flags and languages must be known.
+func (d *DNFCustomization) Validate() error {
+	if d == nil || d.Config == nil {
+		return nil
+	}
+	c := d.Config
+
+	var errs []error
+	for _, exclude := range c.Exclude {
+		if exclude == "" || strings.ContainsAny(exclude, " \t\n,") {
+			errs = append(errs, fmt.Errorf("invalid exclude %q: must be a single package name or glob", exclude))
+		}
+	}
+
+	if c.InstallOnlyLimit != nil && *c.InstallOnlyLimit != 0 && *c.InstallOnlyLimit < dnfMinInstallOnlyLimit {
+		errs = append(errs, fmt.Errorf("installonly_limit must be 0 (no limit) or at least %d, got %d", dnfMinInstallOnlyLimit, *c.InstallOnlyLimit))
+	}
+
+	for _, flag := range c.TSFlags {
+		if !slices.Contains(dnfTSFlags, flag) {
+			errs = append(errs, fmt.Errorf("invalid tsflag %q (valid: %s)", flag, strings.Join(dnfTSFlags, ", ")))
+		}
+	}
+
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		switch {
+		case err != nil || u.Host == "":
+			errs = append(errs, fmt.Errorf("invalid proxy %q: must be a URL such as http://proxy.example.com:3128", c.Proxy))
+		case !slices.Contains(dnfProxySchemes, u.Scheme):
+			errs = append(errs, fmt.Errorf("invalid proxy %q: unsupported scheme %q (valid: %s)", c.Proxy, u.Scheme, strings.Join(dnfProxySchemes, ", ")))
+		case u.User != nil:
+			errs = append(errs, fmt.Errorf("invalid proxy %q: use proxy_username and proxy_password for credentials", u.Redacted()))
+		}
+	} else if c.ProxyUsername != "" || c.ProxyPassword != "" {
+		errs = append(errs, fmt.Errorf("proxy_username and proxy_password require a proxy"))
+	}
+	if c.ProxyPassword != "" && c.ProxyUsername == "" {
+		errs = append(errs, fmt.Errorf("proxy_password requires a proxy_username"))
+	}
+	// both are written to dnf.conf as they are, a line break would add
+	// options
+	if strings.ContainsAny(c.ProxyUsername, "\r\n") {
+		errs = append(errs, fmt.Errorf("invalid proxy_username %q: must not contain line breaks", c.ProxyUsername))
+	}
+	if strings.ContainsAny(c.ProxyPassword, "\r\n") {
+		errs = append(errs, fmt.Errorf("invalid proxy_password: must not contain line breaks"))
+	}
+
+	if c.MaxParallelDownloads != nil && (*c.MaxParallelDownloads < 1 || *c.MaxParallelDownloads > dnfMaxParallelDownloads) {
+		errs = append(errs, fmt.Errorf("max_parallel_downloads must be between 1 and %d, got %d", dnfMaxParallelDownloads, *c.MaxParallelDownloads))
+	}
+
+	for _, lang := range c.InstallLangs {
+		if lang == "all" {
+			if len(c.InstallLangs) > 1 {
+				errs = append(errs, fmt.Errorf("install_langs \"all\" cannot be combined with other languages"))
+			}
+			continue
+		}
+		if err := validateLocale(lang); err != nil {
+			errs = append(errs, fmt.Errorf("invalid install_langs: %w", err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid dnf customizations:\n%w", err)
+	}
+	return nil
+}
+
+// DNFConf renders the options as the [main] section of dnf.conf. Options
+// that are not set are omitted so the defaults of the distribution apply.
+func (c *DNFConfigCustomization) DNFConf() string {
+	var b strings.Builder
+	b.WriteString("[main]\n")
+	if c == nil {
+		return b.String()
+	}
+
+	if len(c.Exclude) > 0 {
+		fmt.Fprintf(&b, "exclude=%s\n", strings.Join(c.Exclude, " "))
+	}
+	if c.InstallWeakDeps != nil {
+		fmt.Fprintf(&b, "install_weak_deps=%s\n", strconv.FormatBool(*c.InstallWeakDeps))
+	}
+	if c.InstallOnlyLimit != nil {
+		fmt.Fprintf(&b, "installonly_limit=%d\n", *c.InstallOnlyLimit)
+	}
+	if len(c.TSFlags) > 0 {
+		fmt.Fprintf(&b, "tsflags=%s\n", strings.Join(c.TSFlags, ","))
+	}
+	if c.Proxy != "" {
+		fmt.Fprintf(&b, "proxy=%s\n", c.Proxy)
+	}
+	if c.ProxyUsername != "" {
+		fmt.Fprintf(&b, "proxy_username=%s\n", c.ProxyUsername)
+	}
+	if c.ProxyPassword != "" {
+		fmt.Fprintf(&b, "proxy_password=%s\n", c.ProxyPassword)
+	}
+	if c.MaxParallelDownloads != nil {
+		fmt.Fprintf(&b, "max_parallel_downloads=%d\n", *c.MaxParallelDownloads)
+	}
+	return b.String()
+}
+
+// RPMMacros renders the RPM macros for the options that dnf does not
+// handle itself. It returns an empty string if there are none.
+func (c *DNFConfigCustomization) RPMMacros() string {
+	if c == nil || len(c.InstallLangs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%%_install_langs %s\n", strings.Join(c.InstallLangs, ":"))
+}
@@ -0,0 +1,334 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// FileTemplateContext is the read-only view of the blueprint that file
+// templates are rendered against, e.g.
+//
+//	server {{ index .NTPServers 0 }} iburst
+//	AllowUsers {{ range .Users }}{{ .Name }} {{ end }}
+//
+// It holds copies of the values, templates cannot modify the blueprint.
+// Passwords and keys are not included.
+type FileTemplateContext struct {
+	Name        string
+	Description string
+	Version     string
+	Distro      string
+	Arch        string
+
+	// Names of the packages
+	Packages []string
+
+	Hostname   string
+	Timezone   string
+	NTPServers []string
+	Languages  []string
+	Keyboard   string
+
+	Users  []FileTemplateUser
+	Groups []string
+}
+
+// FileTemplateUser is a user of the blueprint in a FileTemplateContext.
+type FileTemplateUser struct {
+	Name        string
+	Description string
+	Home        string
+	Shell       string
+	Groups      []string
+}
+
+// fileTemplateMaxSteps limits the work of a template: every range iteration
+// and every template call is a step. Together with the size limit of the
+// strings the functions return, this bounds the time and memory used to
+// render a template, e.g. {{ range 300000000 }}{{ end }} fails quickly
+// instead of running for seconds without writing anything.
+const fileTemplateMaxSteps = 100000
+
+// fileTemplateStepFunc is called by the action inserted at the start of
+// every range iteration and before every template call, it is not available
+// to the templates themselves.
+const fileTemplateStepFunc = "templateStep"
+
+var fileTemplateStepNode = template.Must(template.New("step").
+	Funcs(template.FuncMap{fileTemplateStepFunc: func() string { return "" }}).
+	Parse("{{" + fileTemplateStepFunc + "}}")).Tree.Root.Nodes[0]
+
+// fileTemplateFuncs are the functions available in file templates in
+// addition to the builtins of text/template. Functions that can return
+// strings larger than their arguments, including the builtins print,
+// printf, println, html, js and urlquery, fail if the result exceeds
+// MaxFileDataSize, so repeating them in a loop cannot exhaust the memory.
+var fileTemplateFuncs = template.FuncMap{
+	"join":      templateJoin,
+	"lower":     limitTemplateFunc(strings.ToLower),
+	"upper":     limitTemplateFunc(strings.ToUpper),
+	"trimSpace": strings.TrimSpace,
+	"replace":   templateReplace,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"quote":     limitTemplateFunc(strconv.Quote),
+
+	"print":    limitTemplatePrint(fmt.Sprint),
+	"println":  limitTemplatePrint(fmt.Sprintln),
+	"printf":   templatePrintf,
+	"html":     limitTemplatePrint(template.HTMLEscaper),
+	"js":       limitTemplatePrint(template.JSEscaper),
+	"urlquery": limitTemplatePrint(template.URLQueryEscaper),
+}
+
+func checkTemplateResultSize(size int) error {
+	if size > MaxFileDataSize {
+		return fmt.Errorf("result exceeds the maximum size of %d bytes", MaxFileDataSize)
+	}
+	return nil
+}
+
+// limitTemplateFunc wraps f to fail if its result is too large.
+func limitTemplateFunc[T any](f func(T) string) func(T) (string, error) {
+	return func(arg T) (string, error) {
+		result := f(arg)
+		if err := checkTemplateResultSize(len(result)); err != nil {
+			return "", err
+		}
+		return result, nil
+	}
+}
+
+// limitTemplatePrint is limitTemplateFunc for the functions that format
+// any number of arguments.
+func limitTemplatePrint(f func(...any) string) func(...any) (string, error) {
+	return func(args ...any) (string, error) {
+		result := f(args...)
+		if err := checkTemplateResultSize(len(result)); err != nil {
+			return "", err
+		}
+		return result, nil
+	}
+}
+
+// templateJoin is strings.Join that checks the size before joining, a
+// large separator repeated for many elements can be huge.
+func templateJoin(elems []string, sep string) (string, error) {
+	size := len(sep) * max(len(elems)-1, 0)
+	for _, elem := range elems {
+		size += len(elem)
+	}
+	if err := checkTemplateResultSize(size); err != nil {
+		return "", err
+	}
+	return strings.Join(elems, sep), nil
+}
+
+// templateReplace is strings.ReplaceAll that checks the size before
+// replacing.
+func templateReplace(s, old, new string) (string, error) {
+	size := len(s)
+	if old == "" {
+		// new is inserted before every rune and at the end
+		size += (len([]rune(s)) + 1) * len(new)
+	} else {
+		size += strings.Count(s, old) * (len(new) - len(old))
+	}
+	if err := checkTemplateResultSize(size); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(s, old, new), nil
+}
+
+// widths and precisions given as arguments or with more than 4 digits
+var largePrintfWidth = regexp.MustCompile(`%[^a-zA-Z%]*?(\*|[0-9]{5,})`)
+
+// templatePrintf is fmt.Sprintf without large widths and precisions, which
+// would allocate a huge string in a single call.
+func templatePrintf(format string, args ...any) (string, error) {
+	if largePrintfWidth.MatchString(format) {
+		return "", fmt.Errorf("printf widths and precisions must be at most 4 digits")
+	}
+	result := fmt.Sprintf(format, args...)
+	if err := checkTemplateResultSize(len(result)); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// addTemplateSteps inserts the step action at the start of every range
+// body and before every template call in the list.
+func addTemplateSteps(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	nodes := make([]parse.Node, 0, len(list.Nodes))
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.IfNode:
+			addTemplateSteps(n.List)
+			addTemplateSteps(n.ElseList)
+		case *parse.WithNode:
+			addTemplateSteps(n.List)
+			addTemplateSteps(n.ElseList)
+		case *parse.RangeNode:
+			addTemplateSteps(n.List)
+			addTemplateSteps(n.ElseList)
+			n.List.Nodes = append([]parse.Node{fileTemplateStepNode}, n.List.Nodes...)
+		case *parse.TemplateNode:
+			nodes = append(nodes, fileTemplateStepNode)
+		}
+		nodes = append(nodes, node)
+	}
+	list.Nodes = nodes
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// NewFileTemplateContext returns the template context for the blueprint.
+func NewFileTemplateContext(b *Blueprint) *FileTemplateContext {
+	ctx := &FileTemplateContext{
+		Name:        b.Name,
+		Description: b.Description,
+		Version:     b.Version,
+		Distro:      b.Distro,
+		Arch:        b.Arch,
+	}
+	for _, pkg := range b.Packages {
+		ctx.Packages = append(ctx.Packages, pkg.Name)
+	}
+
+	c := b.Customizations
+	if c == nil {
+		return ctx
+	}
+	ctx.Hostname = derefString(c.Hostname)
+	if c.Timezone != nil {
+		ctx.Timezone = derefString(c.Timezone.Timezone)
+		ctx.NTPServers = append(ctx.NTPServers, c.Timezone.NTPServers...)
+	}
+	if c.Locale != nil {
+		ctx.Languages = append(ctx.Languages, c.Locale.Languages...)
+		ctx.Keyboard = derefString(c.Locale.Keyboard)
+	}
+	for _, user := range c.User {
+		ctx.Users = append(ctx.Users, FileTemplateUser{
+			Name:        user.Name,
+			Description: derefString(user.Description),
+			Home:        derefString(user.Home),
+			Shell:       derefString(user.Shell),
+			Groups:      append([]string(nil), user.Groups...),
+		})
+	}
+	for _, group := range c.Group {
+		ctx.Groups = append(ctx.Groups, group.Name)
+	}
+	return ctx
+}
+
+// parseTemplate parses Data as a template. Templates cannot be combined
+// with encoded data, URIs or checksums.
+func (f FileCustomization) parseTemplate() (*template.Template, error) {
+	switch {
+	case f.URI != "":
+		return nil, fmt.Errorf("file %q: template cannot be used with a URI", f.Path)
+	case f.Encoding != "":
+		return nil, fmt.Errorf("file %q: template cannot be used with encoded data", f.Path)
+	case f.Checksum != "":
+		return nil, fmt.Errorf("file %q: template cannot be used with a checksum", f.Path)
+	}
+
+	tmpl, err := template.New(f.Path).Funcs(fileTemplateFuncs).Option("missingkey=error").Parse(f.Data)
+	if err != nil {
+		return nil, fmt.Errorf("file %q: invalid template: %w", f.Path, err)
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			addTemplateSteps(t.Tree.Root)
+		}
+	}
+	return tmpl, nil
+}
+
+// limitedBuilder is a strings.Builder that fails once its content exceeds
+// the maximum size. It only limits the output, the work done to render a
+// template is limited by fileTemplateMaxSteps.
+type limitedBuilder struct {
+	strings.Builder
+	max int
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, fmt.Errorf("rendered data exceeds the maximum size of %d bytes", b.max)
+	}
+	return b.Builder.Write(p)
+}
+
+// Render returns the file with Data rendered against the context. Files
+// that are not templates are returned unchanged.
+func (f FileCustomization) Render(ctx *FileTemplateContext) (FileCustomization, error) {
+	if !f.Template {
+		return f, nil
+	}
+	tmpl, err := f.parseTemplate()
+	if err != nil {
+		return f, err
+	}
+
+	steps := 0
+	tmpl.Funcs(template.FuncMap{fileTemplateStepFunc: func() (string, error) {
+		steps++
+		if steps > fileTemplateMaxSteps {
+			return "", fmt.Errorf("more than %d loop iterations and template calls", fileTemplateMaxSteps)
+		}
+		return "", nil
+	}})
+
+	out := &limitedBuilder{max: MaxFileDataSize}
+	if err := tmpl.Execute(out, ctx); err != nil {
+		return f, fmt.Errorf("file %q: cannot render template: %w", f.Path, err)
+	}
+
+	f.Data = out.String()
+	f.Template = false
+	return f, nil
+}
+
+// RenderFileTemplates returns the File customizations of the blueprint
+// with all templates rendered. The blueprint is not modified, consumers call
+// it when converting the File customizations.
+func (b *Blueprint) RenderFileTemplates() ([]FileCustomization, error) {
+	files := b.Customizations.GetFiles()
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	ctx := NewFileTemplateContext(b)
+	rendered := make([]FileCustomization, 0, len(files))
+	var errs []error
+	for _, file := range files {
+		file, err := file.Render(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rendered = append(rendered, file)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid file templates:\n%w", err)
+	}
+	return rendered, nil
+}
@@ -0,0 +1,216 @@
+package blueprint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestRenderFileTemplates(t *testing.T) {
+	bp := Blueprint{
+		Name:     "web",
+		Packages: []Package{{Name: "httpd"}, {Name: "chrony"}},
+		Customizations: &Customizations{
+			Hostname: common.ToPtr("web01.example.com"),
+			Timezone: &TimezoneCustomization{NTPServers: []string{"0.pool.ntp.org", "1.pool.ntp.org"}},
+			User: []UserCustomization{
+				{Name: "admin", Password: common.ToPtr("secret"), Groups: []string{"wheel"}},
+				{Name: "deploy"},
+			},
+			Files: []FileCustomization{
+				{
+					Path:     "/etc/chrony.d/servers.conf",
+					Data:     "{{ range .NTPServers }}server {{ . }} iburst\n{{ end }}",
+					Template: true,
+				},
+				{
+					Path:     "/etc/motd",
+					Data:     "{{ upper .Hostname }} managed by {{ (index .Users 0).Name }} ({{ join (index .Users 0).Groups \",\" }})\n",
+					Template: true,
+				},
+				{
+					Path: "/etc/plain",
+					Data: "{{ not a template }}",
+				},
+			},
+		},
+	}
+
+	files, err := bp.RenderFileTemplates()
+	require.NoError(t, err)
+	assert.Equal(t, []FileCustomization{
+		{Path: "/etc/chrony.d/servers.conf", Data: "server 0.pool.ntp.org iburst\nserver 1.pool.ntp.org iburst\n"},
+		{Path: "/etc/motd", Data: "WEB01.EXAMPLE.COM managed by admin (wheel)\n"},
+		{Path: "/etc/plain", Data: "{{ not a template }}"},
+	}, files)
+	// the blueprint is not modified
+	assert.True(t, bp.Customizations.Files[0].Template)
+
+	_, err = FileCustomizationsToFsNodeFiles(files)
+	assert.NoError(t, err)
+
+	// templates must be rendered before converting them
+	_, err = bp.Customizations.Files[0].ToFsNodeFile()
+	assert.EqualError(t, err, `file "/etc/chrony.d/servers.conf" is a template that must be rendered first, see Blueprint.RenderFileTemplates`)
+
+	files, err = (&Blueprint{}).RenderFileTemplates()
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+}
+
+func TestRenderFileTemplatesErrors(t *testing.T) {
+	bp := Blueprint{
+		Name: "web",
+		Customizations: &Customizations{
+			Files: []FileCustomization{
+				{Path: "/etc/a", Data: "{{ .Password }}", Template: true},
+				{Path: "/etc/b", Data: "{{ index .Users 0 }}", Template: true},
+				{Path: "/etc/c", Data: "{{ exec \"rm\" }}", Template: true},
+				{Path: "/etc/d", Data: "{{ range 20000 }}{{ printf \"%0500d\" 0 }}{{ end }}", Template: true},
+			},
+		},
+	}
+
+	_, err := bp.RenderFileTemplates()
+	assert.EqualError(t, err, `invalid file templates:
+file "/etc/a": cannot render template: template: /etc/a:1:3: executing "/etc/a" at <.Password>: can't evaluate field Password in type *blueprint.FileTemplateContext
+file "/etc/b": cannot render template: template: /etc/b:1:3: executing "/etc/b" at <index .Users 0>: error calling index: reflect: slice index out of range
+file "/etc/c": invalid template: template: /etc/c:1: function "exec" not defined
+file "/etc/d": cannot render template: rendered data exceeds the maximum size of 8388608 bytes`)
+
+	// reported when the blueprint is initialized
+	assert.ErrorContains(t, bp.Initialize(), "invalid file templates")
+}
+
+func TestRenderFileTemplateLimits(t *testing.T) {
+	testCases := map[string]struct {
+		data string
+		err  string
+	}{
+		"range": {
+			data: "{{ range 300000000 }}{{ end }}",
+			err:  "more than 100000 loop iterations and template calls",
+		},
+		"nested-range": {
+			data: "{{ range 1000 }}{{ range 1000 }}{{ end }}{{ end }}",
+			err:  "more than 100000 loop iterations and template calls",
+		},
+		"recursion": {
+			data: `{{ define "a" }}{{ range 2 }}{{ template "a" }}{{ end }}{{ end }}{{ template "a" }}`,
+			err:  "more than 100000 loop iterations and template calls",
+		},
+		"print": {
+			data: `{{ $s := "xx" }}{{ range 40 }}{{ $s = print $s $s }}{{ end }}`,
+			err:  "error calling print: result exceeds the maximum size of 8388608 bytes",
+		},
+		"replace": {
+			data: `{{ $s := "x" }}{{ range 30 }}{{ $s = replace $s "x" "xxxxxxxx" }}{{ end }}`,
+			err:  "error calling replace: result exceeds the maximum size of 8388608 bytes",
+		},
+		"printf-width": {
+			data: `{{ printf "%0999999999d" 1 }}`,
+			err:  "error calling printf: printf widths and precisions must be at most 4 digits",
+		},
+		"printf-star": {
+			data: `{{ printf "%[1]*d" 999999999 1 }}`,
+			err:  "error calling printf: printf widths and precisions must be at most 4 digits",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			file := FileCustomization{Path: "/etc/a", Data: tc.data, Template: true}
+			start := time.Now()
+			_, err := file.Render(&FileTemplateContext{})
+			assert.ErrorContains(t, err, tc.err)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+
+	// small widths and loops within the limits are fine
+	file := FileCustomization{Path: "/etc/a", Data: `{{ range 3 }}{{ printf "%-4s|%5.1f" "a" 1.25 }}{{ end }}`, Template: true}
+	file, err := file.Render(&FileTemplateContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, "a   |  1.2a   |  1.2a   |  1.2", file.Data)
+}
+
+func TestFileTemplateValidation(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		File  FileCustomization
+		Error string
+	}{
+		{
+			Name:  "uri",
+			File:  FileCustomization{Path: "/etc/a", URI: "/tmp/a", Template: true},
+			Error: `file "/etc/a": template cannot be used with a URI`,
+		},
+		{
+			Name:  "encoding",
+			File:  FileCustomization{Path: "/etc/a", Data: "e30=", Encoding: "base64", Template: true},
+			Error: `file "/etc/a": template cannot be used with encoded data`,
+		},
+		{
+			Name:  "checksum",
+			File:  FileCustomization{Path: "/etc/a", Data: "{{ .Name }}", Checksum: "sha256:00", Template: true},
+			Error: `file "/etc/a": template cannot be used with a checksum`,
+		},
+		{
+			Name:  "syntax",
+			File:  FileCustomization{Path: "/etc/a", Data: "{{ .Name ", Template: true},
+			Error: `file "/etc/a": invalid template: template: /etc/a:1: unclosed action`,
+		},
+		{
+			Name:  "invalid-path",
+			File:  FileCustomization{Path: "etc/a", Data: "{{ .Name }}", Template: true},
+			Error: "path must be absolute",
+		},
+		{
+			Name: "valid",
+			File: FileCustomization{Path: "/etc/a", Data: "{{ .Name }}", Template: true, Mode: "0600"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.File.validate()
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	var blueprint Blueprint
+	err := toml.Unmarshal([]byte(`
+name = "test"
+
+[customizations]
+hostname = "web01"
+
+[[customizations.files]]
+path = "/etc/motd"
+data = "Welcome to {{ .Hostname }}"
+template = true
+`), &blueprint)
+	require.NoError(t, err)
+	require.NoError(t, blueprint.Initialize())
+	files, err := blueprint.RenderFileTemplates()
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome to web01", files[0].Data)
+
+	// Initialize keeps the template, a changed hostname is rendered
+	assert.True(t, blueprint.Customizations.Files[0].Template)
+	assert.Equal(t, "Welcome to {{ .Hostname }}", blueprint.Customizations.Files[0].Data)
+	blueprint.Customizations.Hostname = common.ToPtr("web02")
+	require.NoError(t, blueprint.Initialize())
+	files, err = blueprint.RenderFileTemplates()
+	require.NoError(t, err)
+	assert.Equal(t, "Welcome to web02", files[0].Data)
+}
@@ -0,0 +1,631 @@
+package blueprint
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+type FirewallCustomization struct {
+	Ports    []string                       `json:"ports,omitempty" toml:"ports,omitempty"`
+	Services *FirewallServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
+	Zones    []FirewallZoneCustomization    `json:"zones,omitempty" toml:"zones,omitempty"`
+}
+
+// FirewallZoneCustomization describes a firewalld zone. Zones that only set
+// Name and Sources can be handled by the firewall stage directly, all other
+// fields require the zone to be written out as XML, see [FirewallZoneCustomization.ToXML].
+type FirewallZoneCustomization struct {
+	Name    *string  `json:"name,omitempty" toml:"name,omitempty"`
+	Sources []string `json:"sources,omitempty" toml:"sources,omitempty"`
+
+	// Target for packets that do not match any rule of the zone, one of
+	// "default", "ACCEPT", "DROP" or "REJECT".
+	Target string `json:"target,omitempty" toml:"target,omitempty"`
+
+	// Network interfaces bound to the zone
+	Interfaces []string `json:"interfaces,omitempty" toml:"interfaces,omitempty"`
+
+	// Names of firewalld services allowed in the zone
+	Services []string `json:"services,omitempty" toml:"services,omitempty"`
+
+	// Ports allowed in the zone, using the same syntax as FirewallCustomization.Ports
+	Ports []string `json:"ports,omitempty" toml:"ports,omitempty"`
+
+	// Enable IPv4 masquerading for the zone
+	Masquerade *bool `json:"masquerade,omitempty" toml:"masquerade,omitempty"`
+
+	RichRules []FirewallRichRuleCustomization `json:"rich_rules,omitempty" toml:"rich_rules,omitempty"`
+}
+
+// FirewallRichRuleCustomization is a simplified firewalld rich rule that
+// matches on source, destination and either a service or a port.
+type FirewallRichRuleCustomization struct {
+	// Address family, "ipv4" or "ipv6". Required when Source or Destination
+	// is set.
+	Family string `json:"family,omitempty" toml:"family,omitempty"`
+
+	// Source address or network in CIDR notation
+	Source string `json:"source,omitempty" toml:"source,omitempty"`
+
+	// Destination address or network in CIDR notation
+	Destination string `json:"destination,omitempty" toml:"destination,omitempty"`
+
+	// Name of a firewalld service, mutually exclusive with Port
+	Service string `json:"service,omitempty" toml:"service,omitempty"`
+
+	// Port using the same syntax as FirewallCustomization.Ports, mutually
+	// exclusive with Service
+	Port string `json:"port,omitempty" toml:"port,omitempty"`
+
+	// Action for matching packets: "accept", "reject" or "drop" (required)
+	Action string `json:"action" toml:"action"`
+}
+
+type FirewallServicesCustomization struct {
+	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
+}
+
+// FirewallPort is the parsed form of a firewall port string. Port strings
+// have the form "<port>:<protocol>", where <port> is a single port number
+// ("22"), a range of ports ("8000-8080") or a service name as listed in
+// /etc/services ("ssh").
+type FirewallPort struct {
+	// First port of the range. For a single port Start and End are equal.
+	// Both are zero when Service is set.
+	Start uint16
+	End   uint16
+
+	// Service name from /etc/services
+	Service string
+
+	// One of tcp, udp, sctp or dccp
+	Protocol string
+}
+
+// FirewalldServicesDir is the default location of the firewalld service
+// definitions on the build host.
+const FirewalldServicesDir = "/usr/lib/firewalld/services"
+
+var validFirewallProtocols = []string{
+	"tcp",
+	"udp",
+	"sctp",
+	"dccp",
+}
+
+var validFirewallZoneTargets = []string{
+	"default",
+	"ACCEPT",
+	"DROP",
+	"REJECT",
+}
+
+var validFirewallRichRuleActions = []string{
+	"accept",
+	"reject",
+	"drop",
+}
+
+// Service names, both from /etc/services and from firewalld. Purely numeric
+// names are handled as port numbers by ParseFirewallPort. Names start and end
+// with an alphanumeric character (or "_", "." and "+" at the end), so a
+// broken port range like "8000-" is not taken for a service.
+var firewallServiceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_.+-]*[a-zA-Z0-9_.+])?$`)
+
+// Names of only digits and dashes are malformed port ranges, e.g. "80--90".
+var firewallPortRangeFragmentRegex = regexp.MustCompile(`^[0-9-]+$`)
+
+func isFirewallServiceName(name string) bool {
+	return firewallServiceNameRegex.MatchString(name) && !firewallPortRangeFragmentRegex.MatchString(name)
+}
+
+// firewalld limits zone names to 17 characters
+var firewalldZoneNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_+-]{1,17}$`)
+
+// ParseFirewallPort parses a port string as used in
+// FirewallCustomization.Ports into its typed form.
+func ParseFirewallPort(port string) (*FirewallPort, error) {
+	spec, protocol, found := strings.Cut(port, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid firewall port %q: missing protocol (expected <port>:<protocol>)", port)
+	}
+
+	if !slices.Contains(validFirewallProtocols, protocol) {
+		return nil, fmt.Errorf("invalid firewall port %q: unknown protocol %q (valid: %s)", port, protocol, strings.Join(validFirewallProtocols, ", "))
+	}
+
+	if start, end, isRange := strings.Cut(spec, "-"); isRange && isDigits(start) && isDigits(end) {
+		startNum, err := parsePortNumber(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid firewall port %q: %w", port, err)
+		}
+		endNum, err := parsePortNumber(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid firewall port %q: %w", port, err)
+		}
+		if startNum > endNum {
+			return nil, fmt.Errorf("invalid firewall port %q: range start is greater than range end", port)
+		}
+		return &FirewallPort{Start: startNum, End: endNum, Protocol: protocol}, nil
+	}
+
+	if isDigits(spec) {
+		num, err := parsePortNumber(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid firewall port %q: %w", port, err)
+		}
+		return &FirewallPort{Start: num, End: num, Protocol: protocol}, nil
+	}
+
+	if !isFirewallServiceName(spec) {
+		return nil, fmt.Errorf("invalid firewall port %q: %q is neither a port, a port range nor a service name", port, spec)
+	}
+
+	return &FirewallPort{Service: spec, Protocol: protocol}, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func parsePortNumber(s string) (uint16, error) {
+	num, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || num == 0 {
+		return 0, fmt.Errorf("port %s is out of range (1-65535)", s)
+	}
+	return uint16(num), nil
+}
+
+// PortString returns the port part of the firewall port without the
+// protocol, e.g. "22", "8000-8080" or "ssh".
+func (p FirewallPort) PortString() string {
+	switch {
+	case p.Service != "":
+		return p.Service
+	case p.Start == p.End:
+		return strconv.FormatUint(uint64(p.Start), 10)
+	default:
+		return fmt.Sprintf("%d-%d", p.Start, p.End)
+	}
+}
+
+func (p FirewallPort) String() string {
+	return p.PortString() + ":" + p.Protocol
+}
+
+// GetPorts returns the parsed form of all ports in the customization.
+func (f *FirewallCustomization) GetPorts() ([]FirewallPort, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	var ports []FirewallPort
+	for _, p := range f.Ports {
+		port, err := ParseFirewallPort(p)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, *port)
+	}
+	return ports, nil
+}
+
+func validateFirewalldServiceName(service string) error {
+	if !isFirewallServiceName(service) {
+		return fmt.Errorf("invalid firewalld service name %q", service)
+	}
+	return nil
+}
+
+// firewallSource validates a zone source and returns the name of the
+// firewalld XML attribute it belongs to: "address", "mac" or "ipset".
+func firewallSource(source string) (string, error) {
+	if name, ok := strings.CutPrefix(source, "ipset:"); ok {
+		if name == "" {
+			return "", fmt.Errorf("invalid firewall zone source %q: empty ipset name", source)
+		}
+		return "ipset", nil
+	}
+	if net.ParseIP(source) != nil {
+		return "address", nil
+	}
+	if _, _, err := net.ParseCIDR(source); err == nil {
+		return "address", nil
+	}
+	if _, err := net.ParseMAC(source); err == nil {
+		return "mac", nil
+	}
+	return "", fmt.Errorf("invalid firewall zone source %q: must be an IP address, a network in CIDR notation, a MAC address or ipset:<name>", source)
+}
+
+func validateFirewallAddress(address string) error {
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(address); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q: must be an IP address or a network in CIDR notation", address)
+}
+
+func validateInterfaceName(iface string) error {
+	// see dev_valid_name() in the kernel
+	if iface == "" || len(iface) > 15 || iface == "." || iface == ".." || strings.ContainsAny(iface, "/: \t\n") {
+		return fmt.Errorf("invalid network interface name %q", iface)
+	}
+	return nil
+}
+
+// Validate checks that all ports, services and zones of the firewall
+// customization are syntactically valid. It does not check that the named
+// services exist, see [FirewallCustomization.ValidateServices] for that.
+func (f *FirewallCustomization) Validate() error {
+	if f == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, p := range f.Ports {
+		if _, err := ParseFirewallPort(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if f.Services != nil {
+		for _, s := range f.Services.Enabled {
+			errs = append(errs, validateFirewalldServiceName(s))
+			if slices.Contains(f.Services.Disabled, s) {
+				errs = append(errs, fmt.Errorf("firewalld service %q is both enabled and disabled", s))
+			}
+		}
+		for _, s := range f.Services.Disabled {
+			errs = append(errs, validateFirewalldServiceName(s))
+		}
+	}
+
+	zoneNames := make(map[string]bool)
+	for _, z := range f.Zones {
+		if err := z.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if zoneNames[*z.Name] {
+			errs = append(errs, fmt.Errorf("duplicate firewall zone name %q", *z.Name))
+		}
+		zoneNames[*z.Name] = true
+	}
+
+	// will discard all nil errors
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid firewall customizations:\n%w", err)
+	}
+	return nil
+}
+
+// Validate checks that the zone has a valid name and that all of its
+// fields are syntactically valid.
+func (z *FirewallZoneCustomization) Validate() error {
+	if z.Name == nil || *z.Name == "" {
+		return fmt.Errorf("firewall zone name is required")
+	}
+	if !firewalldZoneNameRegex.MatchString(*z.Name) {
+		return fmt.Errorf("invalid firewall zone name %q: must be at most 17 characters long and may contain letters, numbers, _, + and - only", *z.Name)
+	}
+
+	if z.Target != "" && !slices.Contains(validFirewallZoneTargets, z.Target) {
+		return fmt.Errorf("invalid target %q for firewall zone %q (valid: %s)", z.Target, *z.Name, strings.Join(validFirewallZoneTargets, ", "))
+	}
+
+	for _, iface := range z.Interfaces {
+		if err := validateInterfaceName(iface); err != nil {
+			return fmt.Errorf("firewall zone %q: %w", *z.Name, err)
+		}
+	}
+
+	for _, source := range z.Sources {
+		if _, err := firewallSource(source); err != nil {
+			return fmt.Errorf("firewall zone %q: %w", *z.Name, err)
+		}
+	}
+
+	for _, s := range z.Services {
+		if err := validateFirewalldServiceName(s); err != nil {
+			return fmt.Errorf("firewall zone %q: %w", *z.Name, err)
+		}
+	}
+
+	for _, p := range z.Ports {
+		if _, err := ParseFirewallPort(p); err != nil {
+			return fmt.Errorf("firewall zone %q: %w", *z.Name, err)
+		}
+	}
+
+	for idx, rule := range z.RichRules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("firewall zone %q: rich rule #%d: %w", *z.Name, idx+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that the rich rule has a valid action and that its
+// addresses, service and port are valid.
+func (r *FirewallRichRuleCustomization) Validate() error {
+	if !slices.Contains(validFirewallRichRuleActions, r.Action) {
+		return fmt.Errorf("invalid action %q (valid: %s)", r.Action, strings.Join(validFirewallRichRuleActions, ", "))
+	}
+
+	switch r.Family {
+	case "":
+		if r.Source != "" || r.Destination != "" {
+			return fmt.Errorf("family is required when source or destination is set")
+		}
+	case "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("invalid family %q (valid: ipv4, ipv6)", r.Family)
+	}
+
+	if r.Source != "" {
+		if err := validateFirewallAddress(r.Source); err != nil {
+			return err
+		}
+	}
+	if r.Destination != "" {
+		if err := validateFirewallAddress(r.Destination); err != nil {
+			return err
+		}
+	}
+
+	if r.Service != "" && r.Port != "" {
+		return fmt.Errorf("service and port are mutually exclusive")
+	}
+	if r.Service != "" {
+		if err := validateFirewalldServiceName(r.Service); err != nil {
+			return err
+		}
+	}
+	if r.Port != "" {
+		if _, err := ParseFirewallPort(r.Port); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadFirewalldServices returns the names of all firewalld services defined
+// in dir. This is usually [FirewalldServicesDir] or a copy of it taken from
+// the target distribution, so validation can happen offline.
+func ReadFirewalldServices(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read firewalld services: %w", err)
+	}
+
+	var services []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".xml" {
+			continue
+		}
+		services = append(services, strings.TrimSuffix(entry.Name(), ".xml"))
+	}
+	return services, nil
+}
+
+// ValidateServices checks that all firewalld services referenced by the
+// customization are part of the known services, as returned by
+// [ReadFirewalldServices].
+func (f *FirewallCustomization) ValidateServices(known []string) error {
+	if f == nil {
+		return nil
+	}
+
+	var referenced []string
+	if f.Services != nil {
+		referenced = append(referenced, f.Services.Enabled...)
+		referenced = append(referenced, f.Services.Disabled...)
+	}
+	for _, z := range f.Zones {
+		referenced = append(referenced, z.Services...)
+		for _, rule := range z.RichRules {
+			if rule.Service != "" {
+				referenced = append(referenced, rule.Service)
+			}
+		}
+	}
+
+	var unknown []string
+	for _, s := range referenced {
+		if !slices.Contains(known, s) && !slices.Contains(unknown, s) {
+			unknown = append(unknown, s)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown firewalld services: %+q", unknown)
+	}
+	return nil
+}
+
+type firewalldNameXML struct {
+	Name string `xml:"name,attr"`
+}
+
+type firewalldAddressXML struct {
+	Address string `xml:"address,attr,omitempty"`
+	MAC     string `xml:"mac,attr,omitempty"`
+	IPSet   string `xml:"ipset,attr,omitempty"`
+}
+
+type firewalldPortXML struct {
+	Port     string `xml:"port,attr"`
+	Protocol string `xml:"protocol,attr"`
+}
+
+type firewalldRuleXML struct {
+	Family      string               `xml:"family,attr,omitempty"`
+	Source      *firewalldAddressXML `xml:"source"`
+	Destination *firewalldAddressXML `xml:"destination"`
+	Service     *firewalldNameXML    `xml:"service"`
+	Port        *firewalldPortXML    `xml:"port"`
+	Accept      *struct{}            `xml:"accept"`
+	Reject      *struct{}            `xml:"reject"`
+	Drop        *struct{}            `xml:"drop"`
+}
+
+type firewalldZoneXML struct {
+	XMLName    xml.Name              `xml:"zone"`
+	Target     string                `xml:"target,attr,omitempty"`
+	Short      string                `xml:"short"`
+	Interfaces []firewalldNameXML    `xml:"interface"`
+	Sources    []firewalldAddressXML `xml:"source"`
+	Services   []firewalldNameXML    `xml:"service"`
+	Ports      []firewalldPortXML    `xml:"port"`
+	Masquerade *struct{}             `xml:"masquerade"`
+	Rules      []firewalldRuleXML    `xml:"rule"`
+}
+
+// ToXML renders the zone as a firewalld zone configuration file, see
+// firewalld.zone(5).
+func (z FirewallZoneCustomization) ToXML() ([]byte, error) {
+	if err := z.Validate(); err != nil {
+		return nil, err
+	}
+
+	zone := firewalldZoneXML{
+		Short: *z.Name,
+	}
+
+	switch z.Target {
+	case "", "default":
+	case "REJECT":
+		zone.Target = "%%REJECT%%"
+	default:
+		zone.Target = z.Target
+	}
+
+	for _, iface := range z.Interfaces {
+		zone.Interfaces = append(zone.Interfaces, firewalldNameXML{Name: iface})
+	}
+
+	for _, source := range z.Sources {
+		// validated above
+		kind, _ := firewallSource(source)
+		switch kind {
+		case "address":
+			zone.Sources = append(zone.Sources, firewalldAddressXML{Address: source})
+		case "mac":
+			zone.Sources = append(zone.Sources, firewalldAddressXML{MAC: source})
+		case "ipset":
+			zone.Sources = append(zone.Sources, firewalldAddressXML{IPSet: strings.TrimPrefix(source, "ipset:")})
+		}
+	}
+
+	for _, s := range z.Services {
+		zone.Services = append(zone.Services, firewalldNameXML{Name: s})
+	}
+
+	for _, p := range z.Ports {
+		port, _ := ParseFirewallPort(p)
+		zone.Ports = append(zone.Ports, firewalldPortXML{Port: port.PortString(), Protocol: port.Protocol})
+	}
+
+	if z.Masquerade != nil && *z.Masquerade {
+		zone.Masquerade = &struct{}{}
+	}
+
+	for _, r := range z.RichRules {
+		rule := firewalldRuleXML{Family: r.Family}
+		if r.Source != "" {
+			rule.Source = &firewalldAddressXML{Address: r.Source}
+		}
+		if r.Destination != "" {
+			rule.Destination = &firewalldAddressXML{Address: r.Destination}
+		}
+		if r.Service != "" {
+			rule.Service = &firewalldNameXML{Name: r.Service}
+		}
+		if r.Port != "" {
+			port, _ := ParseFirewallPort(r.Port)
+			rule.Port = &firewalldPortXML{Port: port.PortString(), Protocol: port.Protocol}
+		}
+		switch r.Action {
+		case "accept":
+			rule.Accept = &struct{}{}
+		case "reject":
+			rule.Reject = &struct{}{}
+		case "drop":
+			rule.Drop = &struct{}{}
+		}
+		zone.Rules = append(zone.Rules, rule)
+	}
+
+	data, err := xml.MarshalIndent(zone, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot render firewall zone %q: %w", *z.Name, err)
+	}
+
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// ToFsNodeFile renders the zone and returns it as a file in
+// /etc/firewalld/zones.
+func (z FirewallZoneCustomization) ToFsNodeFile() (*fsnode.File, error) {
+	data, err := z.ToXML()
+	if err != nil {
+		return nil, err
+	}
+	return fsnode.NewFile(fmt.Sprintf("/etc/firewalld/zones/%s.xml", *z.Name), nil, nil, nil, data)
+}
+
+// FirewallZoneCustomizationsToFsNodeFiles converts a slice of
+// FirewallZoneCustomization to a slice of *fsnode.File
+func FirewallZoneCustomizationsToFsNodeFiles(zones []FirewallZoneCustomization) ([]*fsnode.File, error) {
+	if len(zones) == 0 {
+		return nil, nil
+	}
+
+	var fsFiles []*fsnode.File
+	var errs []error
+	zoneNames := make(map[string]bool)
+	for _, zone := range zones {
+		fsFile, err := zone.ToFsNodeFile()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// each zone is written to /etc/firewalld/zones/<name>.xml
+		if zoneNames[*zone.Name] {
+			errs = append(errs, fmt.Errorf("duplicate firewall zone name %q", *zone.Name))
+			continue
+		}
+		zoneNames[*zone.Name] = true
+		fsFiles = append(fsFiles, fsFile)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid firewall zone customizations:\n%w", err)
+	}
+
+	return fsFiles, nil
+}
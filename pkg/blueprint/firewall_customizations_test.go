@@ -0,0 +1,451 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestParseFirewallPort(t *testing.T) {
+	testCases := []struct {
+		port     string
+		expected *FirewallPort
+		err      string
+	}{
+		{
+			port:     "22:tcp",
+			expected: &FirewallPort{Start: 22, End: 22, Protocol: "tcp"},
+		},
+		{
+			port:     "8000-8080:udp",
+			expected: &FirewallPort{Start: 8000, End: 8080, Protocol: "udp"},
+		},
+		{
+			port:     "ssh:sctp",
+			expected: &FirewallPort{Service: "ssh", Protocol: "sctp"},
+		},
+		{
+			port:     "rfe-3com:dccp",
+			expected: &FirewallPort{Service: "rfe-3com", Protocol: "dccp"},
+		},
+		{
+			port: "22",
+			err:  `invalid firewall port "22": missing protocol (expected <port>:<protocol>)`,
+		},
+		{
+			port: "22:icmp",
+			err:  `invalid firewall port "22:icmp": unknown protocol "icmp" (valid: tcp, udp, sctp, dccp)`,
+		},
+		{
+			port: "0:tcp",
+			err:  `invalid firewall port "0:tcp": port 0 is out of range (1-65535)`,
+		},
+		{
+			port: "65536:tcp",
+			err:  `invalid firewall port "65536:tcp": port 65536 is out of range (1-65535)`,
+		},
+		{
+			port: "8080-8000:tcp",
+			err:  `invalid firewall port "8080-8000:tcp": range start is greater than range end`,
+		},
+		{
+			port: "ss h:tcp",
+			err:  `invalid firewall port "ss h:tcp": "ss h" is neither a port, a port range nor a service name`,
+		},
+		{
+			port: ":tcp",
+			err:  `invalid firewall port ":tcp": "" is neither a port, a port range nor a service name`,
+		},
+		{
+			port: "8000-:tcp",
+			err:  `invalid firewall port "8000-:tcp": "8000-" is neither a port, a port range nor a service name`,
+		},
+		{
+			port: "-8000:tcp",
+			err:  `invalid firewall port "-8000:tcp": "-8000" is neither a port, a port range nor a service name`,
+		},
+		{
+			port: "80--90:tcp",
+			err:  `invalid firewall port "80--90:tcp": "80--90" is neither a port, a port range nor a service name`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.port, func(t *testing.T) {
+			port, err := ParseFirewallPort(tc.port)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, port)
+			assert.Equal(t, tc.port, port.String())
+		})
+	}
+}
+
+func TestFirewallCustomizationGetPorts(t *testing.T) {
+	var nilFirewall *FirewallCustomization
+	ports, err := nilFirewall.GetPorts()
+	assert.NoError(t, err)
+	assert.Nil(t, ports)
+
+	fw := FirewallCustomization{Ports: []string{"22:tcp", "1000-2000:udp"}}
+	ports, err = fw.GetPorts()
+	assert.NoError(t, err)
+	assert.Equal(t, []FirewallPort{
+		{Start: 22, End: 22, Protocol: "tcp"},
+		{Start: 1000, End: 2000, Protocol: "udp"},
+	}, ports)
+
+	fw.Ports = append(fw.Ports, "22/tcp")
+	_, err = fw.GetPorts()
+	assert.Error(t, err)
+}
+
+func TestFirewallCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		firewall *FirewallCustomization
+		err      string
+	}{
+		"nil": {},
+		"happy": {
+			firewall: &FirewallCustomization{
+				Ports: []string{"22:tcp", "8000-8080:udp", "imap:tcp"},
+				Services: &FirewallServicesCustomization{
+					Enabled:  []string{"cockpit", "RH-Satellite-6"},
+					Disabled: []string{"dhcpv6-client"},
+				},
+				Zones: []FirewallZoneCustomization{
+					{
+						Name:       common.ToPtr("trusted"),
+						Sources:    []string{"192.168.0.0/24", "fe80::1", "00:11:22:33:44:55", "ipset:blocklist"},
+						Target:     "ACCEPT",
+						Interfaces: []string{"eth0"},
+						Services:   []string{"ssh"},
+						Ports:      []string{"9090:tcp"},
+						Masquerade: common.ToPtr(true),
+						RichRules: []FirewallRichRuleCustomization{
+							{Family: "ipv4", Source: "10.0.0.0/8", Service: "http", Action: "accept"},
+						},
+					},
+				},
+			},
+		},
+		"bad-port": {
+			firewall: &FirewallCustomization{
+				Ports: []string{"22:tcp", "22"},
+			},
+			err: "invalid firewall customizations:\n" +
+				`invalid firewall port "22": missing protocol (expected <port>:<protocol>)`,
+		},
+		"bad-service": {
+			firewall: &FirewallCustomization{
+				Services: &FirewallServicesCustomization{
+					Enabled: []string{"-ssh"},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`invalid firewalld service name "-ssh"`,
+		},
+		"enabled-and-disabled": {
+			firewall: &FirewallCustomization{
+				Services: &FirewallServicesCustomization{
+					Enabled:  []string{"ssh"},
+					Disabled: []string{"ssh"},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewalld service "ssh" is both enabled and disabled`,
+		},
+		"zone-no-name": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Sources: []string{"10.0.0.1"}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				"firewall zone name is required",
+		},
+		"zone-name-too-long": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("a-very-long-zone-name")},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`invalid firewall zone name "a-very-long-zone-name": must be at most 17 characters long and may contain letters, numbers, _, + and - only`,
+		},
+		"zone-duplicate": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work")},
+					{Name: common.ToPtr("work")},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`duplicate firewall zone name "work"`,
+		},
+		"zone-bad-target": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), Target: "accept"},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`invalid target "accept" for firewall zone "work" (valid: default, ACCEPT, DROP, REJECT)`,
+		},
+		"zone-bad-source": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), Sources: []string{"10.0.0.0/33"}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": invalid firewall zone source "10.0.0.0/33": must be an IP address, a network in CIDR notation, a MAC address or ipset:<name>`,
+		},
+		"zone-bad-interface": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), Interfaces: []string{"eth0/1"}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": invalid network interface name "eth0/1"`,
+		},
+		"zone-bad-port": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), Ports: []string{"22:ip"}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": invalid firewall port "22:ip": unknown protocol "ip" (valid: tcp, udp, sctp, dccp)`,
+		},
+		"rich-rule-no-action": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), RichRules: []FirewallRichRuleCustomization{{Service: "ssh"}}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": rich rule #1: invalid action "" (valid: accept, reject, drop)`,
+		},
+		"rich-rule-no-family": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), RichRules: []FirewallRichRuleCustomization{{Source: "10.0.0.1", Action: "drop"}}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": rich rule #1: family is required when source or destination is set`,
+		},
+		"rich-rule-service-and-port": {
+			firewall: &FirewallCustomization{
+				Zones: []FirewallZoneCustomization{
+					{Name: common.ToPtr("work"), RichRules: []FirewallRichRuleCustomization{{Service: "ssh", Port: "22:tcp", Action: "reject"}}},
+				},
+			},
+			err: "invalid firewall customizations:\n" +
+				`firewall zone "work": rich rule #1: service and port are mutually exclusive`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.firewall.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestFirewallCustomizationValidateServices(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ssh.xml", "http.xml", "cockpit.xml", "README"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir.xml"), 0755))
+
+	known, err := ReadFirewalldServices(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ssh", "http", "cockpit"}, known)
+
+	fw := FirewallCustomization{
+		Services: &FirewallServicesCustomization{
+			Enabled:  []string{"cockpit"},
+			Disabled: []string{"ssh"},
+		},
+		Zones: []FirewallZoneCustomization{
+			{
+				Name:      common.ToPtr("public"),
+				Services:  []string{"http"},
+				RichRules: []FirewallRichRuleCustomization{{Service: "ssh", Action: "accept"}},
+			},
+		},
+	}
+	assert.NoError(t, fw.ValidateServices(known))
+
+	fw.Services.Enabled = append(fw.Services.Enabled, "nfs")
+	fw.Zones[0].Services = append(fw.Zones[0].Services, "nfs", "ftp")
+	assert.EqualError(t, fw.ValidateServices(known), `unknown firewalld services: ["nfs" "ftp"]`)
+
+	_, err = ReadFirewalldServices(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestFirewallZoneCustomizationToXML(t *testing.T) {
+	zone := FirewallZoneCustomization{
+		Name:       common.ToPtr("internal"),
+		Target:     "REJECT",
+		Interfaces: []string{"eth1"},
+		Sources:    []string{"10.0.0.0/8", "00:11:22:33:44:55", "ipset:trusted"},
+		Services:   []string{"ssh", "cockpit"},
+		Ports:      []string{"8080:tcp", "5000-5010:udp"},
+		Masquerade: common.ToPtr(true),
+		RichRules: []FirewallRichRuleCustomization{
+			{Family: "ipv4", Source: "192.168.1.0/24", Port: "3306:tcp", Action: "accept"},
+			{Family: "ipv6", Destination: "fe80::/64", Service: "dns", Action: "drop"},
+		},
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<zone target="%%REJECT%%">
+  <short>internal</short>
+  <interface name="eth1"></interface>
+  <source address="10.0.0.0/8"></source>
+  <source mac="00:11:22:33:44:55"></source>
+  <source ipset="trusted"></source>
+  <service name="ssh"></service>
+  <service name="cockpit"></service>
+  <port port="8080" protocol="tcp"></port>
+  <port port="5000-5010" protocol="udp"></port>
+  <masquerade></masquerade>
+  <rule family="ipv4">
+    <source address="192.168.1.0/24"></source>
+    <port port="3306" protocol="tcp"></port>
+    <accept></accept>
+  </rule>
+  <rule family="ipv6">
+    <destination address="fe80::/64"></destination>
+    <service name="dns"></service>
+    <drop></drop>
+  </rule>
+</zone>
+`
+
+	data, err := zone.ToXML()
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(data))
+
+	file, err := zone.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/firewalld/zones/internal.xml", file.Path())
+	assert.Equal(t, []byte(expected), file.Data())
+
+	minimal := FirewallZoneCustomization{Name: common.ToPtr("work"), Target: "default"}
+	data, err = minimal.ToXML()
+	require.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<zone>
+  <short>work</short>
+</zone>
+`, string(data))
+
+	_, err = FirewallZoneCustomization{}.ToXML()
+	assert.EqualError(t, err, "firewall zone name is required")
+}
+
+func TestFirewallZoneCustomizationsToFsNodeFiles(t *testing.T) {
+	files, err := FirewallZoneCustomizationsToFsNodeFiles(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+
+	files, err = FirewallZoneCustomizationsToFsNodeFiles([]FirewallZoneCustomization{
+		{Name: common.ToPtr("a")},
+		{Name: common.ToPtr("b")},
+	})
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "/etc/firewalld/zones/a.xml", files[0].Path())
+	assert.Equal(t, "/etc/firewalld/zones/b.xml", files[1].Path())
+
+	_, err = FirewallZoneCustomizationsToFsNodeFiles([]FirewallZoneCustomization{
+		{Name: common.ToPtr("a"), Target: "bogus"},
+	})
+	assert.Error(t, err)
+
+	_, err = FirewallZoneCustomizationsToFsNodeFiles([]FirewallZoneCustomization{
+		{Name: common.ToPtr("a")},
+		{Name: common.ToPtr("a"), Target: "ACCEPT"},
+	})
+	assert.EqualError(t, err, "invalid firewall zone customizations:\n"+`duplicate firewall zone name "a"`)
+}
+
+func TestGetFirewallValidates(t *testing.T) {
+	c := &Customizations{
+		Firewall: &FirewallCustomization{
+			Ports: []string{"22:tcp", "foo:bar:baz"},
+		},
+	}
+	firewall, err := c.GetFirewall()
+	assert.Nil(t, firewall)
+	assert.ErrorContains(t, err, "invalid firewall customizations:")
+
+	c.Firewall.Ports = []string{"22:tcp"}
+	firewall, err = c.GetFirewall()
+	assert.NoError(t, err)
+	assert.Equal(t, c.Firewall, firewall)
+}
+
+func TestFirewallZoneCustomizationUnmarshal(t *testing.T) {
+	expected := FirewallCustomization{
+		Zones: []FirewallZoneCustomization{
+			{
+				Name:       common.ToPtr("dmz"),
+				Target:     "DROP",
+				Interfaces: []string{"eth2"},
+				Services:   []string{"http"},
+				Ports:      []string{"443:tcp"},
+				Masquerade: common.ToPtr(false),
+				RichRules: []FirewallRichRuleCustomization{
+					{Family: "ipv4", Source: "10.0.0.1", Action: "reject"},
+				},
+			},
+		},
+	}
+
+	tomlData := `
+[[zones]]
+name = "dmz"
+target = "DROP"
+interfaces = ["eth2"]
+services = ["http"]
+ports = ["443:tcp"]
+masquerade = false
+
+[[zones.rich_rules]]
+family = "ipv4"
+source = "10.0.0.1"
+action = "reject"
+`
+	var fromTOML FirewallCustomization
+	_, err := toml.Decode(tomlData, &fromTOML)
+	require.NoError(t, err)
+	assert.Equal(t, expected, fromTOML)
+
+	jsonData := `{"zones":[{"name":"dmz","target":"DROP","interfaces":["eth2"],"services":["http"],"ports":["443:tcp"],"masquerade":false,"rich_rules":[{"family":"ipv4","source":"10.0.0.1","action":"reject"}]}]}`
+	var fromJSON FirewallCustomization
+	require.NoError(t, json.Unmarshal([]byte(jsonData), &fromJSON))
+	assert.Equal(t, expected, fromJSON)
+}
@@ -0,0 +1,267 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SELinux contexts have the form user:role:type[:level], the level is an
+// MLS/MCS range such as "s0" or "s0-s0:c0.c1023"
+var (
+	selinuxIdentifierRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	selinuxLevelRegex      = regexp.MustCompile(`^s[0-9]+(:c[0-9]+([.,]c[0-9]+)*)?(-s[0-9]+(:c[0-9]+([.,]c[0-9]+)*)?)?$`)
+)
+
+// ValidateSELinuxContext checks the syntax of a SELinux security context,
+// e.g. "system_u:object_r:etc_t:s0".
+func ValidateSELinuxContext(context string) error {
+	fields := strings.SplitN(context, ":", 4)
+	if len(fields) < 3 {
+		return fmt.Errorf("invalid SELinux context %q: must be user:role:type[:level]", context)
+	}
+	for _, field := range fields[:3] {
+		if !selinuxIdentifierRegex.MatchString(field) {
+			return fmt.Errorf("invalid SELinux context %q: invalid component %q", context, field)
+		}
+	}
+	if len(fields) == 4 && !selinuxLevelRegex.MatchString(fields[3]) {
+		return fmt.Errorf("invalid SELinux context %q: invalid level %q", context, fields[3])
+	}
+	return nil
+}
+
+// ACLEntry is an entry of a POSIX ACL.
+type ACLEntry struct {
+	// Default entries are inherited by new files in a directory
+	Default bool
+	// Tag is one of "user", "group", "mask" or "other"
+	Tag string
+	// Qualifier is the user or group name or ID, empty for the owner,
+	// the owning group, the mask and others
+	Qualifier string
+	// Perms are the permissions in "rwx" form, e.g. "r-x"
+	Perms string
+}
+
+var aclTags = map[string]string{
+	"u":     "user",
+	"user":  "user",
+	"g":     "group",
+	"group": "group",
+	"m":     "mask",
+	"mask":  "mask",
+	"o":     "other",
+	"other": "other",
+}
+
+// ParseACLEntry parses an ACL entry in the short or long text form of
+// setfacl(1), e.g. "u:apache:rx", "group:wheel:rw-" or "d:o::0".
+func ParseACLEntry(entry string) (ACLEntry, error) {
+	var acl ACLEntry
+
+	fields := strings.Split(entry, ":")
+	if len(fields) == 4 && (fields[0] == "d" || fields[0] == "default") {
+		acl.Default = true
+		fields = fields[1:]
+	}
+	if len(fields) != 3 {
+		return acl, fmt.Errorf("invalid ACL entry %q: must be [default:]tag:qualifier:perms", entry)
+	}
+
+	tag, ok := aclTags[fields[0]]
+	if !ok {
+		return acl, fmt.Errorf("invalid ACL entry %q: unknown tag %q", entry, fields[0])
+	}
+	acl.Tag = tag
+
+	acl.Qualifier = fields[1]
+	if acl.Qualifier != "" {
+		if tag == "mask" || tag == "other" {
+			return acl, fmt.Errorf("invalid ACL entry %q: %s entries have no qualifier", entry, tag)
+		}
+		if !selinuxIdentifierRegex.MatchString(acl.Qualifier) {
+			return acl, fmt.Errorf("invalid ACL entry %q: invalid qualifier %q", entry, acl.Qualifier)
+		}
+	}
+
+	perms, err := parseACLPerms(fields[2])
+	if err != nil {
+		return acl, fmt.Errorf("invalid ACL entry %q: %w", entry, err)
+	}
+	acl.Perms = perms
+	return acl, nil
+}
+
+// parseACLPerms returns the permissions given as a combination of "rwx-"
+// or as an octal digit in "rwx" form.
+func parseACLPerms(perms string) (string, error) {
+	if len(perms) == 1 && perms[0] >= '0' && perms[0] <= '7' {
+		bits := perms[0] - '0'
+		result := []byte("---")
+		for i, c := range []byte("rwx") {
+			if bits&(4>>i) != 0 {
+				result[i] = c
+			}
+		}
+		return string(result), nil
+	}
+
+	if perms == "" || len(perms) > 3 {
+		return "", fmt.Errorf("invalid permissions %q", perms)
+	}
+	result := []byte("---")
+	for _, c := range []byte(perms) {
+		idx := strings.IndexByte("rwx", c)
+		switch {
+		case c == '-':
+		case idx == -1 || result[idx] != '-':
+			return "", fmt.Errorf("invalid permissions %q", perms)
+		default:
+			result[idx] = c
+		}
+	}
+	return string(result), nil
+}
+
+// String returns the entry in the long text form.
+func (e ACLEntry) String() string {
+	s := fmt.Sprintf("%s:%s:%s", e.Tag, e.Qualifier, e.Perms)
+	if e.Default {
+		return "default:" + s
+	}
+	return s
+}
+
+// FileAttributes maps the supported attribute names to their chattr(1)
+// flags
+var FileAttributes = map[string]string{
+	"append_only": "a",
+	"immutable":   "i",
+	"no_atime":    "A",
+	"no_cow":      "C",
+	"no_dump":     "d",
+	"sync":        "S",
+}
+
+// FsNodeAttributes are the attributes of a file or directory that fsnode
+// nodes cannot hold. They are applied after all nodes are created, so an
+// immutable directory can still receive customized files.
+type FsNodeAttributes struct {
+	Path string
+	// SELinux context, empty to keep the context of the policy
+	SELinuxContext string
+	// ACL entries in long text form, e.g. "user:apache:r-x"
+	ACL []string
+	// Attributes names, sorted, see FileAttributes
+	Attributes []string
+}
+
+// ChattrFlags returns the flags to set with chattr(1), e.g. "+ai".
+func (a FsNodeAttributes) ChattrFlags() string {
+	if len(a.Attributes) == 0 {
+		return ""
+	}
+	var flags []string
+	for _, name := range a.Attributes {
+		flags = append(flags, FileAttributes[name])
+	}
+	slices.Sort(flags)
+	return "+" + strings.Join(flags, "")
+}
+
+// newFsNodeAttributes validates the attributes of a node and returns them,
+// or nil if none is set.
+func newFsNodeAttributes(path, selinuxContext string, acl, attributes []string, isDir bool) (*FsNodeAttributes, error) {
+	if selinuxContext == "" && len(acl) == 0 && len(attributes) == 0 {
+		return nil, nil
+	}
+
+	var errs []error
+	if selinuxContext != "" {
+		if err := ValidateSELinuxContext(selinuxContext); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	result := &FsNodeAttributes{Path: path, SELinuxContext: selinuxContext}
+	seen := make(map[string]bool)
+	for _, entry := range acl {
+		parsed, err := ParseACLEntry(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if parsed.Default && !isDir {
+			errs = append(errs, fmt.Errorf("invalid ACL entry %q: default entries are only allowed for directories", entry))
+			continue
+		}
+		key := fmt.Sprintf("%v:%s:%s", parsed.Default, parsed.Tag, parsed.Qualifier)
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("duplicate ACL entry %q", entry))
+			continue
+		}
+		seen[key] = true
+		result.ACL = append(result.ACL, parsed.String())
+	}
+
+	for _, name := range attributes {
+		if _, ok := FileAttributes[name]; !ok {
+			errs = append(errs, fmt.Errorf("unsupported attribute %q", name))
+			continue
+		}
+		if !slices.Contains(result.Attributes, name) {
+			result.Attributes = append(result.Attributes, name)
+		}
+	}
+	slices.Sort(result.Attributes)
+	if slices.Contains(result.Attributes, "immutable") && slices.Contains(result.Attributes, "append_only") {
+		errs = append(errs, fmt.Errorf("attributes \"immutable\" and \"append_only\" are mutually exclusive"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid attributes for %q:\n%w", path, err)
+	}
+	return result, nil
+}
+
+// ToFsNodeAttributes returns the attributes of the directory, or nil if
+// none is set.
+func (d DirectoryCustomization) ToFsNodeAttributes() (*FsNodeAttributes, error) {
+	return newFsNodeAttributes(d.Path, d.SELinuxContext, d.ACL, d.Attributes, true)
+}
+
+// ToFsNodeAttributes returns the attributes of the file, or nil if none is
+// set.
+func (f FileCustomization) ToFsNodeAttributes() (*FsNodeAttributes, error) {
+	return newFsNodeAttributes(f.Path, f.SELinuxContext, f.ACL, f.Attributes, false)
+}
+
+// FsNodeAttributesForCustomizations returns the attributes of all
+// directories and files that have any.
+func FsNodeAttributesForCustomizations(dirs []DirectoryCustomization, files []FileCustomization) ([]FsNodeAttributes, error) {
+	var result []FsNodeAttributes
+	var errs []error
+	for _, dir := range dirs {
+		attrs, err := dir.ToFsNodeAttributes()
+		if err != nil {
+			errs = append(errs, err)
+		} else if attrs != nil {
+			result = append(result, *attrs)
+		}
+	}
+	for _, file := range files {
+		attrs, err := file.ToFsNodeAttributes()
+		if err != nil {
+			errs = append(errs, err)
+		} else if attrs != nil {
+			result = append(result, *attrs)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSELinuxContext(t *testing.T) {
+	for _, context := range []string{
+		"system_u:object_r:etc_t",
+		"system_u:object_r:etc_t:s0",
+		"system_u:object_r:httpd_sys_content_t:s0-s0:c0.c1023",
+		"unconfined_u:object_r:user_home_t:s0:c1,c5",
+	} {
+		assert.NoError(t, ValidateSELinuxContext(context), context)
+	}
+
+	testCases := map[string]string{
+		"etc_t":                             `invalid SELinux context "etc_t": must be user:role:type[:level]`,
+		"system_u::etc_t":                   `invalid SELinux context "system_u::etc_t": invalid component ""`,
+		"system_u:object_r:etc t:s0":        `invalid SELinux context "system_u:object_r:etc t:s0": invalid component "etc t"`,
+		"system_u:object_r:etc_t:low":       `invalid SELinux context "system_u:object_r:etc_t:low": invalid level "low"`,
+		"system_u:object_r:etc_t:s0:c1024:": `invalid SELinux context "system_u:object_r:etc_t:s0:c1024:": invalid level "s0:c1024:"`,
+	}
+	for context, expected := range testCases {
+		assert.EqualError(t, ValidateSELinuxContext(context), expected)
+	}
+}
+
+func TestParseACLEntry(t *testing.T) {
+	testCases := []struct {
+		Entry string
+		Want  ACLEntry
+		Error string
+	}{
+		{Entry: "u:apache:rx", Want: ACLEntry{Tag: "user", Qualifier: "apache", Perms: "r-x"}},
+		{Entry: "user::rw-", Want: ACLEntry{Tag: "user", Perms: "rw-"}},
+		{Entry: "g:1000:7", Want: ACLEntry{Tag: "group", Qualifier: "1000", Perms: "rwx"}},
+		{Entry: "m::r", Want: ACLEntry{Tag: "mask", Perms: "r--"}},
+		{Entry: "default:other::0", Want: ACLEntry{Default: true, Tag: "other", Perms: "---"}},
+		{Entry: "d:g:wheel:xr", Want: ACLEntry{Default: true, Tag: "group", Qualifier: "wheel", Perms: "r-x"}},
+		{Entry: "u:apache", Error: `invalid ACL entry "u:apache": must be [default:]tag:qualifier:perms`},
+		{Entry: "x:apache:r", Error: `invalid ACL entry "x:apache:r": unknown tag "x"`},
+		{Entry: "o:nobody:r", Error: `invalid ACL entry "o:nobody:r": other entries have no qualifier`},
+		{Entry: "u:a b:r", Error: `invalid ACL entry "u:a b:r": invalid qualifier "a b"`},
+		{Entry: "u:apache:rr", Error: `invalid ACL entry "u:apache:rr": invalid permissions "rr"`},
+		{Entry: "u:apache:8", Error: `invalid ACL entry "u:apache:8": invalid permissions "8"`},
+		{Entry: "u:apache:", Error: `invalid ACL entry "u:apache:": invalid permissions ""`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Entry, func(t *testing.T) {
+			entry, err := ParseACLEntry(tc.Entry)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.Want, entry)
+			}
+		})
+	}
+
+	assert.Equal(t, "default:group:wheel:r-x", ACLEntry{Default: true, Tag: "group", Qualifier: "wheel", Perms: "r-x"}.String())
+}
+
+func TestFsNodeAttributes(t *testing.T) {
+	dir := DirectoryCustomization{
+		Path:           "/srv/www",
+		SELinuxContext: "system_u:object_r:httpd_sys_content_t:s0",
+		ACL:            []string{"u:apache:rx", "d:u:apache:rx"},
+	}
+	file := FileCustomization{
+		Path:       "/etc/resolv.conf",
+		Attributes: []string{"immutable", "no_dump", "immutable"},
+	}
+
+	attrs, err := FsNodeAttributesForCustomizations([]DirectoryCustomization{dir, {Path: "/etc/plain"}}, []FileCustomization{file})
+	require.NoError(t, err)
+	assert.Equal(t, []FsNodeAttributes{
+		{
+			Path:           "/srv/www",
+			SELinuxContext: "system_u:object_r:httpd_sys_content_t:s0",
+			ACL:            []string{"user:apache:r-x", "default:user:apache:r-x"},
+		},
+		{
+			Path:       "/etc/resolv.conf",
+			Attributes: []string{"immutable", "no_dump"},
+		},
+	}, attrs)
+	assert.Equal(t, "+di", attrs[1].ChattrFlags())
+	assert.Equal(t, "", attrs[0].ChattrFlags())
+
+	// the conversion to fsnode validates the attributes
+	_, err = dir.ToFsNodeDirectory()
+	assert.NoError(t, err)
+	_, err = file.ToFsNodeFile()
+	assert.NoError(t, err)
+
+	file = FileCustomization{
+		Path:           "/etc/resolv.conf",
+		SELinuxContext: "etc_t",
+		ACL:            []string{"d:u:apache:r", "u:apache:r", "user:apache:rw"},
+		Attributes:     []string{"immutable", "append_only", "hidden"},
+	}
+	_, err = file.ToFsNodeFile()
+	assert.EqualError(t, err, `invalid attributes for "/etc/resolv.conf":
+invalid SELinux context "etc_t": must be user:role:type[:level]
+invalid ACL entry "d:u:apache:r": default entries are only allowed for directories
+duplicate ACL entry "user:apache:rw"
+unsupported attribute "hidden"
+attributes "immutable" and "append_only" are mutually exclusive`)
+
+	_, err = DirectoryCustomization{Path: "/srv/www", ACL: []string{"bad"}}.ToFsNodeDirectory()
+	assert.EqualError(t, err, "invalid attributes for \"/srv/www\":\ninvalid ACL entry \"bad\": must be [default:]tag:qualifier:perms")
+}
+
+func TestFsNodeAttributesUnmarshal(t *testing.T) {
+	var blueprint Blueprint
+	err := toml.Unmarshal([]byte(`
+[[customizations.directories]]
+path = "/srv/www"
+selinux_context = "system_u:object_r:httpd_sys_content_t:s0"
+acl = ["u:apache:rx", "d:u:apache:rx"]
+
+[[customizations.files]]
+path = "/etc/resolv.conf"
+attributes = ["immutable"]
+`), &blueprint)
+	require.NoError(t, err)
+	assert.Equal(t, []DirectoryCustomization{{
+		Path:           "/srv/www",
+		SELinuxContext: "system_u:object_r:httpd_sys_content_t:s0",
+		ACL:            []string{"u:apache:rx", "d:u:apache:rx"},
+	}}, blueprint.Customizations.Directories)
+	assert.Equal(t, []string{"immutable"}, blueprint.Customizations.Files[0].Attributes)
+
+	err = toml.Unmarshal([]byte(`
+[[customizations.files]]
+path = "/etc/resolv.conf"
+acl = "u:apache:rx"
+`), &blueprint)
+	assert.ErrorContains(t, err, "UnmarshalTOML: acl must be a list of strings")
+
+	err = json.Unmarshal([]byte(`{"customizations": {"files": [{"path": "/etc/resolv.conf", "selinux_context": "bad"}]}}`), &blueprint)
+	assert.ErrorContains(t, err, `invalid SELinux context "bad"`)
+}
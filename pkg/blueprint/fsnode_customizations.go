@@ -1,8 +1,17 @@
 package blueprint
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
@@ -37,6 +46,12 @@ type DirectoryCustomization struct {
 	Mode string `json:"mode,omitempty" toml:"mode,omitempty"`
 	// EnsureParents ensures that all parent directories of the directory exist
 	EnsureParents bool `json:"ensure_parents,omitempty" toml:"ensure_parents,omitempty"`
+	// SELinux context, e.g. "system_u:object_r:etc_t:s0"
+	SELinuxContext string `json:"selinux_context,omitempty" toml:"selinux_context,omitempty"`
+	// POSIX ACL entries in the text form of setfacl(1), e.g. "u:apache:r-x"
+	ACL []string `json:"acl,omitempty" toml:"acl,omitempty"`
+	// Attributes set with chattr(1), e.g. "immutable", see FileAttributes
+	Attributes []string `json:"attributes,omitempty" toml:"attributes,omitempty"`
 }
 
 // Custom TOML unmarshalling for DirectoryCustomization with validation
@@ -92,8 +107,29 @@ func (d *DirectoryCustomization) UnmarshalTOML(data interface{}) error {
 		return fmt.Errorf("UnmarshalTOML: ensure_parents must be a bool")
 	}
 
+	switch selinuxContext := dataMap["selinux_context"].(type) {
+	case string:
+		dir.SELinuxContext = selinuxContext
+	case nil:
+		break
+	default:
+		return fmt.Errorf("UnmarshalTOML: selinux_context must be a string")
+	}
+
+	acl, err := tomlStringSlice(dataMap["acl"])
+	if err != nil {
+		return fmt.Errorf("UnmarshalTOML: acl %w", err)
+	}
+	dir.ACL = acl
+
+	attributes, err := tomlStringSlice(dataMap["attributes"])
+	if err != nil {
+		return fmt.Errorf("UnmarshalTOML: attributes %w", err)
+	}
+	dir.Attributes = attributes
+
 	// try converting to fsnode.Directory to validate all values
-	_, err := dir.ToFsNodeDirectory()
+	_, err = dir.ToFsNodeDirectory()
 	if err != nil {
 		return err
 	}
@@ -153,6 +189,11 @@ func (d DirectoryCustomization) ToFsNodeDirectory() (*fsnode.Directory, error) {
 		mode = common.ToPtr(os.FileMode(modeNum))
 	}
 
+	// attributes are not part of fsnode.Directory, see ToFsNodeAttributes
+	if _, err := d.ToFsNodeAttributes(); err != nil {
+		return nil, err
+	}
+
 	return fsnode.NewDirectory(d.Path, mode, d.User, d.Group, d.EnsureParents)
 }
 
@@ -180,6 +221,76 @@ func DirectoryCustomizationsToFsNodeDirectories(dirs []DirectoryCustomization) (
 	return fsDirs, nil
 }
 
+// Supported encodings of FileCustomization.Data
+const (
+	FileDataEncodingBase64     = "base64"
+	FileDataEncodingGzipBase64 = "gzip+base64"
+)
+
+// MaxFileDataSize is the maximum size of the decoded content of a file
+// embedded in the blueprint with a base64 or gzip+base64 encoding. It guards
+// against small encoded data expanding to huge files. Plain data is not
+// limited as it is already held in memory at its final size. Larger files
+// should be referenced by URI.
+const MaxFileDataSize = 8 * 1024 * 1024
+
+// decodeFileData returns the content of data in the given encoding.
+func decodeFileData(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(data), nil
+	case FileDataEncodingBase64, FileDataEncodingGzipBase64:
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q, must be %q or %q", encoding, FileDataEncodingBase64, FileDataEncodingGzipBase64)
+	}
+
+	decoded, err := base64.StdEncoding.Strict().DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode base64: %w", err)
+	}
+	if encoding == FileDataEncodingBase64 {
+		if len(decoded) > MaxFileDataSize {
+			return nil, fmt.Errorf("data exceeds the maximum size of %d bytes", MaxFileDataSize)
+		}
+		return decoded, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress gzip: %w", err)
+	}
+	// read one byte more than allowed to detect oversized content without
+	// decompressing all of it
+	content, err := io.ReadAll(io.LimitReader(zr, MaxFileDataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress gzip: %w", err)
+	}
+	if len(content) > MaxFileDataSize {
+		return nil, fmt.Errorf("decompressed data exceeds the maximum size of %d bytes", MaxFileDataSize)
+	}
+	return content, nil
+}
+
+// tomlStringSlice returns the strings of a TOML array.
+func tomlStringSlice(value any) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a list of strings")
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("must be a list of strings")
+	}
+}
+
 // FileCustomization represents a file to be created in the image
 type FileCustomization struct {
 	// Absolute path to the file
@@ -190,19 +301,45 @@ type FileCustomization struct {
 	Group interface{} `json:"group,omitempty" toml:"group,omitempty"`
 	// Permissions of the file specified as an octal number
 	Mode string `json:"mode,omitempty" toml:"mode,omitempty"`
-	// Data is the file content in plain text
+	// Data is the file content in plain text or encoded as set in Encoding
 	Data string `json:"data,omitempty" toml:"data,omitempty"`
+	// Encoding of Data, one of "base64" or "gzip+base64", plain text if
+	// empty
+	Encoding string `json:"encoding,omitempty" toml:"encoding,omitempty"`
 
 	// URI references the given URI, this makes the manifest alone
-	// no-longer portable (but future offline manifest bundles
-	// will fix that). It will still be reproducible as the
+	// no-longer portable (WriteBundle creates an offline bundle
+	// that includes the file). It will still be reproducible as the
 	// manifest will include all the hashes of the content so any
-	// change will make the build fail.
+	// change will make the build fail. To pin the content in the
+	// blueprint itself set Checksum.
 	//
-	// Initially only single files are supported, but this can be
-	// expanded to dirs (which will just be added recursively) and
-	// http{,s}.
+	// Only single files are supported, directories are copied
+	// recursively with DirectoryTreeCustomization. This can be
+	// expanded to http{,s}.
 	URI string `json:"uri,omitempty" toml:"uri,omitempty"`
+
+	// Checksum of the content as "<algorithm>:<hex digest>", the
+	// algorithm is "sha256" or "sha512". It is verified against data and
+	// local files when converting to fsnode.File.
+	//
+	// Unlike local files, remote sources are not passed on with their
+	// checksum for later verification: fsnode.File has no field to carry
+	// it and cannot be created for remote URIs at all, so a checksum on a
+	// remote URI is rejected instead of being silently dropped.
+	Checksum string `json:"checksum,omitempty" toml:"checksum,omitempty"`
+
+	// Template renders Data as a text/template against the blueprint,
+	// see Blueprint.RenderFileTemplates. Consumers render the templates
+	// when converting the blueprint, the blueprint keeps the templates.
+	Template bool `json:"template,omitempty" toml:"template,omitempty"`
+
+	// SELinux context, e.g. "system_u:object_r:etc_t:s0"
+	SELinuxContext string `json:"selinux_context,omitempty" toml:"selinux_context,omitempty"`
+	// POSIX ACL entries in the text form of setfacl(1), e.g. "u:apache:r-x"
+	ACL []string `json:"acl,omitempty" toml:"acl,omitempty"`
+	// Attributes set with chattr(1), e.g. "immutable", see FileAttributes
+	Attributes []string `json:"attributes,omitempty" toml:"attributes,omitempty"`
 }
 
 // Custom TOML unmarshalling for FileCustomization with validation
@@ -258,6 +395,15 @@ func (f *FileCustomization) UnmarshalTOML(data interface{}) error {
 		return fmt.Errorf("UnmarshalTOML: data must be a string")
 	}
 
+	switch encoding := dataMap["encoding"].(type) {
+	case string:
+		file.Encoding = encoding
+	case nil:
+		break
+	default:
+		return fmt.Errorf("UnmarshalTOML: encoding must be a string")
+	}
+
 	switch uri := dataMap["uri"].(type) {
 	case string:
 		file.URI = uri
@@ -267,8 +413,47 @@ func (f *FileCustomization) UnmarshalTOML(data interface{}) error {
 		return fmt.Errorf("UnmarshalTOML: uri must be a string")
 	}
 
+	switch template := dataMap["template"].(type) {
+	case bool:
+		file.Template = template
+	case nil:
+		break
+	default:
+		return fmt.Errorf("UnmarshalTOML: template must be a bool")
+	}
+
+	switch checksum := dataMap["checksum"].(type) {
+	case string:
+		file.Checksum = checksum
+	case nil:
+		break
+	default:
+		return fmt.Errorf("UnmarshalTOML: checksum must be a string")
+	}
+
+	switch selinuxContext := dataMap["selinux_context"].(type) {
+	case string:
+		file.SELinuxContext = selinuxContext
+	case nil:
+		break
+	default:
+		return fmt.Errorf("UnmarshalTOML: selinux_context must be a string")
+	}
+
+	acl, err := tomlStringSlice(dataMap["acl"])
+	if err != nil {
+		return fmt.Errorf("UnmarshalTOML: acl %w", err)
+	}
+	file.ACL = acl
+
+	attributes, err := tomlStringSlice(dataMap["attributes"])
+	if err != nil {
+		return fmt.Errorf("UnmarshalTOML: attributes %w", err)
+	}
+	file.Attributes = attributes
+
 	// try converting to fsnode.File to validate all values
-	_, err := file.ToFsNodeFile()
+	err = file.validate()
 	if err != nil {
 		return err
 	}
@@ -302,7 +487,7 @@ func (f *FileCustomization) UnmarshalJSON(data []byte) error {
 		file.Group = int64(gid)
 	}
 	// try converting to fsnode.File to validate all values
-	_, err := file.ToFsNodeFile()
+	err := file.validate()
 	if err != nil {
 		return err
 	}
@@ -311,15 +496,41 @@ func (f *FileCustomization) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// validate checks all values by converting to fsnode.File. Templates can
+// only be rendered against the whole blueprint, so they are only parsed.
+func (f FileCustomization) validate() error {
+	if f.Template {
+		if _, err := f.parseTemplate(); err != nil {
+			return err
+		}
+		f.Template = false
+		f.Data = ""
+	}
+	_, err := f.ToFsNodeFile()
+	return err
+}
+
 // ToFsNodeFile converts the FileCustomization to an fsnode.File
 func (f FileCustomization) ToFsNodeFile() (*fsnode.File, error) {
+	if f.Template {
+		return nil, fmt.Errorf("file %q is a template that must be rendered first, see Blueprint.RenderFileTemplates", f.Path)
+	}
+
 	if f.Data != "" && f.URI != "" {
 		return nil, fmt.Errorf("cannot specify both data %q and URI %q", f.Data, f.URI)
 	}
 
+	if f.Encoding != "" && f.Data == "" {
+		return nil, fmt.Errorf("encoding %q requires data", f.Encoding)
+	}
+
 	var data []byte
 	if f.Data != "" {
-		data = []byte(f.Data)
+		var err error
+		data, err = decodeFileData(f.Data, f.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data for file %q: %w", f.Path, err)
+		}
 	}
 
 	var mode *os.FileMode
@@ -335,12 +546,84 @@ func (f FileCustomization) ToFsNodeFile() (*fsnode.File, error) {
 		mode = common.ToPtr(os.FileMode(modeNum))
 	}
 
+	// attributes are not part of fsnode.File, see ToFsNodeAttributes
+	if _, err := f.ToFsNodeAttributes(); err != nil {
+		return nil, err
+	}
+
+	if f.Checksum != "" {
+		if err := f.verifyChecksum(data); err != nil {
+			return nil, fmt.Errorf("file %q: %w", f.Path, err)
+		}
+	}
+
 	if f.URI != "" {
 		return fsnode.NewFileForURI(f.Path, mode, f.User, f.Group, f.URI)
 	}
 	return fsnode.NewFile(f.Path, mode, f.User, f.Group, data)
 }
 
+// parseFileChecksum returns the hash and the expected digest of a checksum
+// in the "<algorithm>:<hex digest>" format.
+func parseFileChecksum(checksum string) (hash.Hash, []byte, error) {
+	algorithm, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid checksum %q: must be <algorithm>:<hex digest>", checksum)
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, nil, fmt.Errorf("invalid checksum %q: unsupported algorithm %q, must be \"sha256\" or \"sha512\"", checksum, algorithm)
+	}
+
+	expected, err := hex.DecodeString(digest)
+	if err != nil || len(expected) != h.Size() {
+		return nil, nil, fmt.Errorf("invalid checksum %q: digest must be %d hex digits", checksum, 2*h.Size())
+	}
+	return h, expected, nil
+}
+
+// verifyChecksum checks the checksum against the data or the local file
+// of the URI. Remote URIs are rejected: their content cannot be verified
+// here and there is no way to pass the checksum on with the fsnode.File.
+func (f FileCustomization) verifyChecksum(data []byte) error {
+	h, expected, err := parseFileChecksum(f.Checksum)
+	if err != nil {
+		return err
+	}
+
+	if f.URI != "" {
+		uri, err := url.Parse(f.URI)
+		if err != nil {
+			return err
+		}
+		if uri.Scheme != "" && uri.Scheme != "file" {
+			return fmt.Errorf("checksum cannot be verified for %s URI %q, only for data and local files", uri.Scheme, f.URI)
+		}
+		fp, err := os.Open(uri.Path)
+		if err != nil {
+			return fmt.Errorf("cannot verify checksum: %w", err)
+		}
+		defer fp.Close()
+		if _, err := io.Copy(h, fp); err != nil {
+			return fmt.Errorf("cannot verify checksum: %w", err)
+		}
+	} else {
+		h.Write(data)
+	}
+
+	if actual := h.Sum(nil); !bytes.Equal(actual, expected) {
+		algorithm, _, _ := strings.Cut(f.Checksum, ":")
+		return fmt.Errorf("checksum mismatch: expected %s, got %s:%x", f.Checksum, algorithm, actual)
+	}
+	return nil
+}
+
 // FileCustomizationsToFsNodeFiles converts a slice of FileCustomization to a slice of *fsnode.File
 func FileCustomizationsToFsNodeFiles(files []FileCustomization) ([]*fsnode.File, error) {
 	if len(files) == 0 {
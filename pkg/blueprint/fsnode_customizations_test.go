@@ -1,10 +1,14 @@
 package blueprint
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
@@ -621,6 +625,41 @@ func TestFileCustomizationToFsNodeFile(t *testing.T) {
 			},
 			Want: ensureFileCreation(fsnode.NewFile("/etc/file", nil, nil, nil, []byte("hello world"))),
 		},
+		{
+			Name: "path-and-base64-data",
+			File: FileCustomization{
+				Path:     "/etc/krb5.keytab",
+				Data:     "AAECA/8=",
+				Encoding: "base64",
+			},
+			Want: ensureFileCreation(fsnode.NewFile("/etc/krb5.keytab", nil, nil, nil, []byte{0, 1, 2, 3, 255})),
+		},
+		{
+			Name: "path-and-gzip-base64-data",
+			File: FileCustomization{
+				Path:     "/etc/file",
+				Data:     "H4sIAAAAAAAAA8tIzcnJVyjPL8pJAQCFEUoNCwAAAA==",
+				Encoding: "gzip+base64",
+			},
+			Want: ensureFileCreation(fsnode.NewFile("/etc/file", nil, nil, nil, []byte("hello world"))),
+		},
+		{
+			Name: "encoding-without-data",
+			File: FileCustomization{
+				Path:     "/etc/file",
+				Encoding: "base64",
+			},
+			Error: true,
+		},
+		{
+			Name: "invalid-encoding",
+			File: FileCustomization{
+				Path:     "/etc/file",
+				Data:     "hello world",
+				Encoding: "hex",
+			},
+			Error: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -820,6 +859,42 @@ data = "hello world 3"
 				},
 			},
 		},
+		{
+			Name: "file-with-encoded-data",
+			TOML: `
+name = "test"
+description = "Test"
+version = "0.0.0"
+
+[[customizations.files]]
+path = "/etc/krb5.keytab"
+mode = "0600"
+data = "AAECA/8="
+encoding = "base64"
+`,
+			Want: []FileCustomization{
+				{
+					Path:     "/etc/krb5.keytab",
+					Mode:     "0600",
+					Data:     "AAECA/8=",
+					Encoding: "base64",
+				},
+			},
+		},
+		{
+			Name: "file-with-invalid-encoded-data",
+			TOML: `
+name = "test"
+description = "Test"
+version = "0.0.0"
+
+[[customizations.files]]
+path = "/etc/krb5.keytab"
+data = "not base64!"
+encoding = "base64"
+`,
+			Error: true,
+		},
 		{
 			Name: "invalid-files",
 			TOML: `
@@ -1330,3 +1405,178 @@ func TestToFileNodeForRef(t *testing.T) {
 	assert.Equal(t, "/some/path", file.Path())
 	assert.Equal(t, testFile, file.URI())
 }
+
+func TestDecodeFileData(t *testing.T) {
+	gzipped := func(data []byte) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	data, err := decodeFileData("hello", "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	// line breaks of wrapped base64 are ignored
+	data, err = decodeFileData("aGVs\nbG8=\n", "base64")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	data, err = decodeFileData(gzipped([]byte("hello")), "gzip+base64")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	// the size limit only applies to the decoded output of encoded data
+	plain := strings.Repeat("a", MaxFileDataSize+1)
+	data, err = decodeFileData(plain, "")
+	require.NoError(t, err)
+	assert.Len(t, data, MaxFileDataSize+1)
+
+	testCases := []struct {
+		Name     string
+		Data     string
+		Encoding string
+		Error    string
+	}{
+		{
+			Name:     "unsupported",
+			Data:     "hello",
+			Encoding: "gzip",
+			Error:    `unsupported encoding "gzip", must be "base64" or "gzip+base64"`,
+		},
+		{
+			Name:     "bad-base64",
+			Data:     "aGVsbG8",
+			Encoding: "base64",
+			Error:    "cannot decode base64: illegal base64 data at input byte 4",
+		},
+		{
+			Name:     "non-canonical-base64",
+			Data:     "aGVsbG9=",
+			Encoding: "base64",
+			Error:    "cannot decode base64: illegal base64 data at input byte 7",
+		},
+		{
+			Name:     "not-gzip",
+			Data:     "aGVsbG8=",
+			Encoding: "gzip+base64",
+			Error:    "cannot decompress gzip: unexpected EOF",
+		},
+		{
+			Name:     "truncated-gzip",
+			Data:     gzipped([]byte("hello world"))[:24],
+			Encoding: "gzip+base64",
+			Error:    "cannot decompress gzip: unexpected EOF",
+		},
+		{
+			Name:     "base64-too-large",
+			Data:     base64.StdEncoding.EncodeToString(make([]byte, MaxFileDataSize+1)),
+			Encoding: "base64",
+			Error:    "data exceeds the maximum size of 8388608 bytes",
+		},
+		{
+			Name:     "gzip-bomb",
+			Data:     gzipped(make([]byte, MaxFileDataSize+1)),
+			Encoding: "gzip+base64",
+			Error:    "decompressed data exceeds the maximum size of 8388608 bytes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := decodeFileData(tc.Data, tc.Encoding)
+			assert.EqualError(t, err, tc.Error)
+		})
+	}
+}
+
+func TestFileCustomizationChecksum(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "motd")
+	require.NoError(t, os.WriteFile(testFile, []byte("hello world"), 0644))
+
+	const helloSHA256 = "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	const helloSHA512 = "sha512:309ecc489c12d6eb4cc40f50c902f2b4d0ed77ee511a7c7a9bcd3ca86d4cd86f989dd35bc5ff499670da34255b45b0cfd830e81f605dcf7dc5542e93ae9cd76f"
+
+	testCases := []struct {
+		Name  string
+		File  FileCustomization
+		Error string
+	}{
+		{
+			Name: "local-file",
+			File: FileCustomization{Path: "/etc/motd", URI: testFile, Checksum: helloSHA256},
+		},
+		{
+			Name: "local-file-uri",
+			File: FileCustomization{Path: "/etc/motd", URI: "file://" + testFile, Checksum: helloSHA512},
+		},
+		{
+			Name: "data",
+			File: FileCustomization{Path: "/etc/motd", Data: "aGVsbG8gd29ybGQ=", Encoding: "base64", Checksum: helloSHA256},
+		},
+		{
+			Name:  "local-file-changed",
+			File:  FileCustomization{Path: "/etc/motd", URI: testFile, Checksum: "sha256:" + strings.Repeat("0", 64)},
+			Error: `file "/etc/motd": checksum mismatch: expected sha256:` + strings.Repeat("0", 64) + `, got ` + helloSHA256,
+		},
+		{
+			Name:  "local-file-missing",
+			File:  FileCustomization{Path: "/etc/motd", URI: testFile + ".missing", Checksum: helloSHA256},
+			Error: `file "/etc/motd": cannot verify checksum: open ` + testFile + `.missing: no such file or directory`,
+		},
+		{
+			Name:  "no-algorithm",
+			File:  FileCustomization{Path: "/etc/motd", Data: "hello world", Checksum: "b94d27b9"},
+			Error: `file "/etc/motd": invalid checksum "b94d27b9": must be <algorithm>:<hex digest>`,
+		},
+		{
+			Name:  "unsupported-algorithm",
+			File:  FileCustomization{Path: "/etc/motd", Data: "hello world", Checksum: "md5:5eb63bbbe01eeed093cb22bb8f5acdc3"},
+			Error: `file "/etc/motd": invalid checksum "md5:5eb63bbbe01eeed093cb22bb8f5acdc3": unsupported algorithm "md5", must be "sha256" or "sha512"`,
+		},
+		{
+			Name:  "short-digest",
+			File:  FileCustomization{Path: "/etc/motd", Data: "hello world", Checksum: "sha256:b94d27b9"},
+			Error: `file "/etc/motd": invalid checksum "sha256:b94d27b9": digest must be 64 hex digits`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := tc.File.ToFsNodeFile()
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// remote sources cannot be verified and are rejected
+	remote := FileCustomization{Path: "/etc/motd", URI: "https://example.com/motd", Checksum: helloSHA256}
+	assert.EqualError(t, remote.verifyChecksum(nil), `checksum cannot be verified for https URI "https://example.com/motd", only for data and local files`)
+	_, err := remote.ToFsNodeFile()
+	assert.EqualError(t, err, `file "/etc/motd": checksum cannot be verified for https URI "https://example.com/motd", only for data and local files`)
+
+	var blueprint Blueprint
+	err = toml.Unmarshal([]byte(fmt.Sprintf(`
+[[customizations.files]]
+path = "/etc/motd"
+uri = "%s"
+checksum = "%s"
+`, testFile, helloSHA256)), &blueprint)
+	require.NoError(t, err)
+	assert.Equal(t, helloSHA256, blueprint.Customizations.Files[0].Checksum)
+
+	require.NoError(t, os.WriteFile(testFile, []byte("changed"), 0644))
+	err = toml.Unmarshal([]byte(fmt.Sprintf(`
+[[customizations.files]]
+path = "/etc/motd"
+uri = "%s"
+checksum = "%s"
+`, testFile, helloSHA256)), &blueprint)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
@@ -0,0 +1,330 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// HostsEntryCustomization is a static entry in /etc/hosts.
+type HostsEntryCustomization struct {
+	// IPv4 or IPv6 address (required)
+	Address string `json:"address" toml:"address"`
+
+	// Host names for the address, the first one is the canonical name
+	// (required)
+	Hostnames []string `json:"hostnames" toml:"hostnames"`
+}
+
+type HostsCustomization []HostsEntryCustomization
+
+// DNSCustomization configures the name resolution of the image.
+type DNSCustomization struct {
+	// Resolver to configure: "systemd-resolved" (default) or "resolv.conf".
+	Resolver string `json:"resolver,omitempty" toml:"resolver,omitempty"`
+
+	// Name server IP addresses. With systemd-resolved an address may be
+	// followed by "#" and the server name used for DNS over TLS, e.g.
+	// "1.1.1.1#cloudflare-dns.com".
+	Nameservers []string `json:"nameservers,omitempty" toml:"nameservers,omitempty"`
+
+	// Search domains
+	SearchDomains []string `json:"search_domains,omitempty" toml:"search_domains,omitempty"`
+
+	// DNSSEC validation mode for systemd-resolved: "yes", "no" or
+	// "allow-downgrade".
+	DNSSEC string `json:"dnssec,omitempty" toml:"dnssec,omitempty"`
+
+	// DNS over TLS mode for systemd-resolved: "yes", "no" or
+	// "opportunistic".
+	DNSOverTLS string `json:"dns_over_tls,omitempty" toml:"dns_over_tls,omitempty"`
+}
+
+const (
+	ResolverSystemdResolved = "systemd-resolved"
+	ResolverResolvConf      = "resolv.conf"
+)
+
+const (
+	HostsPath          = "/etc/hosts"
+	ResolvConfPath     = "/etc/resolv.conf"
+	ResolvedDropinPath = "/etc/systemd/resolved.conf.d/50-blueprint.conf"
+)
+
+const (
+	// HOST_NAME_MAX of the kernel
+	hostnameMaxLength = 64
+	dnsNameMaxLength  = 253
+	// glibc ignores search domains beyond the sixth
+	resolvConfMaxSearch = 6
+)
+
+// default content of /etc/hosts as shipped by the setup package
+var defaultHosts = HostsCustomization{
+	{Address: "127.0.0.1", Hostnames: []string{"localhost", "localhost.localdomain", "localhost4", "localhost4.localdomain4"}},
+	{Address: "::1", Hostnames: []string{"localhost", "localhost.localdomain", "localhost6", "localhost6.localdomain6"}},
+}
+
+// hostname labels as described in RFC 1123
+var dnsLabelRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+var numericLabelRegex = regexp.MustCompile(`^[0-9]+$`)
+
+// isValidDNSName returns true if name is a valid DNS host name, with or
+// without a trailing dot.
+func isValidDNSName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > dnsNameMaxLength {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !dnsLabelRegex.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateHostname checks that hostname follows the RFC 1123 rules: it
+// consists of dot separated labels of 1 to 63 letters, digits and hyphens
+// which neither start nor end with a hyphen. Trailing dots are not allowed.
+//
+// By default the hostname may be at most 64 characters long, the limit for
+// the kernel hostname. If fqdn is true the hostname must instead be a fully
+// qualified domain name of at most 253 characters with at least two labels
+// and a non-numeric top level domain.
+func ValidateHostname(hostname string, fqdn bool) error {
+	maxLength := hostnameMaxLength
+	if fqdn {
+		maxLength = dnsNameMaxLength
+	}
+	return validateHostname(hostname, maxLength, fqdn)
+}
+
+func validateHostname(hostname string, maxLength int, fqdn bool) error {
+	if hostname == "" {
+		return fmt.Errorf("hostname is empty")
+	}
+	if strings.HasSuffix(hostname, ".") {
+		return fmt.Errorf("invalid hostname %q: must not end with a dot", hostname)
+	}
+
+	if len(hostname) > maxLength {
+		return fmt.Errorf("invalid hostname %q: longer than %d characters", hostname, maxLength)
+	}
+
+	labels := strings.Split(hostname, ".")
+	for _, label := range labels {
+		if !dnsLabelRegex.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q: label %q must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen", hostname, label)
+		}
+	}
+
+	if fqdn {
+		if len(labels) < 2 {
+			return fmt.Errorf("invalid hostname %q: not a fully qualified domain name", hostname)
+		}
+		if numericLabelRegex.MatchString(labels[len(labels)-1]) {
+			return fmt.Errorf("invalid hostname %q: top level domain must not be numeric", hostname)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that all addresses and host names are valid and that no
+// address is listed more than once. Host names are not limited to the length
+// of the kernel hostname, aliases can be fully qualified domain names of up
+// to 253 characters.
+func (h HostsCustomization) Validate() error {
+	addresses := make(map[string]bool)
+
+	var errs []error
+	for _, entry := range h {
+		ip := net.ParseIP(entry.Address)
+		if ip == nil {
+			errs = append(errs, fmt.Errorf("invalid hosts address %q", entry.Address))
+			continue
+		}
+		// normalize so "::1" and "0::1" are detected as duplicates
+		if addresses[ip.String()] {
+			errs = append(errs, fmt.Errorf("duplicate hosts address %q", entry.Address))
+		}
+		addresses[ip.String()] = true
+
+		if len(entry.Hostnames) == 0 {
+			errs = append(errs, fmt.Errorf("hosts entry for %q has no hostnames", entry.Address))
+		}
+		for _, name := range entry.Hostnames {
+			if err := validateHostname(name, dnsNameMaxLength, false); err != nil {
+				errs = append(errs, fmt.Errorf("hosts entry for %q: %w", entry.Address, err))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid hosts customizations:\n%w", err)
+	}
+	return nil
+}
+
+// ToFsNodeFile validates the entries and returns /etc/hosts with the
+// default localhost entries followed by the customized entries. Host names
+// customized for 127.0.0.1 or ::1 are added to the default entry of the
+// address instead of listing the address twice.
+func (h HostsCustomization) ToFsNodeFile() (*fsnode.File, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	entries := slices.Clone(defaultHosts)
+	for _, entry := range h {
+		ip := net.ParseIP(entry.Address)
+		idx := slices.IndexFunc(entries[:len(defaultHosts)], func(d HostsEntryCustomization) bool {
+			return net.ParseIP(d.Address).Equal(ip)
+		})
+		if idx == -1 {
+			entries = append(entries, entry)
+			continue
+		}
+		hostnames := slices.Clone(entries[idx].Hostnames)
+		for _, name := range entry.Hostnames {
+			if !slices.Contains(hostnames, name) {
+				hostnames = append(hostnames, name)
+			}
+		}
+		entries[idx].Hostnames = hostnames
+	}
+
+	var b strings.Builder
+	for idx, entry := range entries {
+		if idx < len(defaultHosts) {
+			// aligned like the file shipped by the setup package
+			fmt.Fprintf(&b, "%-11s %s\n", entry.Address, strings.Join(entry.Hostnames, " "))
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s\n", entry.Address, strings.Join(entry.Hostnames, " "))
+	}
+	return fsnode.NewFile(HostsPath, nil, nil, nil, []byte(b.String()))
+}
+
+func (d *DNSCustomization) resolver() string {
+	if d.Resolver == "" {
+		return ResolverSystemdResolved
+	}
+	return d.Resolver
+}
+
+// Validate checks that all name servers and search domains are valid and
+// that the options are supported by the selected resolver.
+func (d *DNSCustomization) Validate() error {
+	if d == nil {
+		return nil
+	}
+
+	var errs []error
+	resolver := d.resolver()
+	switch resolver {
+	case ResolverSystemdResolved:
+		if d.DNSSEC != "" && !slices.Contains([]string{"yes", "no", "allow-downgrade"}, d.DNSSEC) {
+			errs = append(errs, fmt.Errorf("invalid dnssec mode %q (valid: yes, no, allow-downgrade)", d.DNSSEC))
+		}
+		if d.DNSOverTLS != "" && !slices.Contains([]string{"yes", "no", "opportunistic"}, d.DNSOverTLS) {
+			errs = append(errs, fmt.Errorf("invalid dns_over_tls mode %q (valid: yes, no, opportunistic)", d.DNSOverTLS))
+		}
+	case ResolverResolvConf:
+		if d.DNSSEC != "" {
+			errs = append(errs, fmt.Errorf("dnssec is only supported with the %s resolver", ResolverSystemdResolved))
+		}
+		if d.DNSOverTLS != "" {
+			errs = append(errs, fmt.Errorf("dns_over_tls is only supported with the %s resolver", ResolverSystemdResolved))
+		}
+		if len(d.SearchDomains) > resolvConfMaxSearch {
+			errs = append(errs, fmt.Errorf("%s supports at most %d search domains, got %d", ResolverResolvConf, resolvConfMaxSearch, len(d.SearchDomains)))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown resolver %q (valid: %s, %s)", d.Resolver, ResolverSystemdResolved, ResolverResolvConf))
+	}
+
+	for _, ns := range d.Nameservers {
+		address, serverName, hasServerName := strings.Cut(ns, "#")
+		if net.ParseIP(address) == nil {
+			errs = append(errs, fmt.Errorf("invalid nameserver %q: must be an IP address", ns))
+			continue
+		}
+		if hasServerName {
+			if resolver != ResolverSystemdResolved {
+				errs = append(errs, fmt.Errorf("invalid nameserver %q: server names are only supported with the %s resolver", ns, ResolverSystemdResolved))
+			} else if !isValidDNSName(serverName) {
+				errs = append(errs, fmt.Errorf("invalid nameserver %q: invalid server name %q", ns, serverName))
+			}
+		}
+	}
+
+	for _, domain := range d.SearchDomains {
+		if !isValidDNSName(domain) {
+			errs = append(errs, fmt.Errorf("invalid search domain %q", domain))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid dns customizations:\n%w", err)
+	}
+	return nil
+}
+
+// ResolvedConf renders the settings as a systemd-resolved drop-in, see
+// resolved.conf(5).
+func (d *DNSCustomization) ResolvedConf() string {
+	var b strings.Builder
+	b.WriteString("[Resolve]\n")
+	if len(d.Nameservers) > 0 {
+		fmt.Fprintf(&b, "DNS=%s\n", strings.Join(d.Nameservers, " "))
+	}
+	if len(d.SearchDomains) > 0 {
+		fmt.Fprintf(&b, "Domains=%s\n", strings.Join(d.SearchDomains, " "))
+	}
+	if d.DNSSEC != "" {
+		fmt.Fprintf(&b, "DNSSEC=%s\n", d.DNSSEC)
+	}
+	if d.DNSOverTLS != "" {
+		fmt.Fprintf(&b, "DNSOverTLS=%s\n", d.DNSOverTLS)
+	}
+	return b.String()
+}
+
+// ResolvConf renders the settings as resolv.conf(5).
+func (d *DNSCustomization) ResolvConf() string {
+	var b strings.Builder
+	for _, ns := range d.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(d.SearchDomains) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(d.SearchDomains, " "))
+	}
+	return b.String()
+}
+
+// ToFsNodeFile validates the customization and returns the configuration
+// file for the selected resolver.
+func (d *DNSCustomization) ToFsNodeFile() (*fsnode.File, error) {
+	if d == nil {
+		return nil, nil
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	if d.resolver() == ResolverResolvConf {
+		return fsnode.NewFile(ResolvConfPath, nil, nil, nil, []byte(d.ResolvConf()))
+	}
+	return fsnode.NewFile(ResolvedDropinPath, nil, nil, nil, []byte(d.ResolvedConf()))
+}
@@ -0,0 +1,371 @@
+package blueprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestValidateHostname(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		fqdn     bool
+		err      string
+	}{
+		{hostname: "localhost"},
+		{hostname: "web-01"},
+		{hostname: "web01.example.com"},
+		{hostname: "1host"},
+		{hostname: "web01.example.com", fqdn: true},
+		{hostname: "a.b", fqdn: true},
+		{
+			hostname: "",
+			err:      "hostname is empty",
+		},
+		{
+			hostname: "web_01",
+			err:      `invalid hostname "web_01": label "web_01" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`,
+		},
+		{
+			hostname: "-web",
+			err:      `invalid hostname "-web": label "-web" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`,
+		},
+		{
+			hostname: "web..example.com",
+			err:      `invalid hostname "web..example.com": label "" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`,
+		},
+		{
+			hostname: "web.example.com.",
+			err:      `invalid hostname "web.example.com.": must not end with a dot`,
+		},
+		{
+			hostname: strings.Repeat("a", 64) + ".com",
+			fqdn:     true,
+			err:      `invalid hostname "` + strings.Repeat("a", 64) + `.com": label "` + strings.Repeat("a", 64) + `" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`,
+		},
+		{
+			hostname: strings.Repeat("a.", 32) + "com",
+			err:      `invalid hostname "` + strings.Repeat("a.", 32) + `com": longer than 64 characters`,
+		},
+		{
+			hostname: strings.Repeat("a.", 32) + "com",
+			fqdn:     true,
+		},
+		{
+			hostname: strings.Repeat("a.", 127) + "com",
+			fqdn:     true,
+			err:      `invalid hostname "` + strings.Repeat("a.", 127) + `com": longer than 253 characters`,
+		},
+		{
+			hostname: "web01",
+			fqdn:     true,
+			err:      `invalid hostname "web01": not a fully qualified domain name`,
+		},
+		{
+			hostname: "192.168.0.1",
+			fqdn:     true,
+			err:      `invalid hostname "192.168.0.1": top level domain must not be numeric`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.hostname, func(t *testing.T) {
+			err := ValidateHostname(tc.hostname, tc.fqdn)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCheckHostname(t *testing.T) {
+	var nilCustomizations *Customizations
+	assert.NoError(t, nilCustomizations.CheckHostname(true))
+	assert.NoError(t, (&Customizations{}).CheckHostname(true))
+
+	c := Customizations{Hostname: common.ToPtr("my_host")}
+	assert.Error(t, c.CheckHostname(false))
+
+	c.Hostname = common.ToPtr("myhost")
+	assert.NoError(t, c.CheckHostname(false))
+	assert.Error(t, c.CheckHostname(true))
+
+	// checked when the blueprint is initialized
+	bp := Blueprint{Name: "test", Customizations: &Customizations{Hostname: common.ToPtr("my_host")}}
+	assert.EqualError(t, bp.Initialize(), `invalid hostname "my_host": label "my_host" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`)
+	bp.Customizations.Hostname = common.ToPtr("myhost")
+	assert.NoError(t, bp.Initialize())
+}
+
+func TestHostsCustomization(t *testing.T) {
+	testCases := map[string]struct {
+		hosts HostsCustomization
+		err   string
+	}{
+		"nil": {},
+		"happy": {
+			hosts: HostsCustomization{
+				{Address: "192.168.0.10", Hostnames: []string{"db.example.com", "db"}},
+				{Address: "fd00::10", Hostnames: []string{"cache"}},
+			},
+		},
+		"bad-address": {
+			hosts: HostsCustomization{
+				{Address: "192.168.0.300", Hostnames: []string{"db"}},
+			},
+			err: "invalid hosts customizations:\n" +
+				`invalid hosts address "192.168.0.300"`,
+		},
+		"duplicate-address": {
+			hosts: HostsCustomization{
+				{Address: "fd00::10", Hostnames: []string{"a"}},
+				{Address: "fd00:0::10", Hostnames: []string{"b"}},
+			},
+			err: "invalid hosts customizations:\n" +
+				`duplicate hosts address "fd00:0::10"`,
+		},
+		"no-hostnames": {
+			hosts: HostsCustomization{
+				{Address: "10.0.0.1"},
+			},
+			err: "invalid hosts customizations:\n" +
+				`hosts entry for "10.0.0.1" has no hostnames`,
+		},
+		"long-alias": {
+			hosts: HostsCustomization{
+				{Address: "10.0.0.1", Hostnames: []string{strings.Repeat("a.", 40) + "example.com", "db"}},
+			},
+		},
+		"bad-hostname": {
+			hosts: HostsCustomization{
+				{Address: "10.0.0.1", Hostnames: []string{"db_1"}},
+			},
+			err: "invalid hosts customizations:\n" +
+				`hosts entry for "10.0.0.1": invalid hostname "db_1": label "db_1" must be 1 to 63 letters, digits or hyphens and must not start or end with a hyphen`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.hosts.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestHostsCustomizationToFsNodeFile(t *testing.T) {
+	hosts := HostsCustomization{
+		{Address: "192.168.0.10", Hostnames: []string{"db.example.com", "db"}},
+		{Address: "fd00::10", Hostnames: []string{"cache"}},
+	}
+
+	file, err := hosts.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/hosts", file.Path())
+	assert.Equal(t, `127.0.0.1   localhost localhost.localdomain localhost4 localhost4.localdomain4
+::1         localhost localhost.localdomain localhost6 localhost6.localdomain6
+192.168.0.10 db.example.com db
+fd00::10 cache
+`, string(file.Data()))
+
+	// names for the loopback addresses are added to the default entries
+	hosts = HostsCustomization{
+		{Address: "127.0.0.1", Hostnames: []string{"myhost.example.com", "localhost"}},
+		{Address: "0::1", Hostnames: []string{"myhost6"}},
+		{Address: "192.168.0.10", Hostnames: []string{"db"}},
+	}
+	file, err = hosts.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, `127.0.0.1   localhost localhost.localdomain localhost4 localhost4.localdomain4 myhost.example.com
+::1         localhost localhost.localdomain localhost6 localhost6.localdomain6 myhost6
+192.168.0.10 db
+`, string(file.Data()))
+	// the defaults are not modified
+	assert.Len(t, defaultHosts[0].Hostnames, 4)
+
+	file, err = HostsCustomization(nil).ToFsNodeFile()
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+}
+
+func TestDNSCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		dns *DNSCustomization
+		err string
+	}{
+		"nil": {},
+		"resolved": {
+			dns: &DNSCustomization{
+				Nameservers:   []string{"1.1.1.1#cloudflare-dns.com", "2606:4700:4700::1111"},
+				SearchDomains: []string{"example.com", "corp.example.com."},
+				DNSSEC:        "allow-downgrade",
+				DNSOverTLS:    "opportunistic",
+			},
+		},
+		"resolv-conf": {
+			dns: &DNSCustomization{
+				Resolver:      "resolv.conf",
+				Nameservers:   []string{"10.0.0.53"},
+				SearchDomains: []string{"example.com"},
+			},
+		},
+		"unknown-resolver": {
+			dns: &DNSCustomization{Resolver: "dnsmasq"},
+			err: "invalid dns customizations:\n" +
+				`unknown resolver "dnsmasq" (valid: systemd-resolved, resolv.conf)`,
+		},
+		"bad-nameserver": {
+			dns: &DNSCustomization{Nameservers: []string{"dns.example.com"}},
+			err: "invalid dns customizations:\n" +
+				`invalid nameserver "dns.example.com": must be an IP address`,
+		},
+		"bad-server-name": {
+			dns: &DNSCustomization{Nameservers: []string{"1.1.1.1#not_valid"}},
+			err: "invalid dns customizations:\n" +
+				`invalid nameserver "1.1.1.1#not_valid": invalid server name "not_valid"`,
+		},
+		"bad-search-domain": {
+			dns: &DNSCustomization{SearchDomains: []string{"-example.com"}},
+			err: "invalid dns customizations:\n" +
+				`invalid search domain "-example.com"`,
+		},
+		"bad-dnssec": {
+			dns: &DNSCustomization{DNSSEC: "true", DNSOverTLS: "strict"},
+			err: "invalid dns customizations:\n" +
+				`invalid dnssec mode "true" (valid: yes, no, allow-downgrade)` + "\n" +
+				`invalid dns_over_tls mode "strict" (valid: yes, no, opportunistic)`,
+		},
+		"resolv-conf-resolved-options": {
+			dns: &DNSCustomization{
+				Resolver:    "resolv.conf",
+				Nameservers: []string{"1.1.1.1#cloudflare-dns.com"},
+				DNSSEC:      "yes",
+				DNSOverTLS:  "yes",
+			},
+			err: "invalid dns customizations:\n" +
+				"dnssec is only supported with the systemd-resolved resolver\n" +
+				"dns_over_tls is only supported with the systemd-resolved resolver\n" +
+				`invalid nameserver "1.1.1.1#cloudflare-dns.com": server names are only supported with the systemd-resolved resolver`,
+		},
+		"resolv-conf-too-many-search-domains": {
+			dns: &DNSCustomization{
+				Resolver:      "resolv.conf",
+				SearchDomains: []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "g.com"},
+			},
+			err: "invalid dns customizations:\n" +
+				"resolv.conf supports at most 6 search domains, got 7",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.dns.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestDNSCustomizationToFsNodeFile(t *testing.T) {
+	dns := &DNSCustomization{
+		Nameservers:   []string{"1.1.1.1#cloudflare-dns.com", "9.9.9.9"},
+		SearchDomains: []string{"example.com"},
+		DNSSEC:        "yes",
+		DNSOverTLS:    "yes",
+	}
+	file, err := dns.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/systemd/resolved.conf.d/50-blueprint.conf", file.Path())
+	assert.Equal(t, `[Resolve]
+DNS=1.1.1.1#cloudflare-dns.com 9.9.9.9
+Domains=example.com
+DNSSEC=yes
+DNSOverTLS=yes
+`, string(file.Data()))
+
+	dns = &DNSCustomization{
+		Resolver:      "resolv.conf",
+		Nameservers:   []string{"10.0.0.53", "10.0.1.53"},
+		SearchDomains: []string{"example.com", "corp.example.com"},
+	}
+	file, err = dns.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/resolv.conf", file.Path())
+	assert.Equal(t, `nameserver 10.0.0.53
+nameserver 10.0.1.53
+search example.com corp.example.com
+`, string(file.Data()))
+
+	var nilDNS *DNSCustomization
+	file, err = nilDNS.ToFsNodeFile()
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+
+	_, err = (&DNSCustomization{Nameservers: []string{"bogus"}}).ToFsNodeFile()
+	assert.Error(t, err)
+}
+
+func TestGetHostsAndDNS(t *testing.T) {
+	tomlData := `
+[customizations]
+hostname = "web01.example.com"
+
+[[customizations.hosts]]
+address = "10.0.0.5"
+hostnames = ["db.example.com", "db"]
+
+[customizations.dns]
+nameservers = ["10.0.0.53"]
+search_domains = ["example.com"]
+dnssec = "no"
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	assert.NoError(t, bp.Customizations.CheckHostname(true))
+
+	hosts, err := bp.Customizations.GetHosts()
+	require.NoError(t, err)
+	assert.Equal(t, HostsCustomization{
+		{Address: "10.0.0.5", Hostnames: []string{"db.example.com", "db"}},
+	}, hosts)
+
+	dns, err := bp.Customizations.GetDNS()
+	require.NoError(t, err)
+	assert.Equal(t, &DNSCustomization{
+		Nameservers:   []string{"10.0.0.53"},
+		SearchDomains: []string{"example.com"},
+		DNSSEC:        "no",
+	}, dns)
+
+	bp.Customizations.Hosts[0].Address = "bogus"
+	_, err = bp.Customizations.GetHosts()
+	assert.Error(t, err)
+
+	bp.Customizations.DNS.DNSSEC = "maybe"
+	_, err = bp.Customizations.GetDNS()
+	assert.Error(t, err)
+
+	var nilCustomizations *Customizations
+	hosts, err = nilCustomizations.GetHosts()
+	assert.NoError(t, err)
+	assert.Nil(t, hosts)
+	dns, err = nilCustomizations.GetDNS()
+	assert.NoError(t, err)
+	assert.Nil(t, dns)
+}
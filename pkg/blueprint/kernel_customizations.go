@@ -0,0 +1,480 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+type KernelCustomization struct {
+	Name   string `json:"name,omitempty" toml:"name,omitempty"`
+	Append string `json:"append,omitempty" toml:"append,omitempty"`
+
+	// Structured kernel command line arguments, merged with Append and the
+	// image type defaults by [KernelCustomization.MergeArgs].
+	Args *KernelArgsCustomization `json:"args,omitempty" toml:"args,omitempty"`
+
+	// Kernel module configuration rendered to modprobe.d and
+	// modules-load.d.
+	Modules *KernelModulesCustomization `json:"modules,omitempty" toml:"modules,omitempty"`
+}
+
+// KernelArgsCustomization adds arguments to and removes arguments from the
+// kernel command line. Each entry is parsed with the same quoting rules as
+// Append and can contain more than one argument.
+type KernelArgsCustomization struct {
+	// Arguments to add, e.g. "console=ttyS0,115200n8"
+	Add []string `json:"add,omitempty" toml:"add,omitempty"`
+
+	// Arguments to remove. An entry without a value ("quiet") removes all
+	// arguments with that key, an entry with a value ("console=tty0") only
+	// removes the exact argument.
+	Remove []string `json:"remove,omitempty" toml:"remove,omitempty"`
+}
+
+// KernelModulesCustomization configures which kernel modules are blacklisted
+// or loaded at boot and the options they are loaded with.
+type KernelModulesCustomization struct {
+	// Modules that must not be loaded automatically, e.g. "nouveau"
+	Blacklist []string `json:"blacklist,omitempty" toml:"blacklist,omitempty"`
+
+	// Modules that are loaded at boot by systemd-modules-load, e.g.
+	// "vfio-pci"
+	Load []string `json:"load,omitempty" toml:"load,omitempty"`
+
+	// Options to pass to modules when they are loaded
+	Options []KernelModuleOptionsCustomization `json:"options,omitempty" toml:"options,omitempty"`
+}
+
+// KernelModuleOptionsCustomization is an "options" line in modprobe.d.
+type KernelModuleOptionsCustomization struct {
+	// Module name (required)
+	Module string `json:"module" toml:"module"`
+
+	// Module parameters, e.g. "nested=1" (required)
+	Options []string `json:"options" toml:"options"`
+}
+
+const (
+	KernelModulesBlacklistPath = "/etc/modprobe.d/blueprint-blacklist.conf"
+	KernelModulesOptionsPath   = "/etc/modprobe.d/blueprint-options.conf"
+	KernelModulesLoadPath      = "/etc/modules-load.d/blueprint.conf"
+)
+
+// KernelArg is a single kernel command line argument.
+type KernelArg struct {
+	Key string
+	// Value of the argument, without quotes. Only meaningful if HasValue is
+	// true, "foo=" has an empty value while "foo" has none.
+	Value    string
+	HasValue bool
+}
+
+// Arguments that only take effect once, usually the kernel or the initrd uses
+// the last value. Giving them more than once with different values is a
+// conflict and user arguments replace the image defaults for them. All other
+// arguments can be repeated (e.g. console, ip, systemd.setenv or
+// rd.luks.options) and are added to the defaults.
+// "ro" and "rw" are mutually exclusive and treated as the same key.
+var singleValuedKernelArgs = []string{
+	"crashkernel",
+	"enforcing",
+	"fips",
+	"init",
+	"mitigations",
+	"nosmt",
+	"rcu_nocbs",
+	"resume",
+	"ro",
+	"root",
+	"rootflags",
+	"rootfstype",
+	"rw",
+	"selinux",
+}
+
+var kernelModuleNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+var kernelModuleOptionRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(=\S+)?$`)
+
+var kernelConsoleRegex = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*(,[0-9]+([noe][5-8]?r?)?)?|uart(8250)?,.+)$`)
+
+// "auto", "<size>[@<offset>]" or "<range>:<size>[,<range>:<size>...][@<offset>]",
+// optionally followed by ",high" or ",low", see the kernel documentation for
+// kdump.
+var kernelCrashkernelRegex = regexp.MustCompile(
+	`^(auto|[0-9]+[KMG]?(@[0-9]+[KMG]?)?|[0-9]+[KMG]?-([0-9]+[KMG]?)?:[0-9]+[KMG]?(,[0-9]+[KMG]?-([0-9]+[KMG]?)?:[0-9]+[KMG]?)*(@[0-9]+[KMG]?)?)(,(high|low))?$`)
+
+// ParseKernelArgs splits a kernel command line into its arguments. Like the
+// kernel, arguments are separated by whitespace and double quotes can be
+// used to include whitespace in an argument, either around the value
+// (foo="a b") or around the whole argument ("foo=a b"). The quotes are not
+// part of the parsed value.
+func ParseKernelArgs(cmdline string) ([]KernelArg, error) {
+	var tokens []string
+	var current strings.Builder
+	inToken := false
+	inQuote := false
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inToken = true
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuote:
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			inToken = true
+			current.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("invalid kernel command line %q: unterminated quote", cmdline)
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+
+	var args []KernelArg
+	for _, token := range tokens {
+		// quotes around the whole argument
+		if strings.HasPrefix(token, `"`) && strings.HasSuffix(token, `"`) {
+			token = token[1 : len(token)-1]
+		}
+
+		key, value, hasValue := strings.Cut(token, "=")
+		if strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) && len(value) >= 2 {
+			value = value[1 : len(value)-1]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid kernel argument %q: empty key", token)
+		}
+		if strings.Contains(key, `"`) || strings.Contains(value, `"`) {
+			return nil, fmt.Errorf("invalid kernel argument %q: misplaced quote", token)
+		}
+		args = append(args, KernelArg{Key: key, Value: value, HasValue: hasValue})
+	}
+	return args, nil
+}
+
+// String returns the argument as it appears on the kernel command line,
+// quoting the value if it contains whitespace.
+func (a KernelArg) String() string {
+	if !a.HasValue {
+		return a.Key
+	}
+	if strings.ContainsFunc(a.Value, unicode.IsSpace) {
+		return fmt.Sprintf(`%s="%s"`, a.Key, a.Value)
+	}
+	return a.Key + "=" + a.Value
+}
+
+// normalizedKey returns the key used to compare arguments, the kernel treats
+// dashes and underscores in parameter names the same.
+func (a KernelArg) normalizedKey() string {
+	return strings.ReplaceAll(a.Key, "-", "_")
+}
+
+func (a KernelArg) equal(b KernelArg) bool {
+	return a.normalizedKey() == b.normalizedKey() && a.HasValue == b.HasValue && a.Value == b.Value
+}
+
+func (a KernelArg) singleValued() bool {
+	return slices.Contains(singleValuedKernelArgs, a.normalizedKey())
+}
+
+// conflictKey returns the key used to find conflicting single-valued
+// arguments.
+func (a KernelArg) conflictKey() string {
+	if key := a.normalizedKey(); key != "rw" {
+		return key
+	}
+	return "ro"
+}
+
+func (a KernelArg) conflicts(b KernelArg) bool {
+	return a.singleValued() && b.singleValued() && a.conflictKey() == b.conflictKey()
+}
+
+// validate checks the value of well-known arguments.
+func (a KernelArg) validate() error {
+	switch a.normalizedKey() {
+	case "console":
+		if !a.HasValue || !kernelConsoleRegex.MatchString(a.Value) {
+			return fmt.Errorf("invalid kernel argument %q: expected console=<device>[,<options>]", a.String())
+		}
+	case "crashkernel":
+		if !a.HasValue || !kernelCrashkernelRegex.MatchString(a.Value) {
+			return fmt.Errorf("invalid kernel argument %q: expected crashkernel=auto, crashkernel=<size>[@<offset>] or crashkernel=<range>:<size>[,...]", a.String())
+		}
+	case "fips":
+		if !a.HasValue || (a.Value != "0" && a.Value != "1") {
+			return fmt.Errorf("invalid kernel argument %q: expected fips=0 or fips=1", a.String())
+		}
+	}
+	return nil
+}
+
+func parseKernelArgsList(entries []string) ([]KernelArg, error) {
+	var args []KernelArg
+	for _, entry := range entries {
+		parsed, err := ParseKernelArgs(entry)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, parsed...)
+	}
+	return args, nil
+}
+
+// userArgs returns the parsed arguments from Append and Args.Add.
+func (k *KernelCustomization) userArgs() ([]KernelArg, error) {
+	args, err := ParseKernelArgs(k.Append)
+	if err != nil {
+		return nil, err
+	}
+	if k.Args != nil {
+		added, err := parseKernelArgsList(k.Args.Add)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, added...)
+	}
+	return args, nil
+}
+
+// Validate checks that Append and the structured arguments can be parsed,
+// that well-known arguments have valid values and that no argument is
+// duplicated, conflicts with another argument or is both added and removed.
+func (k *KernelCustomization) Validate() error {
+	if k == nil {
+		return nil
+	}
+
+	if err := k.Modules.Validate(); err != nil {
+		return err
+	}
+
+	args, err := k.userArgs()
+	if err != nil {
+		return fmt.Errorf("invalid kernel customizations:\n%w", err)
+	}
+
+	var errs []error
+	for idx, arg := range args {
+		errs = append(errs, arg.validate())
+
+		if slices.ContainsFunc(args[:idx], arg.equal) {
+			errs = append(errs, fmt.Errorf("duplicate kernel argument %q", arg.String()))
+			continue
+		}
+		conflict := slices.IndexFunc(args[:idx], arg.conflicts)
+		if conflict != -1 {
+			errs = append(errs, fmt.Errorf("conflicting kernel arguments %q and %q", args[conflict].String(), arg.String()))
+		}
+	}
+
+	if k.Args != nil {
+		removed, err := parseKernelArgsList(k.Args.Remove)
+		if err != nil {
+			return fmt.Errorf("invalid kernel customizations:\n%w", err)
+		}
+		for _, rm := range removed {
+			if slices.ContainsFunc(args, rm.matches) {
+				errs = append(errs, fmt.Errorf("kernel argument %q is both added and removed", rm.String()))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid kernel customizations:\n%w", err)
+	}
+	return nil
+}
+
+// matches returns true if the argument a, used as a removal pattern,
+// matches the argument b.
+func (a KernelArg) matches(b KernelArg) bool {
+	if !a.HasValue {
+		return a.normalizedKey() == b.normalizedKey()
+	}
+	return a.equal(b)
+}
+
+// MergeArgs validates the customization and merges its arguments into the
+// given default arguments (e.g. from the image type):
+//   - Arguments from Append and Args.Add replace default arguments with the
+//     same key if the argument only takes effect once (e.g. root or ro/rw),
+//     all other arguments (e.g. console) are added.
+//   - Arguments already present are not added again.
+//   - Args.Remove is applied last and can remove default arguments.
+//
+// The order of the default arguments is preserved and new arguments are
+// appended in the order they are specified.
+func (k *KernelCustomization) MergeArgs(defaults []string) ([]string, error) {
+	result, err := parseKernelArgsList(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default kernel arguments: %w", err)
+	}
+
+	if k != nil {
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+
+		args, err := k.userArgs()
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if slices.ContainsFunc(result, arg.equal) {
+				continue
+			}
+			if idx := slices.IndexFunc(result, arg.conflicts); idx != -1 {
+				result[idx] = arg
+				// drop any further defaults with the same key
+				result = append(result[:idx+1], slices.DeleteFunc(result[idx+1:], arg.conflicts)...)
+				continue
+			}
+			result = append(result, arg)
+		}
+
+		if k.Args != nil {
+			removed, err := parseKernelArgsList(k.Args.Remove)
+			if err != nil {
+				return nil, err
+			}
+			for _, rm := range removed {
+				result = slices.DeleteFunc(result, rm.matches)
+			}
+		}
+	}
+
+	merged := make([]string, 0, len(result))
+	for _, arg := range result {
+		merged = append(merged, arg.String())
+	}
+	return merged, nil
+}
+
+// normalizeModuleName returns the name used to compare modules, modprobe
+// treats dashes and underscores in module names the same.
+func normalizeModuleName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func validateModuleName(name string) error {
+	if !kernelModuleNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid kernel module name %q", name)
+	}
+	return nil
+}
+
+// Validate checks that all module names and options are valid, that no
+// module is listed twice and that no module is both blacklisted and loaded.
+func (m *KernelModulesCustomization) Validate() error {
+	if m == nil {
+		return nil
+	}
+
+	var errs []error
+	checkList := func(list string, modules []string) map[string]bool {
+		seen := make(map[string]bool)
+		for _, module := range modules {
+			if err := validateModuleName(module); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if seen[normalizeModuleName(module)] {
+				errs = append(errs, fmt.Errorf("duplicate kernel module %q in %s", module, list))
+			}
+			seen[normalizeModuleName(module)] = true
+		}
+		return seen
+	}
+	blacklisted := checkList("blacklist", m.Blacklist)
+	checkList("load", m.Load)
+
+	for _, module := range m.Load {
+		if blacklisted[normalizeModuleName(module)] {
+			errs = append(errs, fmt.Errorf("kernel module %q is both blacklisted and loaded", module))
+		}
+	}
+
+	withOptions := make(map[string]bool)
+	for _, opts := range m.Options {
+		if err := validateModuleName(opts.Module); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if withOptions[normalizeModuleName(opts.Module)] {
+			errs = append(errs, fmt.Errorf("duplicate options for kernel module %q", opts.Module))
+		}
+		withOptions[normalizeModuleName(opts.Module)] = true
+
+		if len(opts.Options) == 0 {
+			errs = append(errs, fmt.Errorf("no options for kernel module %q", opts.Module))
+		}
+		for _, opt := range opts.Options {
+			if !kernelModuleOptionRegex.MatchString(opt) {
+				errs = append(errs, fmt.Errorf("invalid option %q for kernel module %q", opt, opts.Module))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid kernel modules customizations:\n%w", err)
+	}
+	return nil
+}
+
+// ToFsNodeFiles validates the customization and returns the modprobe.d(5)
+// and modules-load.d(5) files for it. Files without content are omitted.
+func (m *KernelModulesCustomization) ToFsNodeFiles() ([]*fsnode.File, error) {
+	if m == nil {
+		return nil, nil
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	var blacklist, options, load strings.Builder
+	for _, module := range m.Blacklist {
+		fmt.Fprintf(&blacklist, "blacklist %s\n", module)
+	}
+	for _, opts := range m.Options {
+		fmt.Fprintf(&options, "options %s %s\n", opts.Module, strings.Join(opts.Options, " "))
+	}
+	for _, module := range m.Load {
+		fmt.Fprintf(&load, "%s\n", module)
+	}
+
+	var files []*fsnode.File
+	for _, f := range []struct {
+		path string
+		data string
+	}{
+		{KernelModulesBlacklistPath, blacklist.String()},
+		{KernelModulesOptionsPath, options.String()},
+		{KernelModulesLoadPath, load.String()},
+	} {
+		if f.data == "" {
+			continue
+		}
+		file, err := fsnode.NewFile(f.path, nil, nil, nil, []byte(f.data))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
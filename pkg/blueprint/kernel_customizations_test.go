@@ -0,0 +1,403 @@
+package blueprint
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKernelArgs(t *testing.T) {
+	testCases := []struct {
+		cmdline  string
+		expected []KernelArg
+		err      string
+	}{
+		{cmdline: ""},
+		{cmdline: "   "},
+		{
+			cmdline: "quiet  rhgb\tconsole=ttyS0,115200n8 foo=",
+			expected: []KernelArg{
+				{Key: "quiet"},
+				{Key: "rhgb"},
+				{Key: "console", Value: "ttyS0,115200n8", HasValue: true},
+				{Key: "foo", Value: "", HasValue: true},
+			},
+		},
+		{
+			cmdline: `foo="a b" "bar=c d" baz=x=y`,
+			expected: []KernelArg{
+				{Key: "foo", Value: "a b", HasValue: true},
+				{Key: "bar", Value: "c d", HasValue: true},
+				{Key: "baz", Value: "x=y", HasValue: true},
+			},
+		},
+		{
+			cmdline: `foo="a b`,
+			err:     `invalid kernel command line "foo=\"a b": unterminated quote`,
+		},
+		{
+			cmdline: `=foo`,
+			err:     `invalid kernel argument "=foo": empty key`,
+		},
+		{
+			cmdline: `fo"o"=bar`,
+			err:     `invalid kernel argument "fo\"o\"=bar": misplaced quote`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.cmdline, func(t *testing.T) {
+			args, err := ParseKernelArgs(tc.cmdline)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, args)
+		})
+	}
+}
+
+func TestKernelArgString(t *testing.T) {
+	assert.Equal(t, "quiet", KernelArg{Key: "quiet"}.String())
+	assert.Equal(t, "foo=", KernelArg{Key: "foo", HasValue: true}.String())
+	assert.Equal(t, "root=/dev/sda1", KernelArg{Key: "root", Value: "/dev/sda1", HasValue: true}.String())
+	assert.Equal(t, `foo="a b"`, KernelArg{Key: "foo", Value: "a b", HasValue: true}.String())
+}
+
+func TestKernelCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		kernel *KernelCustomization
+		err    string
+	}{
+		"nil": {},
+		"append-only": {
+			kernel: &KernelCustomization{Append: "nosmt=force console=tty0 console=ttyS0,115200n8"},
+		},
+		"structured": {
+			kernel: &KernelCustomization{
+				Append: "crashkernel=1G-4G:192M,4G-64G:256M,64G-:512M",
+				Args: &KernelArgsCustomization{
+					Add:    []string{"fips=1", "console=hvc0", `"dyndbg=file foo.c +p"`},
+					Remove: []string{"rhgb", "quiet"},
+				},
+			},
+		},
+		"repeated-systemd-setenv": {
+			kernel: &KernelCustomization{
+				Append: "systemd.setenv=FOO=1 systemd.setenv=BAR=2 rd.luks.options=discard",
+				Args: &KernelArgsCustomization{
+					Add: []string{"rd.luks.options=tpm2-device=auto", "ip=eth0:dhcp", "ip=eth1:dhcp"},
+				},
+			},
+		},
+		"ro-rw-conflict": {
+			kernel: &KernelCustomization{Append: "ro rw"},
+			err: "invalid kernel customizations:\n" +
+				`conflicting kernel arguments "ro" and "rw"`,
+		},
+		"crashkernel-variants": {
+			kernel: &KernelCustomization{
+				Args: &KernelArgsCustomization{
+					Add: []string{"crashkernel=auto"},
+				},
+			},
+		},
+		"parse-error": {
+			kernel: &KernelCustomization{Append: `foo="bar`},
+			err: "invalid kernel customizations:\n" +
+				`invalid kernel command line "foo=\"bar": unterminated quote`,
+		},
+		"bad-values": {
+			kernel: &KernelCustomization{
+				Append: "console=/dev/ttyS0 crashkernel=lots fips=yes",
+			},
+			err: "invalid kernel customizations:\n" +
+				`invalid kernel argument "console=/dev/ttyS0": expected console=<device>[,<options>]` + "\n" +
+				`invalid kernel argument "crashkernel=lots": expected crashkernel=auto, crashkernel=<size>[@<offset>] or crashkernel=<range>:<size>[,...]` + "\n" +
+				`invalid kernel argument "fips=yes": expected fips=0 or fips=1`,
+		},
+		"duplicate": {
+			kernel: &KernelCustomization{
+				Append: "console=tty0",
+				Args: &KernelArgsCustomization{
+					Add: []string{"console=tty0"},
+				},
+			},
+			err: "invalid kernel customizations:\n" +
+				`duplicate kernel argument "console=tty0"`,
+		},
+		"conflict": {
+			kernel: &KernelCustomization{
+				Append: "nosmt=force",
+				Args: &KernelArgsCustomization{
+					Add: []string{"nosmt"},
+				},
+			},
+			err: "invalid kernel customizations:\n" +
+				`conflicting kernel arguments "nosmt=force" and "nosmt"`,
+		},
+		"conflict-dash-underscore": {
+			kernel: &KernelCustomization{
+				Append: "rcu-nocbs=1 rcu_nocbs=2",
+			},
+			err: "invalid kernel customizations:\n" +
+				`conflicting kernel arguments "rcu-nocbs=1" and "rcu_nocbs=2"`,
+		},
+		"added-and-removed": {
+			kernel: &KernelCustomization{
+				Append: "quiet console=ttyS0",
+				Args: &KernelArgsCustomization{
+					Remove: []string{"quiet", "console=tty0", "console=ttyS0"},
+				},
+			},
+			err: "invalid kernel customizations:\n" +
+				`kernel argument "quiet" is both added and removed` + "\n" +
+				`kernel argument "console=ttyS0" is both added and removed`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.kernel.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestKernelCustomizationMergeArgs(t *testing.T) {
+	defaults := []string{"ro", "console=tty0 console=ttyS0,115200n8", "crashkernel=auto", "rhgb quiet", "rd.luks.options=discard"}
+
+	testCases := map[string]struct {
+		kernel   *KernelCustomization
+		expected []string
+		err      string
+	}{
+		"nil": {
+			expected: []string{"ro", "console=tty0", "console=ttyS0,115200n8", "crashkernel=auto", "rhgb", "quiet", "rd.luks.options=discard"},
+		},
+		"replace-and-add": {
+			kernel: &KernelCustomization{
+				Append: "crashkernel=512M console=hvc0",
+				Args: &KernelArgsCustomization{
+					Add: []string{"console=tty0", `foo="a b"`},
+				},
+			},
+			expected: []string{"ro", "console=tty0", "console=ttyS0,115200n8", "crashkernel=512M", "rhgb", "quiet", "rd.luks.options=discard", "console=hvc0", `foo="a b"`},
+		},
+		"repeatable-added": {
+			kernel: &KernelCustomization{
+				Append: "rd.luks.options=tpm2-device=auto systemd.setenv=FOO=1 systemd.setenv=BAR=2",
+			},
+			expected: []string{"ro", "console=tty0", "console=ttyS0,115200n8", "crashkernel=auto", "rhgb", "quiet", "rd.luks.options=discard", "rd.luks.options=tpm2-device=auto", "systemd.setenv=FOO=1", "systemd.setenv=BAR=2"},
+		},
+		"rw-replaces-ro": {
+			kernel: &KernelCustomization{
+				Append: "rw root=/dev/vda3",
+			},
+			expected: []string{"rw", "console=tty0", "console=ttyS0,115200n8", "crashkernel=auto", "rhgb", "quiet", "rd.luks.options=discard", "root=/dev/vda3"},
+		},
+		"remove": {
+			kernel: &KernelCustomization{
+				Args: &KernelArgsCustomization{
+					Remove: []string{"quiet rhgb", "console=tty0", "crashkernel"},
+				},
+			},
+			expected: []string{"ro", "console=ttyS0,115200n8", "rd.luks.options=discard"},
+		},
+		"invalid": {
+			kernel: &KernelCustomization{Append: "fips=2"},
+			err: "invalid kernel customizations:\n" +
+				`invalid kernel argument "fips=2": expected fips=0 or fips=1`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			merged, err := tc.kernel.MergeArgs(defaults)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, merged)
+		})
+	}
+
+	_, err := (&KernelCustomization{}).MergeArgs([]string{`foo="bar`})
+	assert.EqualError(t, err, `invalid default kernel arguments: invalid kernel command line "foo=\"bar": unterminated quote`)
+}
+
+func TestKernelCustomizationDecode(t *testing.T) {
+	tomlData := `
+[customizations.kernel]
+name = "kernel-rt"
+append = "nosmt"
+
+[customizations.kernel.args]
+add = ["console=ttyS0,115200n8"]
+remove = ["rhgb", "quiet"]
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	kernel, err := bp.Customizations.GetKernel()
+	require.NoError(t, err)
+	assert.Equal(t, &KernelCustomization{
+		Name:   "kernel-rt",
+		Append: "nosmt",
+		Args: &KernelArgsCustomization{
+			Add:    []string{"console=ttyS0,115200n8"},
+			Remove: []string{"rhgb", "quiet"},
+		},
+	}, kernel)
+	assert.NoError(t, kernel.Validate())
+}
+
+func TestKernelModulesCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		modules *KernelModulesCustomization
+		err     string
+	}{
+		"nil": {},
+		"happy": {
+			modules: &KernelModulesCustomization{
+				Blacklist: []string{"nouveau", "pcspkr"},
+				Load:      []string{"vfio-pci", "vfio_iommu_type1"},
+				Options: []KernelModuleOptionsCustomization{
+					{Module: "kvm_intel", Options: []string{"nested=1", "enable_shadow_vmcs"}},
+					{Module: "vfio-pci", Options: []string{"ids=10de:1b80,10de:10f0"}},
+				},
+			},
+		},
+		"bad-names": {
+			modules: &KernelModulesCustomization{
+				Blacklist: []string{"nou veau"},
+				Load:      []string{"../vfio"},
+				Options: []KernelModuleOptionsCustomization{
+					{Module: "", Options: []string{"nested=1"}},
+				},
+			},
+			err: "invalid kernel modules customizations:\n" +
+				`invalid kernel module name "nou veau"` + "\n" +
+				`invalid kernel module name "../vfio"` + "\n" +
+				`invalid kernel module name ""`,
+		},
+		"duplicates": {
+			modules: &KernelModulesCustomization{
+				Blacklist: []string{"nouveau", "nouveau"},
+				Load:      []string{"vfio-pci", "vfio_pci"},
+				Options: []KernelModuleOptionsCustomization{
+					{Module: "kvm-intel", Options: []string{"nested=1"}},
+					{Module: "kvm_intel", Options: []string{"ept=0"}},
+				},
+			},
+			err: "invalid kernel modules customizations:\n" +
+				`duplicate kernel module "nouveau" in blacklist` + "\n" +
+				`duplicate kernel module "vfio_pci" in load` + "\n" +
+				`duplicate options for kernel module "kvm_intel"`,
+		},
+		"blacklisted-and-loaded": {
+			modules: &KernelModulesCustomization{
+				Blacklist: []string{"vfio_pci"},
+				Load:      []string{"vfio-pci"},
+			},
+			err: "invalid kernel modules customizations:\n" +
+				`kernel module "vfio-pci" is both blacklisted and loaded`,
+		},
+		"bad-options": {
+			modules: &KernelModulesCustomization{
+				Options: []KernelModuleOptionsCustomization{
+					{Module: "kvm_intel"},
+					{Module: "kvm_amd", Options: []string{"nested = 1"}},
+				},
+			},
+			err: "invalid kernel modules customizations:\n" +
+				`no options for kernel module "kvm_intel"` + "\n" +
+				`invalid option "nested = 1" for kernel module "kvm_amd"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.modules.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+
+	// validated as part of the kernel customization
+	kernel := &KernelCustomization{Modules: &KernelModulesCustomization{Load: []string{"a b"}}}
+	assert.EqualError(t, kernel.Validate(), "invalid kernel modules customizations:\n"+`invalid kernel module name "a b"`)
+}
+
+func TestKernelModulesCustomizationToFsNodeFiles(t *testing.T) {
+	modules := &KernelModulesCustomization{
+		Blacklist: []string{"nouveau", "pcspkr"},
+		Load:      []string{"vfio-pci"},
+		Options: []KernelModuleOptionsCustomization{
+			{Module: "kvm_intel", Options: []string{"nested=1"}},
+			{Module: "vfio-pci", Options: []string{"ids=10de:1b80", "disable_vga=1"}},
+		},
+	}
+
+	files, err := modules.ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.Equal(t, "/etc/modprobe.d/blueprint-blacklist.conf", files[0].Path())
+	assert.Equal(t, "blacklist nouveau\nblacklist pcspkr\n", string(files[0].Data()))
+	assert.Equal(t, "/etc/modprobe.d/blueprint-options.conf", files[1].Path())
+	assert.Equal(t, "options kvm_intel nested=1\noptions vfio-pci ids=10de:1b80 disable_vga=1\n", string(files[1].Data()))
+	assert.Equal(t, "/etc/modules-load.d/blueprint.conf", files[2].Path())
+	assert.Equal(t, "vfio-pci\n", string(files[2].Data()))
+
+	files, err = (&KernelModulesCustomization{Load: []string{"br_netfilter"}}).ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "/etc/modules-load.d/blueprint.conf", files[0].Path())
+
+	var nilModules *KernelModulesCustomization
+	files, err = nilModules.ToFsNodeFiles()
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+
+	_, err = (&KernelModulesCustomization{Blacklist: []string{"a"}, Load: []string{"a"}}).ToFsNodeFiles()
+	assert.Error(t, err)
+}
+
+func TestKernelModulesCustomizationDecode(t *testing.T) {
+	tomlData := `
+[customizations.kernel.modules]
+blacklist = ["nouveau"]
+load = ["vfio-pci"]
+
+[[customizations.kernel.modules.options]]
+module = "kvm_intel"
+options = ["nested=1"]
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	kernel, err := bp.Customizations.GetKernel()
+	require.NoError(t, err)
+	assert.Equal(t, "kernel", kernel.Name)
+	assert.Equal(t, &KernelModulesCustomization{
+		Blacklist: []string{"nouveau"},
+		Load:      []string{"vfio-pci"},
+		Options: []KernelModuleOptionsCustomization{
+			{Module: "kvm_intel", Options: []string{"nested=1"}},
+		},
+	}, kernel.Modules)
+	assert.NoError(t, kernel.Validate())
+}
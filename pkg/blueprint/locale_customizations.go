@@ -0,0 +1,205 @@
+package blueprint
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+type LocaleCustomization struct {
+	Languages []string `json:"languages,omitempty" toml:"languages,omitempty"`
+	Keyboard  *string  `json:"keyboard,omitempty" toml:"keyboard,omitempty"`
+
+	// Keyboard configuration for X11 and Wayland, independent of the console
+	// keymap set in Keyboard.
+	X11Keyboard *X11KeyboardCustomization `json:"x11_keyboard,omitempty" toml:"x11_keyboard,omitempty"`
+}
+
+// X11KeyboardCustomization configures the XKB keyboard settings.
+type X11KeyboardCustomization struct {
+	// XKB layouts, e.g. "us" or "de" (required)
+	Layouts []string `json:"layouts" toml:"layouts"`
+
+	// XKB variants, one for each layout in the same order. Use an empty
+	// string for layouts without a variant.
+	Variants []string `json:"variants,omitempty" toml:"variants,omitempty"`
+
+	// XKB options, e.g. "grp:alt_shift_toggle"
+	Options []string `json:"options,omitempty" toml:"options,omitempty"`
+
+	// XKB keyboard model, e.g. "pc105"
+	Model string `json:"model,omitempty" toml:"model,omitempty"`
+}
+
+const (
+	LocaleConfPath   = "/etc/locale.conf"
+	VconsoleConfPath = "/etc/vconsole.conf"
+)
+
+//go:embed data/vconsole-keymaps.txt
+var vconsoleKeymapsData string
+
+//go:embed data/xkb-layouts.txt
+var xkbLayoutsData string
+
+var vconsoleKeymaps = parseDataTable(vconsoleKeymapsData)
+var xkbLayouts = parseDataTable(xkbLayoutsData)
+
+// glibc locale names: language[_territory][.codeset][@modifier]
+var localeRegex = regexp.MustCompile(`^[a-z]{2,3}(_([A-Z]{2}|[0-9]{3}))?(\.[a-zA-Z0-9_-]+)?(@[a-zA-Z0-9_]+)?$`)
+
+var xkbVariantRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]*$`)
+var xkbModelRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+var xkbOptionRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+:[a-zA-Z0-9_-]+$`)
+
+// parseDataTable returns the set of entries in an embedded data table with
+// one entry per line. Empty lines and lines starting with "#" are ignored.
+func parseDataTable(data string) map[string]bool {
+	table := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		table[line] = true
+	}
+	return table
+}
+
+func validateLocale(locale string) error {
+	switch locale {
+	case "C", "POSIX", "C.UTF-8", "C.utf8":
+		return nil
+	}
+	if !localeRegex.MatchString(locale) {
+		return fmt.Errorf("invalid language %q: must be a locale name such as en_US.UTF-8", locale)
+	}
+	return nil
+}
+
+// Validate checks that all languages are valid locale names and that the
+// keyboard settings refer to known console keymaps and XKB layouts.
+func (l *LocaleCustomization) Validate() error {
+	if l == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, lang := range l.Languages {
+		errs = append(errs, validateLocale(lang))
+	}
+
+	if l.Keyboard != nil && !vconsoleKeymaps[*l.Keyboard] && !xkbLayouts[*l.Keyboard] {
+		errs = append(errs, fmt.Errorf("unknown keyboard %q: must be a console keymap or an XKB layout", *l.Keyboard))
+	}
+
+	if l.X11Keyboard != nil {
+		errs = append(errs, l.X11Keyboard.validate())
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid locale customizations:\n%w", err)
+	}
+	return nil
+}
+
+func (x *X11KeyboardCustomization) validate() error {
+	if len(x.Layouts) == 0 {
+		return fmt.Errorf("x11_keyboard requires at least one layout")
+	}
+	for _, layout := range x.Layouts {
+		if !xkbLayouts[layout] {
+			return fmt.Errorf("unknown XKB layout %q", layout)
+		}
+	}
+
+	if len(x.Variants) > len(x.Layouts) {
+		return fmt.Errorf("x11_keyboard has more variants (%d) than layouts (%d)", len(x.Variants), len(x.Layouts))
+	}
+	for _, variant := range x.Variants {
+		if !xkbVariantRegex.MatchString(variant) {
+			return fmt.Errorf("invalid XKB variant %q", variant)
+		}
+	}
+
+	for _, option := range x.Options {
+		if !xkbOptionRegex.MatchString(option) {
+			return fmt.Errorf("invalid XKB option %q: must be of the form group:option", option)
+		}
+	}
+
+	if x.Model != "" && !xkbModelRegex.MatchString(x.Model) {
+		return fmt.Errorf("invalid XKB model %q", x.Model)
+	}
+
+	return nil
+}
+
+// LocaleConf renders the primary language as locale.conf(5). It returns an
+// empty string when no language is set.
+func (l *LocaleCustomization) LocaleConf() string {
+	if l == nil || len(l.Languages) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("LANG=%s\n", l.Languages[0])
+}
+
+// VconsoleConf renders the console keymap and the XKB settings as
+// vconsole.conf(5). It returns an empty string when no keyboard is set.
+func (l *LocaleCustomization) VconsoleConf() string {
+	if l == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	if l.Keyboard != nil {
+		fmt.Fprintf(&b, "KEYMAP=%s\n", *l.Keyboard)
+	}
+	if x := l.X11Keyboard; x != nil {
+		fmt.Fprintf(&b, "XKBLAYOUT=%s\n", strings.Join(x.Layouts, ","))
+		if x.Model != "" {
+			fmt.Fprintf(&b, "XKBMODEL=%s\n", x.Model)
+		}
+		if len(x.Variants) > 0 {
+			fmt.Fprintf(&b, "XKBVARIANT=%s\n", strings.Join(x.Variants, ","))
+		}
+		if len(x.Options) > 0 {
+			fmt.Fprintf(&b, "XKBOPTIONS=%s\n", strings.Join(x.Options, ","))
+		}
+	}
+	return b.String()
+}
+
+// ToFsNodeFiles validates the customization and returns locale.conf and
+// vconsole.conf for the settings that are customized.
+func (l *LocaleCustomization) ToFsNodeFiles() ([]*fsnode.File, error) {
+	if l == nil {
+		return nil, nil
+	}
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+
+	var files []*fsnode.File
+	for _, f := range []struct {
+		path string
+		data string
+	}{
+		{LocaleConfPath, l.LocaleConf()},
+		{VconsoleConfPath, l.VconsoleConf()},
+	} {
+		if f.data == "" {
+			continue
+		}
+		file, err := fsnode.NewFile(f.path, nil, nil, nil, []byte(f.data))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -0,0 +1,205 @@
+package blueprint
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestValidateLocale(t *testing.T) {
+	valid := []string{"en_US.UTF-8", "en_US.utf8", "de_DE", "en", "es_419.UTF-8", "sr_RS@latin", "ca_ES.UTF-8@valencia", "ast_ES", "C", "C.UTF-8", "POSIX"}
+	for _, locale := range valid {
+		assert.NoError(t, validateLocale(locale), locale)
+	}
+
+	invalid := []string{"", "enUS", "en-US", "EN_us", "en_US.", "en_US@", "english", "c"}
+	for _, locale := range invalid {
+		assert.Error(t, validateLocale(locale), locale)
+	}
+}
+
+func TestKeyboardDataTables(t *testing.T) {
+	assert.True(t, vconsoleKeymaps["us"])
+	assert.True(t, vconsoleKeymaps["de-latin1-nodeadkeys"])
+	assert.False(t, vconsoleKeymaps["# Console keymaps accepted by the keyboard customization, one per line."])
+	assert.False(t, vconsoleKeymaps[""])
+
+	assert.True(t, xkbLayouts["us"])
+	assert.True(t, xkbLayouts["latam"])
+	assert.False(t, xkbLayouts["de-latin1"])
+}
+
+func TestLocaleCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		locale *LocaleCustomization
+		err    string
+	}{
+		"nil": {},
+		"happy": {
+			locale: &LocaleCustomization{
+				Languages: []string{"en_US.UTF-8", "de_DE.UTF-8"},
+				Keyboard:  common.ToPtr("de-latin1-nodeadkeys"),
+				X11Keyboard: &X11KeyboardCustomization{
+					Layouts:  []string{"us", "de"},
+					Variants: []string{"", "nodeadkeys"},
+					Options:  []string{"grp:alt_shift_toggle", "ctrl:nocaps"},
+					Model:    "pc105",
+				},
+			},
+		},
+		"xkb-layout-as-keyboard": {
+			locale: &LocaleCustomization{
+				Keyboard: common.ToPtr("latam"),
+			},
+		},
+		"bad-language": {
+			locale: &LocaleCustomization{
+				Languages: []string{"en_US.UTF-8", "english"},
+			},
+			err: "invalid locale customizations:\n" +
+				`invalid language "english": must be a locale name such as en_US.UTF-8`,
+		},
+		"bad-keyboard": {
+			locale: &LocaleCustomization{
+				Keyboard: common.ToPtr("qwerty"),
+			},
+			err: "invalid locale customizations:\n" +
+				`unknown keyboard "qwerty": must be a console keymap or an XKB layout`,
+		},
+		"x11-no-layouts": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Model: "pc105"},
+			},
+			err: "invalid locale customizations:\n" +
+				"x11_keyboard requires at least one layout",
+		},
+		"x11-bad-layout": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Layouts: []string{"us", "de-latin1"}},
+			},
+			err: "invalid locale customizations:\n" +
+				`unknown XKB layout "de-latin1"`,
+		},
+		"x11-too-many-variants": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Layouts: []string{"us"}, Variants: []string{"intl", "dvorak"}},
+			},
+			err: "invalid locale customizations:\n" +
+				"x11_keyboard has more variants (2) than layouts (1)",
+		},
+		"x11-bad-variant": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Layouts: []string{"us"}, Variants: []string{"alt intl"}},
+			},
+			err: "invalid locale customizations:\n" +
+				`invalid XKB variant "alt intl"`,
+		},
+		"x11-bad-option": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Layouts: []string{"us"}, Options: []string{"nocaps"}},
+			},
+			err: "invalid locale customizations:\n" +
+				`invalid XKB option "nocaps": must be of the form group:option`,
+		},
+		"x11-bad-model": {
+			locale: &LocaleCustomization{
+				X11Keyboard: &X11KeyboardCustomization{Layouts: []string{"us"}, Model: "pc 105"},
+			},
+			err: "invalid locale customizations:\n" +
+				`invalid XKB model "pc 105"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.locale.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLocaleCustomizationToFsNodeFiles(t *testing.T) {
+	locale := &LocaleCustomization{
+		Languages: []string{"de_DE.UTF-8", "en_US.UTF-8"},
+		Keyboard:  common.ToPtr("de-nodeadkeys"),
+		X11Keyboard: &X11KeyboardCustomization{
+			Layouts:  []string{"de", "us"},
+			Variants: []string{"nodeadkeys"},
+			Options:  []string{"grp:alt_shift_toggle", "compose:ralt"},
+			Model:    "pc105",
+		},
+	}
+
+	files, err := locale.ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "/etc/locale.conf", files[0].Path())
+	assert.Equal(t, "LANG=de_DE.UTF-8\n", string(files[0].Data()))
+	assert.Equal(t, "/etc/vconsole.conf", files[1].Path())
+	assert.Equal(t, `KEYMAP=de-nodeadkeys
+XKBLAYOUT=de,us
+XKBMODEL=pc105
+XKBVARIANT=nodeadkeys
+XKBOPTIONS=grp:alt_shift_toggle,compose:ralt
+`, string(files[1].Data()))
+
+	// only a keyboard
+	files, err = (&LocaleCustomization{Keyboard: common.ToPtr("us")}).ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "/etc/vconsole.conf", files[0].Path())
+	assert.Equal(t, "KEYMAP=us\n", string(files[0].Data()))
+
+	var nilLocale *LocaleCustomization
+	files, err = nilLocale.ToFsNodeFiles()
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+	assert.Equal(t, "", nilLocale.LocaleConf())
+	assert.Equal(t, "", nilLocale.VconsoleConf())
+
+	_, err = (&LocaleCustomization{Languages: []string{"bogus locale"}}).ToFsNodeFiles()
+	assert.Error(t, err)
+}
+
+func TestGetLocale(t *testing.T) {
+	tomlData := `
+[customizations.locale]
+languages = ["en_GB.UTF-8"]
+keyboard = "uk"
+
+[customizations.locale.x11_keyboard]
+layouts = ["gb"]
+options = ["ctrl:nocaps"]
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	locale, err := bp.Customizations.GetLocale()
+	require.NoError(t, err)
+	assert.Equal(t, &LocaleCustomization{
+		Languages: []string{"en_GB.UTF-8"},
+		Keyboard:  common.ToPtr("uk"),
+		X11Keyboard: &X11KeyboardCustomization{
+			Layouts: []string{"gb"},
+			Options: []string{"ctrl:nocaps"},
+		},
+	}, locale)
+
+	bp.Customizations.Locale.Keyboard = common.ToPtr("klingon")
+	_, err = bp.Customizations.GetLocale()
+	assert.Error(t, err)
+
+	var nilCustomizations *Customizations
+	locale, err = nilCustomizations.GetLocale()
+	assert.NoError(t, err)
+	assert.Nil(t, locale)
+}
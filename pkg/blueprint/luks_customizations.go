@@ -0,0 +1,374 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/images/pkg/datasizes"
+)
+
+const (
+	LUKSVersion1 = "luks1"
+	LUKSVersion2 = "luks2"
+
+	PBKDFArgon2id = "argon2id"
+	PBKDFArgon2i  = "argon2i"
+	PBKDFPBKDF2   = "pbkdf2"
+
+	// cryptsetup limits the memory cost of argon2 to 4 GiB
+	maxPBKDFMemoryKiB = 4 * 1024 * 1024
+
+	// Size of the LUKS header at the start of an encrypted partition. The
+	// LUKS2 header (including the keyslot area) is 16 MiB by default, the
+	// LUKS1 header is 2 MiB with the default key size.
+	luks2HeaderSize = 16 * datasizes.MiB
+	luks1HeaderSize = 2 * datasizes.MiB
+)
+
+// These mountpoints are needed by the bootloader and must not be encrypted.
+var unencryptedMountpoints = []string{
+	"/boot",
+	"/boot/efi",
+}
+
+// LUKSCustomization encrypts the payload of a partition (a plain filesystem,
+// an LVM volume group, or a btrfs volume) with LUKS.
+type LUKSCustomization struct {
+	// LUKS format version: luks1 or luks2 (optional, defaults to luks2).
+	Version string `json:"version,omitempty" toml:"version,omitempty"`
+
+	// Cipher specification in cryptsetup format, e.g. aes-xts-plain64
+	// (optional, defaults to the cryptsetup default).
+	Cipher string `json:"cipher,omitempty" toml:"cipher,omitempty"`
+
+	// Password-based key derivation function for the passphrase keyslot
+	// (optional).
+	PBKDF *PBKDFCustomization `json:"pbkdf,omitempty" toml:"pbkdf,omitempty"`
+
+	// Reference to the secret holding the initial passphrase (required).
+	// Passphrases cannot be stored in the blueprint itself, see
+	// [ParseSecretReference] for the supported references.
+	Passphrase string `json:"passphrase,omitempty" toml:"passphrase,omitempty"`
+
+	// Automatic unlocking with clevis (optional).
+	Clevis *ClevisCustomization `json:"clevis,omitempty" toml:"clevis,omitempty"`
+}
+
+// PBKDFCustomization defines the parameters of the password-based key
+// derivation function.
+type PBKDFCustomization struct {
+	// argon2id, argon2i or pbkdf2 (LUKS1 only supports pbkdf2).
+	Type string `json:"type,omitempty" toml:"type,omitempty"`
+
+	// Number of iterations (time cost for argon2).
+	Iterations uint `json:"iterations,omitempty" toml:"iterations,omitempty"`
+
+	// Memory cost in KiB (argon2 only).
+	Memory uint `json:"memory,omitempty" toml:"memory,omitempty"`
+
+	// Number of parallel threads (argon2 only).
+	Parallelism uint `json:"parallelism,omitempty" toml:"parallelism,omitempty"`
+}
+
+// ClevisCustomization binds the LUKS device to one or more clevis pins. With
+// more than one pin, they are combined with Shamir's Secret Sharing (the sss
+// pin) and Threshold pins are required to unlock the device.
+type ClevisCustomization struct {
+	TPM2 *ClevisTPM2Pin  `json:"tpm2,omitempty" toml:"tpm2,omitempty"`
+	Tang []ClevisTangPin `json:"tang,omitempty" toml:"tang,omitempty"`
+
+	// Number of pins required to unlock the device (optional, defaults to
+	// 1).
+	Threshold uint `json:"threshold,omitempty" toml:"threshold,omitempty"`
+
+	// Remove the passphrase after binding the pins, so the device can only
+	// be unlocked by clevis.
+	RemovePassphrase bool `json:"remove_passphrase,omitempty" toml:"remove_passphrase,omitempty"`
+}
+
+// ClevisTPM2Pin unlocks the device with the TPM2 chip, optionally sealed
+// against a set of PCRs.
+type ClevisTPM2Pin struct {
+	// PCR bank, e.g. sha256 (optional).
+	PCRBank string `json:"pcr_bank,omitempty" toml:"pcr_bank,omitempty"`
+	// PCRs to seal against (optional).
+	PCRIDs []int `json:"pcr_ids,omitempty" toml:"pcr_ids,omitempty"`
+}
+
+// ClevisTangPin unlocks the device with a tang server.
+type ClevisTangPin struct {
+	URL string `json:"url" toml:"url"`
+	// Thumbprint of the trusted server signing key (optional, but without
+	// it the key is fetched and trusted at build time).
+	Thumbprint string `json:"thumbprint,omitempty" toml:"thumbprint,omitempty"`
+}
+
+var (
+	validLUKSCipher  = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)+(:[a-z0-9]+)?$`)
+	validPCRBank     = regexp.MustCompile(`^sha(1|256|384|512)$`)
+	validEnvVarName  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	validThumbprint  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	validPBKDFTypes  = []string{PBKDFArgon2id, PBKDFArgon2i, PBKDFPBKDF2}
+	validLUKSVersion = []string{"", LUKSVersion1, LUKSVersion2}
+)
+
+// Validate checks the LUKS parameters.
+func (l *LUKSCustomization) Validate() error {
+	if l == nil {
+		return nil
+	}
+
+	var errs []error
+	if !slices.Contains(validLUKSVersion, l.Version) {
+		errs = append(errs, fmt.Errorf("unknown LUKS version %q (valid: %s, %s)", l.Version, LUKSVersion1, LUKSVersion2))
+	}
+	if l.Cipher != "" && !validLUKSCipher.MatchString(l.Cipher) {
+		errs = append(errs, fmt.Errorf("invalid LUKS cipher %q (expected cipher-mode, e.g. aes-xts-plain64)", l.Cipher))
+	}
+	if err := l.PBKDF.validate(l.Version); err != nil {
+		errs = append(errs, err)
+	}
+	if l.Passphrase == "" {
+		errs = append(errs, fmt.Errorf("LUKS passphrase secret reference is required"))
+	} else if _, err := ParseSecretReference(l.Passphrase); err != nil {
+		errs = append(errs, fmt.Errorf("invalid LUKS passphrase: %w", err))
+	}
+	if err := l.Clevis.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// HeaderSize returns the space taken by the LUKS header at the start of the
+// encrypted partition.
+func (l *LUKSCustomization) HeaderSize() uint64 {
+	if l == nil {
+		return 0
+	}
+	if l.Version == LUKSVersion1 {
+		return luks1HeaderSize
+	}
+	return luks2HeaderSize
+}
+
+func (p *PBKDFCustomization) validate(version string) error {
+	if p == nil {
+		return nil
+	}
+	if p.Type != "" && !slices.Contains(validPBKDFTypes, p.Type) {
+		return fmt.Errorf("unknown LUKS pbkdf type %q (valid: %s)", p.Type, strings.Join(validPBKDFTypes, ", "))
+	}
+	if version == LUKSVersion1 && p.Type != "" && p.Type != PBKDFPBKDF2 {
+		return fmt.Errorf("LUKS pbkdf type %q is not supported by %s (only %s)", p.Type, LUKSVersion1, PBKDFPBKDF2)
+	}
+
+	// argon2id is the LUKS2 default, pbkdf2 the only choice for LUKS1
+	isArgon2 := p.Type == PBKDFArgon2id || p.Type == PBKDFArgon2i || (p.Type == "" && version != LUKSVersion1)
+	if !isArgon2 && (p.Memory != 0 || p.Parallelism != 0) {
+		return fmt.Errorf("LUKS pbkdf memory and parallelism are only supported for argon2")
+	}
+	if p.Memory > maxPBKDFMemoryKiB {
+		return fmt.Errorf("LUKS pbkdf memory %d KiB exceeds the maximum of %d KiB", p.Memory, maxPBKDFMemoryKiB)
+	}
+	return nil
+}
+
+func (c *ClevisCustomization) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	pins := len(c.Tang)
+	if c.TPM2 != nil {
+		pins++
+		if c.TPM2.PCRBank != "" && !validPCRBank.MatchString(c.TPM2.PCRBank) {
+			return fmt.Errorf("invalid clevis tpm2 pcr_bank %q", c.TPM2.PCRBank)
+		}
+		for _, pcr := range c.TPM2.PCRIDs {
+			if pcr < 0 || pcr > 23 {
+				return fmt.Errorf("invalid clevis tpm2 pcr id %d (must be between 0 and 23)", pcr)
+			}
+		}
+	}
+	if pins == 0 {
+		return fmt.Errorf("clevis requires at least one tpm2 or tang pin")
+	}
+	if c.Threshold > uint(pins) {
+		return fmt.Errorf("clevis threshold %d is larger than the number of pins (%d)", c.Threshold, pins)
+	}
+
+	for _, tang := range c.Tang {
+		u, err := url.Parse(tang.URL)
+		if err != nil {
+			return fmt.Errorf("invalid clevis tang url %q: %w", tang.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid clevis tang url %q: must be an http or https url", tang.URL)
+		}
+		if tang.Thumbprint != "" && !validThumbprint.MatchString(tang.Thumbprint) {
+			return fmt.Errorf("invalid clevis tang thumbprint %q", tang.Thumbprint)
+		}
+	}
+	return nil
+}
+
+// Pin returns the clevis pin name and its JSON configuration, as used by
+// "clevis luks bind -d DEV PIN CONFIG". More than one pin is combined with
+// the sss pin.
+func (c *ClevisCustomization) Pin() (string, string, error) {
+	if err := c.validate(); err != nil {
+		return "", "", err
+	}
+
+	type tpm2Config struct {
+		PCRBank string `json:"pcr_bank,omitempty"`
+		PCRIDs  string `json:"pcr_ids,omitempty"`
+	}
+	type tangConfig struct {
+		URL        string `json:"url"`
+		Thumbprint string `json:"thp,omitempty"`
+	}
+
+	var tpm2 *tpm2Config
+	if c.TPM2 != nil {
+		ids := make([]string, 0, len(c.TPM2.PCRIDs))
+		for _, pcr := range c.TPM2.PCRIDs {
+			ids = append(ids, fmt.Sprint(pcr))
+		}
+		tpm2 = &tpm2Config{PCRBank: c.TPM2.PCRBank, PCRIDs: strings.Join(ids, ",")}
+	}
+	tang := make([]tangConfig, 0, len(c.Tang))
+	for _, t := range c.Tang {
+		tang = append(tang, tangConfig{URL: t.URL, Thumbprint: t.Thumbprint})
+	}
+
+	var pin string
+	var config any
+	switch {
+	case tpm2 != nil && len(tang) == 0:
+		pin, config = "tpm2", tpm2
+	case tpm2 == nil && len(tang) == 1:
+		pin, config = "tang", tang[0]
+	default:
+		threshold := c.Threshold
+		if threshold == 0 {
+			threshold = 1
+		}
+		pins := map[string]any{}
+		if tpm2 != nil {
+			pins["tpm2"] = tpm2
+		}
+		if len(tang) > 0 {
+			pins["tang"] = tang
+		}
+		pin, config = "sss", map[string]any{"t": threshold, "pins": pins}
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", "", err
+	}
+	return pin, string(data), nil
+}
+
+// SecretReference points to a secret that is provided when the image is
+// built instead of being stored in the blueprint. Supported references are
+// "env:NAME" for an environment variable and "file:/path" for a file.
+type SecretReference struct {
+	// env or file
+	Source string
+	// Name of the environment variable or path of the file.
+	Name string
+}
+
+// ParseSecretReference parses a secret reference of the form "env:NAME" or
+// "file:/absolute/path".
+func ParseSecretReference(ref string) (SecretReference, error) {
+	source, name, ok := strings.Cut(ref, ":")
+	if !ok {
+		return SecretReference{}, fmt.Errorf("secret reference %q must be of the form env:NAME or file:/path", ref)
+	}
+	switch source {
+	case "env":
+		if !validEnvVarName.MatchString(name) {
+			return SecretReference{}, fmt.Errorf("invalid environment variable name %q in secret reference", name)
+		}
+	case "file":
+		if !filepath.IsAbs(name) {
+			return SecretReference{}, fmt.Errorf("secret reference file path %q must be absolute", name)
+		}
+	default:
+		return SecretReference{}, fmt.Errorf("unknown secret reference source %q (valid: env, file)", source)
+	}
+	return SecretReference{Source: source, Name: name}, nil
+}
+
+// SecretResolver returns the value of a secret reference. Secrets are not
+// resolved by this package: the caller knows which environment variables and
+// files a blueprint may reference and must reject all others, otherwise a
+// blueprint could read arbitrary secrets of the build host into the image.
+type SecretResolver func(ref SecretReference) (string, error)
+
+// ResolvePassphrase returns the passphrase using the resolver supplied by the
+// caller.
+func (l *LUKSCustomization) ResolvePassphrase(resolve SecretResolver) (string, error) {
+	ref, err := ParseSecretReference(l.Passphrase)
+	if err != nil {
+		return "", fmt.Errorf("invalid LUKS passphrase: %w", err)
+	}
+	value, err := resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve LUKS passphrase %s: %w", ref, err)
+	}
+	if value == "" {
+		return "", fmt.Errorf("LUKS passphrase %s is empty", ref)
+	}
+	return value, nil
+}
+
+func (s SecretReference) String() string {
+	return s.Source + ":" + s.Name
+}
+
+// mountpoints returns all mountpoints of the partition, including those of its
+// logical volumes and subvolumes.
+func (p *PartitionCustomization) mountpoints() []string {
+	var mountpoints []string
+	if p.Mountpoint != "" {
+		mountpoints = append(mountpoints, p.Mountpoint)
+	}
+	for _, lv := range p.LogicalVolumes {
+		if lv.Mountpoint != "" {
+			mountpoints = append(mountpoints, lv.Mountpoint)
+		}
+	}
+	for _, subvol := range p.Subvolumes {
+		if subvol.Mountpoint != "" {
+			mountpoints = append(mountpoints, subvol.Mountpoint)
+		}
+	}
+	return mountpoints
+}
+
+// validateEncryption checks the encryption parameters of the partition and
+// that no mountpoint needed by the bootloader is encrypted.
+func (p *PartitionCustomization) validateEncryption() error {
+	if p.Encryption == nil {
+		return nil
+	}
+	if err := p.Encryption.Validate(); err != nil {
+		return fmt.Errorf("invalid encryption for partition: %w", err)
+	}
+	for _, mp := range p.mountpoints() {
+		if slices.Contains(unencryptedMountpoints, mp) {
+			return fmt.Errorf("mountpoint %q must not be on an encrypted partition", mp)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package blueprint_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+)
+
+func TestLUKSCustomizationValidate(t *testing.T) {
+	type testCase struct {
+		luks        *blueprint.LUKSCustomization
+		expectedErr string
+	}
+
+	testCases := map[string]testCase{
+		"nil": {
+			luks: nil,
+		},
+		"minimal": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:LUKS_PASSPHRASE",
+			},
+		},
+		"full": {
+			luks: &blueprint.LUKSCustomization{
+				Version: "luks2",
+				Cipher:  "aes-xts-plain64",
+				PBKDF: &blueprint.PBKDFCustomization{
+					Type:        "argon2id",
+					Iterations:  4,
+					Memory:      1048576,
+					Parallelism: 4,
+				},
+				Passphrase: "file:/run/secrets/luks",
+				Clevis: &blueprint.ClevisCustomization{
+					TPM2: &blueprint.ClevisTPM2Pin{PCRBank: "sha256", PCRIDs: []int{0, 7}},
+					Tang: []blueprint.ClevisTangPin{
+						{URL: "http://tang.example.com", Thumbprint: "x100_1k6GPiDOaMlL3WbpCjHOy9ul1bSfdhI3M08wO0"},
+					},
+					Threshold:        2,
+					RemovePassphrase: true,
+				},
+			},
+		},
+		"luks1-pbkdf2": {
+			luks: &blueprint.LUKSCustomization{
+				Version:    "luks1",
+				Cipher:     "aes-cbc-essiv:sha256",
+				PBKDF:      &blueprint.PBKDFCustomization{Type: "pbkdf2", Iterations: 100000},
+				Passphrase: "env:PW",
+			},
+		},
+		"bad-version-and-cipher": {
+			luks: &blueprint.LUKSCustomization{
+				Version:    "2",
+				Cipher:     "AES XTS",
+				Passphrase: "env:PW",
+			},
+			expectedErr: "unknown LUKS version \"2\" (valid: luks1, luks2)\ninvalid LUKS cipher \"AES XTS\" (expected cipher-mode, e.g. aes-xts-plain64)",
+		},
+		"bad-pbkdf-type": {
+			luks: &blueprint.LUKSCustomization{
+				PBKDF:      &blueprint.PBKDFCustomization{Type: "scrypt"},
+				Passphrase: "env:PW",
+			},
+			expectedErr: "unknown LUKS pbkdf type \"scrypt\" (valid: argon2id, argon2i, pbkdf2)",
+		},
+		"luks1-argon2": {
+			luks: &blueprint.LUKSCustomization{
+				Version:    "luks1",
+				PBKDF:      &blueprint.PBKDFCustomization{Type: "argon2id"},
+				Passphrase: "env:PW",
+			},
+			expectedErr: "LUKS pbkdf type \"argon2id\" is not supported by luks1 (only pbkdf2)",
+		},
+		"pbkdf2-memory": {
+			luks: &blueprint.LUKSCustomization{
+				PBKDF:      &blueprint.PBKDFCustomization{Type: "pbkdf2", Memory: 1024},
+				Passphrase: "env:PW",
+			},
+			expectedErr: "LUKS pbkdf memory and parallelism are only supported for argon2",
+		},
+		"argon2-memory-too-large": {
+			luks: &blueprint.LUKSCustomization{
+				PBKDF:      &blueprint.PBKDFCustomization{Memory: 8 * 1024 * 1024},
+				Passphrase: "env:PW",
+			},
+			expectedErr: "LUKS pbkdf memory 8388608 KiB exceeds the maximum of 4194304 KiB",
+		},
+		"literal-passphrase": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "hunter2",
+			},
+			expectedErr: "invalid LUKS passphrase: secret reference \"hunter2\" must be of the form env:NAME or file:/path",
+		},
+		"clevis-no-pins": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:PW",
+				Clevis:     &blueprint.ClevisCustomization{RemovePassphrase: true},
+			},
+			expectedErr: "clevis requires at least one tpm2 or tang pin",
+		},
+		"clevis-threshold": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:PW",
+				Clevis: &blueprint.ClevisCustomization{
+					TPM2:      &blueprint.ClevisTPM2Pin{},
+					Threshold: 2,
+				},
+			},
+			expectedErr: "clevis threshold 2 is larger than the number of pins (1)",
+		},
+		"clevis-bad-pcr": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:PW",
+				Clevis: &blueprint.ClevisCustomization{
+					TPM2: &blueprint.ClevisTPM2Pin{PCRIDs: []int{24}},
+				},
+			},
+			expectedErr: "invalid clevis tpm2 pcr id 24 (must be between 0 and 23)",
+		},
+		"clevis-bad-pcr-bank": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:PW",
+				Clevis: &blueprint.ClevisCustomization{
+					TPM2: &blueprint.ClevisTPM2Pin{PCRBank: "md5"},
+				},
+			},
+			expectedErr: "invalid clevis tpm2 pcr_bank \"md5\"",
+		},
+		"clevis-bad-tang-url": {
+			luks: &blueprint.LUKSCustomization{
+				Passphrase: "env:PW",
+				Clevis: &blueprint.ClevisCustomization{
+					Tang: []blueprint.ClevisTangPin{{URL: "tang.example.com"}},
+				},
+			},
+			expectedErr: "invalid clevis tang url \"tang.example.com\": must be an http or https url",
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			err := tc.luks.Validate()
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLUKSCustomizationHeaderSize(t *testing.T) {
+	var luks *blueprint.LUKSCustomization
+	assert.Equal(t, uint64(0), luks.HeaderSize())
+	assert.Equal(t, uint64(16*1024*1024), (&blueprint.LUKSCustomization{}).HeaderSize())
+	assert.Equal(t, uint64(2*1024*1024), (&blueprint.LUKSCustomization{Version: "luks1"}).HeaderSize())
+}
+
+func TestClevisCustomizationPin(t *testing.T) {
+	type testCase struct {
+		clevis         *blueprint.ClevisCustomization
+		expectedPin    string
+		expectedConfig string
+	}
+
+	testCases := map[string]testCase{
+		"tpm2": {
+			clevis:         &blueprint.ClevisCustomization{TPM2: &blueprint.ClevisTPM2Pin{}},
+			expectedPin:    "tpm2",
+			expectedConfig: `{}`,
+		},
+		"tpm2-pcrs": {
+			clevis: &blueprint.ClevisCustomization{
+				TPM2: &blueprint.ClevisTPM2Pin{PCRBank: "sha256", PCRIDs: []int{0, 7}},
+			},
+			expectedPin:    "tpm2",
+			expectedConfig: `{"pcr_bank":"sha256","pcr_ids":"0,7"}`,
+		},
+		"tang": {
+			clevis: &blueprint.ClevisCustomization{
+				Tang: []blueprint.ClevisTangPin{{URL: "https://tang.example.com", Thumbprint: "abc"}},
+			},
+			expectedPin:    "tang",
+			expectedConfig: `{"url":"https://tang.example.com","thp":"abc"}`,
+		},
+		"sss": {
+			clevis: &blueprint.ClevisCustomization{
+				TPM2: &blueprint.ClevisTPM2Pin{PCRIDs: []int{7}},
+				Tang: []blueprint.ClevisTangPin{
+					{URL: "https://tang1.example.com"},
+					{URL: "https://tang2.example.com"},
+				},
+				Threshold: 2,
+			},
+			expectedPin:    "sss",
+			expectedConfig: `{"pins":{"tang":[{"url":"https://tang1.example.com"},{"url":"https://tang2.example.com"}],"tpm2":{"pcr_ids":"7"}},"t":2}`,
+		},
+		"sss-default-threshold": {
+			clevis: &blueprint.ClevisCustomization{
+				Tang: []blueprint.ClevisTangPin{
+					{URL: "http://tang1.example.com"},
+					{URL: "http://tang2.example.com"},
+				},
+			},
+			expectedPin:    "sss",
+			expectedConfig: `{"pins":{"tang":[{"url":"http://tang1.example.com"},{"url":"http://tang2.example.com"}]},"t":1}`,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			pin, config, err := tc.clevis.Pin()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPin, pin)
+			assert.JSONEq(t, tc.expectedConfig, config)
+		})
+	}
+
+	_, _, err := (&blueprint.ClevisCustomization{}).Pin()
+	assert.EqualError(t, err, "clevis requires at least one tpm2 or tang pin")
+}
+
+func TestParseSecretReference(t *testing.T) {
+	type testCase struct {
+		ref         string
+		expected    blueprint.SecretReference
+		expectedErr string
+	}
+
+	testCases := map[string]testCase{
+		"env": {
+			ref:      "env:LUKS_PASSPHRASE",
+			expected: blueprint.SecretReference{Source: "env", Name: "LUKS_PASSPHRASE"},
+		},
+		"file": {
+			ref:      "file:/run/secrets/luks",
+			expected: blueprint.SecretReference{Source: "file", Name: "/run/secrets/luks"},
+		},
+		"plain": {
+			ref:         "secret",
+			expectedErr: `secret reference "secret" must be of the form env:NAME or file:/path`,
+		},
+		"bad-env": {
+			ref:         "env:1PW",
+			expectedErr: `invalid environment variable name "1PW" in secret reference`,
+		},
+		"relative-file": {
+			ref:         "file:secrets/luks",
+			expectedErr: `secret reference file path "secrets/luks" must be absolute`,
+		},
+		"unknown-source": {
+			ref:         "vault:luks",
+			expectedErr: `unknown secret reference source "vault" (valid: env, file)`,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			ref, err := blueprint.ParseSecretReference(tc.ref)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ref)
+			assert.Equal(t, tc.ref, ref.String())
+		})
+	}
+}
+
+func TestLUKSCustomizationResolvePassphrase(t *testing.T) {
+	// the caller decides which secrets can be referenced
+	resolver := func(ref blueprint.SecretReference) (string, error) {
+		switch ref.String() {
+		case "env:LUKS_PASSPHRASE":
+			return "from-env", nil
+		case "file:/run/secrets/empty":
+			return "", nil
+		}
+		return "", fmt.Errorf("secret %s is not allowed", ref)
+	}
+
+	luks := &blueprint.LUKSCustomization{Passphrase: "env:LUKS_PASSPHRASE"}
+	value, err := luks.ResolvePassphrase(resolver)
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", value)
+
+	luks.Passphrase = "file:/etc/shadow"
+	_, err = luks.ResolvePassphrase(resolver)
+	assert.EqualError(t, err, "cannot resolve LUKS passphrase file:/etc/shadow: secret file:/etc/shadow is not allowed")
+
+	luks.Passphrase = "file:/run/secrets/empty"
+	_, err = luks.ResolvePassphrase(resolver)
+	assert.EqualError(t, err, "LUKS passphrase file:/run/secrets/empty is empty")
+
+	luks.Passphrase = "hunter2"
+	_, err = luks.ResolvePassphrase(resolver)
+	assert.EqualError(t, err, `invalid LUKS passphrase: secret reference "hunter2" must be of the form env:NAME or file:/path`)
+}
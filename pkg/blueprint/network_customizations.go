@@ -0,0 +1,462 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// NetworkCustomization describes NetworkManager connection profiles.
+type NetworkCustomization struct {
+	Connections []NetworkConnectionCustomization `json:"connections,omitempty" toml:"connections,omitempty"`
+}
+
+// NetworkConnectionCustomization is a single NetworkManager connection
+// profile.
+type NetworkConnectionCustomization struct {
+	// Connection name, also used as the name of the keyfile (required)
+	Name string `json:"name" toml:"name"`
+
+	// Connection type: "ethernet", "bond", "vlan" or "bridge" (required)
+	Type string `json:"type" toml:"type"`
+
+	// Name of the interface the connection applies to. Required for bonds
+	// and bridges, for which it is the name of the created device.
+	InterfaceName string `json:"interface_name,omitempty" toml:"interface_name,omitempty"`
+
+	// MAC address of the interface the connection applies to. Only
+	// supported for ethernet connections.
+	MACAddress string `json:"mac_address,omitempty" toml:"mac_address,omitempty"`
+
+	// Activate the connection automatically, defaults to true
+	Autoconnect *bool `json:"autoconnect,omitempty" toml:"autoconnect,omitempty"`
+
+	// Name of the bond or bridge connection this connection is a port of.
+	// Ports have no IP configuration of their own.
+	Controller string `json:"controller,omitempty" toml:"controller,omitempty"`
+
+	// VLAN settings, required for vlan connections
+	VLAN *NetworkVLANCustomization `json:"vlan,omitempty" toml:"vlan,omitempty"`
+
+	// Bond settings, optional for bond connections
+	Bond *NetworkBondCustomization `json:"bond,omitempty" toml:"bond,omitempty"`
+
+	IPv4 *NetworkIPCustomization `json:"ipv4,omitempty" toml:"ipv4,omitempty"`
+	IPv6 *NetworkIPCustomization `json:"ipv6,omitempty" toml:"ipv6,omitempty"`
+}
+
+type NetworkVLANCustomization struct {
+	// VLAN id between 1 and 4094 (required)
+	ID uint16 `json:"id" toml:"id"`
+
+	// Name of the connection of the parent interface (required)
+	Parent string `json:"parent" toml:"parent"`
+}
+
+type NetworkBondCustomization struct {
+	// Bonding mode, e.g. "active-backup" or "802.3ad". Defaults to
+	// "balance-rr".
+	Mode string `json:"mode,omitempty" toml:"mode,omitempty"`
+
+	// Additional bonding options, e.g. "miimon=100"
+	Options []string `json:"options,omitempty" toml:"options,omitempty"`
+}
+
+// NetworkIPCustomization is the IPv4 or IPv6 configuration of a connection.
+type NetworkIPCustomization struct {
+	// "auto" (DHCP or SLAAC, default), "manual" (static) or "disabled"
+	Method string `json:"method,omitempty" toml:"method,omitempty"`
+
+	// Addresses in CIDR notation, required for the manual method
+	Addresses []string `json:"addresses,omitempty" toml:"addresses,omitempty"`
+
+	// Default gateway, must be inside the subnet of one of the addresses
+	Gateway string `json:"gateway,omitempty" toml:"gateway,omitempty"`
+
+	// DNS server addresses
+	DNS []string `json:"dns,omitempty" toml:"dns,omitempty"`
+
+	// DNS search domains
+	DNSSearch []string `json:"dns_search,omitempty" toml:"dns_search,omitempty"`
+
+	// Static routes
+	Routes []NetworkRouteCustomization `json:"routes,omitempty" toml:"routes,omitempty"`
+}
+
+type NetworkRouteCustomization struct {
+	// Destination network in CIDR notation (required)
+	Destination string `json:"destination" toml:"destination"`
+
+	// Next hop, defaults to a direct route
+	NextHop string `json:"next_hop,omitempty" toml:"next_hop,omitempty"`
+
+	// Route metric
+	Metric *uint32 `json:"metric,omitempty" toml:"metric,omitempty"`
+}
+
+const (
+	NetworkConnectionTypeEthernet = "ethernet"
+	NetworkConnectionTypeBond     = "bond"
+	NetworkConnectionTypeVLAN     = "vlan"
+	NetworkConnectionTypeBridge   = "bridge"
+)
+
+const (
+	NetworkIPMethodAuto     = "auto"
+	NetworkIPMethodManual   = "manual"
+	NetworkIPMethodDisabled = "disabled"
+)
+
+// NetworkManagerConnectionsDir is the directory of the keyfiles in the image.
+const NetworkManagerConnectionsDir = "/etc/NetworkManager/system-connections"
+
+var networkBondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+// namespace for the connection UUIDs, so the same blueprint always produces
+// the same keyfiles
+var networkConnectionUUIDNamespace = uuid.MustParse("1d7a3a1e-5ab4-4c38-9a4e-7c1b3f0e6a52")
+
+// UUID returns the UUID of the connection, derived from its name.
+func (c *NetworkConnectionCustomization) UUID() string {
+	return uuid.NewSHA1(networkConnectionUUIDNamespace, []byte(c.Name)).String()
+}
+
+func (c *NetworkConnectionCustomization) autoconnect() bool {
+	return c.Autoconnect == nil || *c.Autoconnect
+}
+
+func (ip *NetworkIPCustomization) method() string {
+	if ip == nil || ip.Method == "" {
+		return NetworkIPMethodAuto
+	}
+	return ip.Method
+}
+
+// validate checks the IP configuration for the given family ("ipv4" or
+// "ipv6").
+func (ip *NetworkIPCustomization) validate(family string) []error {
+	if ip == nil {
+		return nil
+	}
+
+	isFamily := func(addr net.IP) bool {
+		return (addr.To4() != nil) == (family == "ipv4")
+	}
+
+	var errs []error
+	method := ip.method()
+	switch method {
+	case NetworkIPMethodAuto:
+	case NetworkIPMethodManual:
+		if len(ip.Addresses) == 0 {
+			errs = append(errs, fmt.Errorf("%s method %q requires at least one address", family, method))
+		}
+	case NetworkIPMethodDisabled:
+		if len(ip.Addresses) > 0 || ip.Gateway != "" || len(ip.DNS) > 0 || len(ip.DNSSearch) > 0 || len(ip.Routes) > 0 {
+			errs = append(errs, fmt.Errorf("%s is disabled but has addresses, gateway, dns or routes", family))
+		}
+		return errs
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s method %q (valid: auto, manual, disabled)", family, ip.Method))
+	}
+
+	var subnets []*net.IPNet
+	for _, address := range ip.Addresses {
+		addr, subnet, err := net.ParseCIDR(address)
+		if err != nil || !isFamily(addr) {
+			errs = append(errs, fmt.Errorf("invalid %s address %q: must be an address in CIDR notation", family, address))
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	if ip.Gateway != "" {
+		gw := net.ParseIP(ip.Gateway)
+		switch {
+		case gw == nil || !isFamily(gw):
+			errs = append(errs, fmt.Errorf("invalid %s gateway %q", family, ip.Gateway))
+		case len(ip.Addresses) == 0:
+			errs = append(errs, fmt.Errorf("%s gateway %q requires a static address", family, ip.Gateway))
+		case !slices.ContainsFunc(subnets, func(subnet *net.IPNet) bool { return subnet.Contains(gw) }):
+			errs = append(errs, fmt.Errorf("%s gateway %q is not inside the subnet of any address", family, ip.Gateway))
+		}
+	}
+
+	for _, dns := range ip.DNS {
+		if addr := net.ParseIP(dns); addr == nil || !isFamily(addr) {
+			errs = append(errs, fmt.Errorf("invalid %s dns server %q", family, dns))
+		}
+	}
+	for _, domain := range ip.DNSSearch {
+		if !isValidDNSName(domain) {
+			errs = append(errs, fmt.Errorf("invalid %s dns search domain %q", family, domain))
+		}
+	}
+
+	for _, route := range ip.Routes {
+		if addr, _, err := net.ParseCIDR(route.Destination); err != nil || !isFamily(addr) {
+			errs = append(errs, fmt.Errorf("invalid %s route destination %q: must be a network in CIDR notation", family, route.Destination))
+		}
+		if route.NextHop != "" {
+			if addr := net.ParseIP(route.NextHop); addr == nil || !isFamily(addr) {
+				errs = append(errs, fmt.Errorf("invalid %s route next hop %q", family, route.NextHop))
+			}
+		}
+	}
+
+	return errs
+}
+
+// Validate checks the connections and the references between them: VLAN
+// parents and port controllers must be connections of the blueprint and
+// no connection name or interface is used twice.
+func (n *NetworkCustomization) Validate() error {
+	if n == nil {
+		return nil
+	}
+
+	connections := make(map[string]*NetworkConnectionCustomization)
+	interfaces := make(map[string]bool)
+	macs := make(map[string]bool)
+
+	var errs []error
+	for idx := range n.Connections {
+		conn := &n.Connections[idx]
+		if conn.Name == "" {
+			errs = append(errs, fmt.Errorf("connection name is required"))
+			continue
+		}
+		if strings.ContainsAny(conn.Name, "/\n") || conn.Name == "." || conn.Name == ".." {
+			errs = append(errs, fmt.Errorf("invalid connection name %q", conn.Name))
+			continue
+		}
+		if connections[conn.Name] != nil {
+			errs = append(errs, fmt.Errorf("duplicate connection %q", conn.Name))
+			continue
+		}
+		connections[conn.Name] = conn
+
+		if conn.InterfaceName != "" {
+			if err := validateInterfaceName(conn.InterfaceName); err != nil {
+				errs = append(errs, fmt.Errorf("connection %q: %w", conn.Name, err))
+			} else if interfaces[conn.InterfaceName] {
+				errs = append(errs, fmt.Errorf("connection %q: interface %q is used by another connection", conn.Name, conn.InterfaceName))
+			}
+			interfaces[conn.InterfaceName] = true
+		}
+		if conn.MACAddress != "" {
+			mac, err := net.ParseMAC(conn.MACAddress)
+			if err != nil || len(mac) != 6 {
+				errs = append(errs, fmt.Errorf("connection %q: invalid MAC address %q", conn.Name, conn.MACAddress))
+			} else if macs[mac.String()] {
+				errs = append(errs, fmt.Errorf("connection %q: MAC address %q is used by another connection", conn.Name, conn.MACAddress))
+			} else {
+				macs[mac.String()] = true
+			}
+		}
+
+		switch conn.Type {
+		case NetworkConnectionTypeEthernet:
+			if conn.InterfaceName == "" && conn.MACAddress == "" {
+				errs = append(errs, fmt.Errorf("connection %q: ethernet connections require an interface name or a MAC address", conn.Name))
+			}
+		case NetworkConnectionTypeBond, NetworkConnectionTypeBridge:
+			if conn.InterfaceName == "" {
+				errs = append(errs, fmt.Errorf("connection %q: %s connections require an interface name", conn.Name, conn.Type))
+			}
+		case NetworkConnectionTypeVLAN:
+			if conn.VLAN == nil {
+				errs = append(errs, fmt.Errorf("connection %q: vlan connections require vlan settings", conn.Name))
+			} else if conn.VLAN.ID < 1 || conn.VLAN.ID > 4094 {
+				errs = append(errs, fmt.Errorf("connection %q: vlan id %d must be between 1 and 4094", conn.Name, conn.VLAN.ID))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("connection %q: unknown type %q (valid: ethernet, bond, vlan, bridge)", conn.Name, conn.Type))
+		}
+
+		if conn.MACAddress != "" && conn.Type != NetworkConnectionTypeEthernet {
+			errs = append(errs, fmt.Errorf("connection %q: matching by MAC address is only supported for ethernet connections", conn.Name))
+		}
+		if conn.VLAN != nil && conn.Type != NetworkConnectionTypeVLAN {
+			errs = append(errs, fmt.Errorf("connection %q: vlan settings are only supported for vlan connections", conn.Name))
+		}
+		if conn.Bond != nil {
+			if conn.Type != NetworkConnectionTypeBond {
+				errs = append(errs, fmt.Errorf("connection %q: bond settings are only supported for bond connections", conn.Name))
+			}
+			if conn.Bond.Mode != "" && !slices.Contains(networkBondModes, conn.Bond.Mode) {
+				errs = append(errs, fmt.Errorf("connection %q: invalid bond mode %q (valid: %s)", conn.Name, conn.Bond.Mode, strings.Join(networkBondModes, ", ")))
+			}
+			for _, opt := range conn.Bond.Options {
+				key, value, ok := strings.Cut(opt, "=")
+				if !ok || key == "" || value == "" || key == "mode" || strings.ContainsAny(opt, " \n") {
+					errs = append(errs, fmt.Errorf("connection %q: invalid bond option %q: must be key=value", conn.Name, opt))
+				}
+			}
+		}
+
+		if conn.Controller != "" && (conn.IPv4 != nil || conn.IPv6 != nil) {
+			errs = append(errs, fmt.Errorf("connection %q: ports of a bond or bridge cannot have an IP configuration", conn.Name))
+		}
+		for _, err := range conn.IPv4.validate("ipv4") {
+			errs = append(errs, fmt.Errorf("connection %q: %w", conn.Name, err))
+		}
+		for _, err := range conn.IPv6.validate("ipv6") {
+			errs = append(errs, fmt.Errorf("connection %q: %w", conn.Name, err))
+		}
+	}
+
+	// references can point to connections defined later
+	for _, conn := range n.Connections {
+		if conn.VLAN != nil && conn.Type == NetworkConnectionTypeVLAN {
+			parent := connections[conn.VLAN.Parent]
+			switch {
+			case parent == nil:
+				errs = append(errs, fmt.Errorf("connection %q: vlan parent %q does not exist", conn.Name, conn.VLAN.Parent))
+			case parent.Name == conn.Name:
+				errs = append(errs, fmt.Errorf("connection %q: vlan cannot be its own parent", conn.Name))
+			}
+		}
+		if conn.Controller != "" {
+			controller := connections[conn.Controller]
+			switch {
+			case controller == nil:
+				errs = append(errs, fmt.Errorf("connection %q: controller %q does not exist", conn.Name, conn.Controller))
+			case conn.Controller == conn.Name:
+				errs = append(errs, fmt.Errorf("connection %q: cannot be its own controller", conn.Name))
+			case controller.Type != NetworkConnectionTypeBond && controller.Type != NetworkConnectionTypeBridge:
+				errs = append(errs, fmt.Errorf("connection %q: controller %q is not a bond or bridge", conn.Name, conn.Controller))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid network customizations:\n%w", err)
+	}
+	return nil
+}
+
+func writeIPSection(b *strings.Builder, family string, ip *NetworkIPCustomization) {
+	fmt.Fprintf(b, "\n[%s]\n", family)
+	fmt.Fprintf(b, "method=%s\n", ip.method())
+	if ip == nil {
+		return
+	}
+	for idx, address := range ip.Addresses {
+		fmt.Fprintf(b, "address%d=%s\n", idx+1, address)
+	}
+	if ip.Gateway != "" {
+		fmt.Fprintf(b, "gateway=%s\n", ip.Gateway)
+	}
+	if len(ip.DNS) > 0 {
+		fmt.Fprintf(b, "dns=%s;\n", strings.Join(ip.DNS, ";"))
+	}
+	if len(ip.DNSSearch) > 0 {
+		fmt.Fprintf(b, "dns-search=%s;\n", strings.Join(ip.DNSSearch, ";"))
+	}
+	for idx, route := range ip.Routes {
+		fmt.Fprintf(b, "route%d=%s", idx+1, route.Destination)
+		if route.NextHop != "" || route.Metric != nil {
+			fmt.Fprintf(b, ",%s", route.NextHop)
+		}
+		if route.Metric != nil {
+			fmt.Fprintf(b, ",%d", *route.Metric)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// keyfile renders the connection in the NetworkManager keyfile format, see
+// nm-settings-keyfile(5). References to other connections use their UUIDs.
+// The controller is written with the "master" and "slave-type" keys, which
+// all NetworkManager versions understand.
+func (n *NetworkCustomization) keyfile(conn *NetworkConnectionCustomization) string {
+	byName := func(name string) *NetworkConnectionCustomization {
+		idx := slices.IndexFunc(n.Connections, func(c NetworkConnectionCustomization) bool { return c.Name == name })
+		return &n.Connections[idx]
+	}
+
+	var b strings.Builder
+	b.WriteString("[connection]\n")
+	fmt.Fprintf(&b, "id=%s\n", conn.Name)
+	fmt.Fprintf(&b, "uuid=%s\n", conn.UUID())
+	fmt.Fprintf(&b, "type=%s\n", conn.Type)
+	if conn.InterfaceName != "" {
+		fmt.Fprintf(&b, "interface-name=%s\n", conn.InterfaceName)
+	}
+	if !conn.autoconnect() {
+		b.WriteString("autoconnect=false\n")
+	}
+	if conn.Controller != "" {
+		controller := byName(conn.Controller)
+		fmt.Fprintf(&b, "master=%s\n", controller.UUID())
+		fmt.Fprintf(&b, "slave-type=%s\n", controller.Type)
+	}
+
+	switch conn.Type {
+	case NetworkConnectionTypeEthernet:
+		b.WriteString("\n[ethernet]\n")
+		if conn.MACAddress != "" {
+			mac, _ := net.ParseMAC(conn.MACAddress)
+			fmt.Fprintf(&b, "mac-address=%s\n", strings.ToUpper(mac.String()))
+		}
+	case NetworkConnectionTypeBond:
+		b.WriteString("\n[bond]\n")
+		mode := "balance-rr"
+		if conn.Bond != nil && conn.Bond.Mode != "" {
+			mode = conn.Bond.Mode
+		}
+		fmt.Fprintf(&b, "mode=%s\n", mode)
+		if conn.Bond != nil {
+			for _, opt := range conn.Bond.Options {
+				b.WriteString(opt + "\n")
+			}
+		}
+	case NetworkConnectionTypeVLAN:
+		b.WriteString("\n[vlan]\n")
+		fmt.Fprintf(&b, "id=%d\n", conn.VLAN.ID)
+		fmt.Fprintf(&b, "parent=%s\n", byName(conn.VLAN.Parent).UUID())
+	case NetworkConnectionTypeBridge:
+		b.WriteString("\n[bridge]\n")
+	}
+
+	if conn.Controller == "" {
+		writeIPSection(&b, "ipv4", conn.IPv4)
+		writeIPSection(&b, "ipv6", conn.IPv6)
+	}
+
+	return b.String()
+}
+
+// ToFsNodeFiles validates the connections and returns a NetworkManager
+// keyfile for each of them. NetworkManager ignores keyfiles that are
+// readable by other users, so the files are created with mode 0600.
+func (n *NetworkCustomization) ToFsNodeFiles() ([]*fsnode.File, error) {
+	if n == nil || len(n.Connections) == 0 {
+		return nil, nil
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0600)
+	files := make([]*fsnode.File, 0, len(n.Connections))
+	for idx := range n.Connections {
+		conn := &n.Connections[idx]
+		filePath := path.Join(NetworkManagerConnectionsDir, conn.Name+".nmconnection")
+		file, err := fsnode.NewFile(filePath, &mode, nil, nil, []byte(n.keyfile(conn)))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -0,0 +1,350 @@
+package blueprint
+
+import (
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestNetworkCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		network *NetworkCustomization
+		err     string
+	}{
+		"nil": {},
+		"happy": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Name: "eth0", Type: "ethernet", MACAddress: "52:54:00:12:34:56", Controller: "bond0"},
+					{Name: "eth1", Type: "ethernet", InterfaceName: "eth1", Controller: "bond0"},
+					{
+						Name:          "bond0",
+						Type:          "bond",
+						InterfaceName: "bond0",
+						Bond:          &NetworkBondCustomization{Mode: "802.3ad", Options: []string{"miimon=100"}},
+						IPv4:          &NetworkIPCustomization{Method: "disabled"},
+						IPv6:          &NetworkIPCustomization{Method: "disabled"},
+					},
+					{
+						Name:          "mgmt",
+						Type:          "vlan",
+						InterfaceName: "bond0.100",
+						VLAN:          &NetworkVLANCustomization{ID: 100, Parent: "bond0"},
+						IPv4: &NetworkIPCustomization{
+							Method:    "manual",
+							Addresses: []string{"192.168.100.10/24"},
+							Gateway:   "192.168.100.1",
+							DNS:       []string{"192.168.100.1"},
+							DNSSearch: []string{"example.com"},
+							Routes: []NetworkRouteCustomization{
+								{Destination: "10.0.0.0/8", NextHop: "192.168.100.254", Metric: common.ToPtr(uint32(100))},
+							},
+						},
+						IPv6: &NetworkIPCustomization{
+							Addresses: []string{"fd00::10/64"},
+							Gateway:   "fd00::1",
+						},
+					},
+					{Name: "br0", Type: "bridge", InterfaceName: "br0"},
+				},
+			},
+		},
+		"bad-connections": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Type: "ethernet", InterfaceName: "eth0"},
+					{Name: "a/b", Type: "ethernet", InterfaceName: "eth0"},
+					{Name: "eth0", Type: "wifi", InterfaceName: "eth0"},
+					{Name: "eth0", Type: "ethernet", InterfaceName: "eth0"},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				"connection name is required\n" +
+				`invalid connection name "a/b"` + "\n" +
+				`connection "eth0": unknown type "wifi" (valid: ethernet, bond, vlan, bridge)` + "\n" +
+				`duplicate connection "eth0"`,
+		},
+		"bad-matching": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Name: "a", Type: "ethernet"},
+					{Name: "b", Type: "ethernet", InterfaceName: "this-name-is-too-long"},
+					{Name: "c", Type: "ethernet", MACAddress: "52:54:00:12:34"},
+					{Name: "d", Type: "ethernet", MACAddress: "52:54:00:12:34:56"},
+					{Name: "e", Type: "ethernet", MACAddress: "52-54-00-12-34-56"},
+					{Name: "f", Type: "bond", MACAddress: "52:54:00:12:34:57"},
+					{Name: "g", Type: "ethernet", InterfaceName: "eth0"},
+					{Name: "h", Type: "ethernet", InterfaceName: "eth0"},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				`connection "a": ethernet connections require an interface name or a MAC address` + "\n" +
+				`connection "b": invalid network interface name "this-name-is-too-long"` + "\n" +
+				`connection "c": invalid MAC address "52:54:00:12:34"` + "\n" +
+				`connection "e": MAC address "52-54-00-12-34-56" is used by another connection` + "\n" +
+				`connection "f": bond connections require an interface name` + "\n" +
+				`connection "f": matching by MAC address is only supported for ethernet connections` + "\n" +
+				`connection "h": interface "eth0" is used by another connection`,
+		},
+		"bad-references": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Name: "eth0", Type: "ethernet", InterfaceName: "eth0", Controller: "bond0"},
+					{Name: "eth1", Type: "ethernet", InterfaceName: "eth1", Controller: "eth0", IPv4: &NetworkIPCustomization{}},
+					{Name: "v1", Type: "vlan", VLAN: &NetworkVLANCustomization{ID: 10, Parent: "eth9"}},
+					{Name: "v2", Type: "vlan", VLAN: &NetworkVLANCustomization{ID: 4095, Parent: "v2"}},
+					{Name: "v3", Type: "vlan"},
+					{Name: "eth2", Type: "ethernet", InterfaceName: "eth2", VLAN: &NetworkVLANCustomization{ID: 1, Parent: "eth0"}},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				`connection "eth1": ports of a bond or bridge cannot have an IP configuration` + "\n" +
+				`connection "v2": vlan id 4095 must be between 1 and 4094` + "\n" +
+				`connection "v3": vlan connections require vlan settings` + "\n" +
+				`connection "eth2": vlan settings are only supported for vlan connections` + "\n" +
+				`connection "eth0": controller "bond0" does not exist` + "\n" +
+				`connection "eth1": controller "eth0" is not a bond or bridge` + "\n" +
+				`connection "v1": vlan parent "eth9" does not exist` + "\n" +
+				`connection "v2": vlan cannot be its own parent`,
+		},
+		"bad-bond": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Name: "bond0", Type: "bond", InterfaceName: "bond0", Bond: &NetworkBondCustomization{Mode: "lacp", Options: []string{"miimon", "mode=802.3ad"}}},
+					{Name: "br0", Type: "bridge", InterfaceName: "br0", Bond: &NetworkBondCustomization{}},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				`connection "bond0": invalid bond mode "lacp" (valid: balance-rr, active-backup, balance-xor, broadcast, 802.3ad, balance-tlb, balance-alb)` + "\n" +
+				`connection "bond0": invalid bond option "miimon": must be key=value` + "\n" +
+				`connection "bond0": invalid bond option "mode=802.3ad": must be key=value` + "\n" +
+				`connection "br0": bond settings are only supported for bond connections`,
+		},
+		"own-controller": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{Name: "bond0", Type: "bond", InterfaceName: "bond0", Controller: "bond0"},
+					{Name: "br0", Type: "bridge", InterfaceName: "br0", Controller: "br0"},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				`connection "bond0": cannot be its own controller` + "\n" +
+				`connection "br0": cannot be its own controller`,
+		},
+		"bad-ip": {
+			network: &NetworkCustomization{
+				Connections: []NetworkConnectionCustomization{
+					{
+						Name:          "eth0",
+						Type:          "ethernet",
+						InterfaceName: "eth0",
+						IPv4: &NetworkIPCustomization{
+							Method:    "manual",
+							Addresses: []string{"192.168.0.10/24", "192.168.1.10", "fd00::1/64"},
+							Gateway:   "192.168.2.1",
+							DNS:       []string{"fd00::53", "bogus"},
+							DNSSearch: []string{"-bad"},
+							Routes: []NetworkRouteCustomization{
+								{Destination: "10.0.0.1", NextHop: "fd00::1"},
+							},
+						},
+						IPv6: &NetworkIPCustomization{
+							Method:  "dhcp",
+							Gateway: "fd00::1",
+						},
+					},
+					{Name: "eth1", Type: "ethernet", InterfaceName: "eth1", IPv4: &NetworkIPCustomization{Method: "manual"}},
+					{Name: "eth2", Type: "ethernet", InterfaceName: "eth2", IPv4: &NetworkIPCustomization{Method: "disabled", DNS: []string{"1.1.1.1"}}},
+				},
+			},
+			err: "invalid network customizations:\n" +
+				`connection "eth0": invalid ipv4 address "192.168.1.10": must be an address in CIDR notation` + "\n" +
+				`connection "eth0": invalid ipv4 address "fd00::1/64": must be an address in CIDR notation` + "\n" +
+				`connection "eth0": ipv4 gateway "192.168.2.1" is not inside the subnet of any address` + "\n" +
+				`connection "eth0": invalid ipv4 dns server "fd00::53"` + "\n" +
+				`connection "eth0": invalid ipv4 dns server "bogus"` + "\n" +
+				`connection "eth0": invalid ipv4 dns search domain "-bad"` + "\n" +
+				`connection "eth0": invalid ipv4 route destination "10.0.0.1": must be a network in CIDR notation` + "\n" +
+				`connection "eth0": invalid ipv4 route next hop "fd00::1"` + "\n" +
+				`connection "eth0": invalid ipv6 method "dhcp" (valid: auto, manual, disabled)` + "\n" +
+				`connection "eth0": ipv6 gateway "fd00::1" requires a static address` + "\n" +
+				`connection "eth1": ipv4 method "manual" requires at least one address` + "\n" +
+				`connection "eth2": ipv4 is disabled but has addresses, gateway, dns or routes`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.network.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestNetworkCustomizationToFsNodeFiles(t *testing.T) {
+	network := &NetworkCustomization{
+		Connections: []NetworkConnectionCustomization{
+			{Name: "eth0", Type: "ethernet", MACAddress: "52:54:00:ab:cd:ef", Controller: "bond0"},
+			{
+				Name:          "bond0",
+				Type:          "bond",
+				InterfaceName: "bond0",
+				Bond:          &NetworkBondCustomization{Mode: "active-backup", Options: []string{"miimon=100"}},
+				IPv6:          &NetworkIPCustomization{Method: "disabled"},
+			},
+			{
+				Name:          "mgmt",
+				Type:          "vlan",
+				InterfaceName: "bond0.100",
+				Autoconnect:   common.ToPtr(false),
+				VLAN:          &NetworkVLANCustomization{ID: 100, Parent: "bond0"},
+				IPv4: &NetworkIPCustomization{
+					Method:    "manual",
+					Addresses: []string{"192.168.100.10/24"},
+					Gateway:   "192.168.100.1",
+					DNS:       []string{"192.168.100.1", "1.1.1.1"},
+					DNSSearch: []string{"example.com"},
+					Routes: []NetworkRouteCustomization{
+						{Destination: "10.0.0.0/8", NextHop: "192.168.100.254"},
+						{Destination: "172.16.0.0/12", Metric: common.ToPtr(uint32(50))},
+					},
+				},
+			},
+		},
+	}
+
+	files, err := network.ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+
+	bondUUID := network.Connections[1].UUID()
+	assert.Equal(t, bondUUID, (&NetworkConnectionCustomization{Name: "bond0"}).UUID())
+	assert.NotEqual(t, bondUUID, network.Connections[0].UUID())
+
+	for _, file := range files {
+		assert.Equal(t, os.FileMode(0600), *file.Mode())
+	}
+
+	assert.Equal(t, "/etc/NetworkManager/system-connections/eth0.nmconnection", files[0].Path())
+	assert.Equal(t, `[connection]
+id=eth0
+uuid=`+network.Connections[0].UUID()+`
+type=ethernet
+master=`+bondUUID+`
+slave-type=bond
+
+[ethernet]
+mac-address=52:54:00:AB:CD:EF
+`, string(files[0].Data()))
+
+	assert.Equal(t, "/etc/NetworkManager/system-connections/bond0.nmconnection", files[1].Path())
+	assert.Equal(t, `[connection]
+id=bond0
+uuid=`+bondUUID+`
+type=bond
+interface-name=bond0
+
+[bond]
+mode=active-backup
+miimon=100
+
+[ipv4]
+method=auto
+
+[ipv6]
+method=disabled
+`, string(files[1].Data()))
+
+	assert.Equal(t, "/etc/NetworkManager/system-connections/mgmt.nmconnection", files[2].Path())
+	assert.Equal(t, `[connection]
+id=mgmt
+uuid=`+network.Connections[2].UUID()+`
+type=vlan
+interface-name=bond0.100
+autoconnect=false
+
+[vlan]
+id=100
+parent=`+bondUUID+`
+
+[ipv4]
+method=manual
+address1=192.168.100.10/24
+gateway=192.168.100.1
+dns=192.168.100.1;1.1.1.1;
+dns-search=example.com;
+route1=10.0.0.0/8,192.168.100.254
+route2=172.16.0.0/12,,50
+
+[ipv6]
+method=auto
+`, string(files[2].Data()))
+
+	var nilNetwork *NetworkCustomization
+	files, err = nilNetwork.ToFsNodeFiles()
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+
+	_, err = (&NetworkCustomization{Connections: []NetworkConnectionCustomization{{Name: "x", Type: "ethernet"}}}).ToFsNodeFiles()
+	assert.Error(t, err)
+}
+
+func TestGetNetwork(t *testing.T) {
+	tomlData := `
+[[customizations.network.connections]]
+name = "uplink"
+type = "ethernet"
+interface_name = "enp1s0"
+
+[customizations.network.connections.ipv4]
+method = "manual"
+addresses = ["10.0.0.5/24"]
+gateway = "10.0.0.1"
+
+[[customizations.network.connections.ipv4.routes]]
+destination = "10.1.0.0/16"
+next_hop = "10.0.0.254"
+metric = 10
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	network, err := bp.Customizations.GetNetwork()
+	require.NoError(t, err)
+	assert.Equal(t, &NetworkCustomization{
+		Connections: []NetworkConnectionCustomization{
+			{
+				Name:          "uplink",
+				Type:          "ethernet",
+				InterfaceName: "enp1s0",
+				IPv4: &NetworkIPCustomization{
+					Method:    "manual",
+					Addresses: []string{"10.0.0.5/24"},
+					Gateway:   "10.0.0.1",
+					Routes: []NetworkRouteCustomization{
+						{Destination: "10.1.0.0/16", NextHop: "10.0.0.254", Metric: common.ToPtr(uint32(10))},
+					},
+				},
+			},
+		},
+	}, network)
+
+	bp.Customizations.Network.Connections[0].IPv4.Gateway = "10.1.0.1"
+	_, err = bp.Customizations.GetNetwork()
+	assert.Error(t, err)
+
+	var nilCustomizations *Customizations
+	network, err = nilCustomizations.GetNetwork()
+	assert.NoError(t, err)
+	assert.Nil(t, network)
+}
@@ -0,0 +1,156 @@
+package blueprint
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const (
+	openPGPPublicKeyBlock  = "PGP PUBLIC KEY BLOCK"
+	openPGPPrivateKeyBlock = "PGP PRIVATE KEY BLOCK"
+)
+
+// OpenPGPKeyInfo describes an OpenPGP public key.
+type OpenPGPKeyInfo struct {
+	// Fingerprint of the primary key in upper case hex
+	Fingerprint string `json:"fingerprint"`
+
+	// Key ID of the primary key, the last 16 hex digits of the fingerprint
+	KeyID string `json:"key_id"`
+
+	// User IDs of the key, e.g. "Fedora (40) <fedora-40-primary@fedoraproject.org>"
+	UserIDs []string `json:"user_ids"`
+
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// openPGPNow returns the time against which key expiry and revocation are
+// checked.
+var openPGPNow = time.Now
+
+// splitArmoredBlocks returns the armored blocks in data, several keys can
+// be concatenated in one file.
+func splitArmoredBlocks(data string) ([]string, error) {
+	var blocks []string
+	for {
+		start := strings.Index(data, "-----BEGIN ")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(data[start:], "-----END ")
+		if end == -1 {
+			return nil, fmt.Errorf("armored block without end line")
+		}
+		end += start
+		lineEnd := strings.Index(data[end:], "\n")
+		if lineEnd == -1 {
+			lineEnd = len(data) - end
+		}
+		blocks = append(blocks, data[start:end+lineEnd]+"\n")
+		data = data[end+lineEnd:]
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no armored block found")
+	}
+	return blocks, nil
+}
+
+// ParseOpenPGPPublicKeys parses one or more ASCII armored OpenPGP public
+// keys. Private keys, keys without a valid self-signature and expired or
+// revoked keys are rejected.
+func ParseOpenPGPPublicKeys(armored string) ([]OpenPGPKeyInfo, error) {
+	blocks, err := splitArmoredBlocks(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	now := openPGPNow()
+	var keys []OpenPGPKeyInfo
+	for _, data := range blocks {
+		block, err := armor.Decode(strings.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode armored block: %w", err)
+		}
+		switch block.Type {
+		case openPGPPublicKeyBlock:
+		case openPGPPrivateKeyBlock:
+			return nil, fmt.Errorf("private keys are not allowed")
+		default:
+			return nil, fmt.Errorf("unexpected armored block %q, expected %q", block.Type, openPGPPublicKeyBlock)
+		}
+
+		entities, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse public key: %w", err)
+		}
+
+		for _, entity := range entities {
+			info, err := openPGPKeyInfo(entity, now)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, info)
+		}
+	}
+	return keys, nil
+}
+
+func openPGPKeyInfo(entity *openpgp.Entity, now time.Time) (OpenPGPKeyInfo, error) {
+	pk := entity.PrimaryKey
+	info := OpenPGPKeyInfo{
+		Fingerprint: fmt.Sprintf("%X", pk.Fingerprint),
+		KeyID:       fmt.Sprintf("%016X", pk.KeyId),
+		Created:     pk.CreationTime,
+	}
+
+	if entity.PrivateKey != nil {
+		return info, fmt.Errorf("key %s: private keys are not allowed", info.Fingerprint)
+	}
+	if entity.Revoked(now) {
+		return info, fmt.Errorf("key %s is revoked", info.Fingerprint)
+	}
+
+	sig, _ := entity.PrimarySelfSignature()
+	if sig == nil {
+		return info, fmt.Errorf("key %s has no valid self-signature", info.Fingerprint)
+	}
+	if sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs != 0 {
+		expires := pk.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		info.Expires = &expires
+	}
+	if pk.KeyExpired(sig, now) {
+		return info, fmt.Errorf("key %s expired on %s", info.Fingerprint, info.Expires.Format(time.DateOnly))
+	}
+
+	for name, identity := range entity.Identities {
+		if !identity.Revoked(now) {
+			info.UserIDs = append(info.UserIDs, name)
+		}
+	}
+	slices.Sort(info.UserIDs)
+
+	return info, nil
+}
+
+// InlineGPGKeyInfo parses the inline GPG keys of the repository and returns
+// their fingerprints and user IDs. Keys given as URLs are skipped.
+func (rc *RepositoryCustomization) InlineGPGKeyInfo() ([]OpenPGPKeyInfo, error) {
+	var keys []OpenPGPKeyInfo
+	for _, key := range rc.GPGKeys {
+		if !isInlineGPGKey(key) {
+			continue
+		}
+		info, err := ParseOpenPGPPublicKeys(key)
+		if err != nil {
+			return nil, fmt.Errorf("repository %q: %w", rc.Id, err)
+		}
+		keys = append(keys, info...)
+	}
+	return keys, nil
+}
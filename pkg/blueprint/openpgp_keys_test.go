@@ -0,0 +1,152 @@
+package blueprint
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestOpenPGPKey returns a new key pair created at the given time,
+// expiring after lifetime unless it is zero.
+func generateTestOpenPGPKey(t *testing.T, name string, created time.Time, lifetime time.Duration) *openpgp.Entity {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEdDSA,
+		Time:            func() time.Time { return created },
+		KeyLifetimeSecs: uint32(lifetime.Seconds()),
+	})
+	require.NoError(t, err)
+	return entity
+}
+
+// armorTestOpenPGPKey returns the armored public key, or the armored
+// private key if private is set.
+func armorTestOpenPGPKey(t *testing.T, entity *openpgp.Entity, private bool) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	blockType := openPGPPublicKeyBlock
+	if private {
+		blockType = openPGPPrivateKeyBlock
+	}
+	w, err := armor.Encode(&buf, blockType, nil)
+	require.NoError(t, err)
+	if private {
+		require.NoError(t, entity.SerializePrivate(w, nil))
+	} else {
+		require.NoError(t, entity.Serialize(w))
+	}
+	require.NoError(t, w.Close())
+	return buf.String() + "\n"
+}
+
+func TestParseOpenPGPPublicKeys(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	alice := generateTestOpenPGPKey(t, "alice", now.Add(-time.Hour), 0)
+	bob := generateTestOpenPGPKey(t, "bob", now.Add(-time.Hour), 48*time.Hour)
+
+	keys, err := ParseOpenPGPPublicKeys(armorTestOpenPGPKey(t, alice, false))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, []string{"alice <alice@example.com>"}, keys[0].UserIDs)
+	assert.Len(t, keys[0].Fingerprint, 40)
+	assert.Equal(t, keys[0].Fingerprint[24:], keys[0].KeyID)
+	assert.Equal(t, now.Add(-time.Hour).Unix(), keys[0].Created.Unix())
+	assert.Nil(t, keys[0].Expires)
+
+	// several concatenated keys
+	keys, err = ParseOpenPGPPublicKeys(armorTestOpenPGPKey(t, alice, false) + armorTestOpenPGPKey(t, bob, false))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, []string{"bob <bob@example.com>"}, keys[1].UserIDs)
+	require.NotNil(t, keys[1].Expires)
+	assert.Equal(t, now.Add(47*time.Hour).Unix(), keys[1].Expires.Unix())
+}
+
+func TestParseOpenPGPPublicKeysErrors(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	valid := generateTestOpenPGPKey(t, "valid", now.Add(-time.Hour), 0)
+	expired := generateTestOpenPGPKey(t, "expired", now.Add(-48*time.Hour), 24*time.Hour)
+	revoked := generateTestOpenPGPKey(t, "revoked", now.Add(-time.Hour), 0)
+	require.NoError(t, revoked.RevokeKey(packet.KeyCompromised, "test", nil))
+
+	testCases := map[string]struct {
+		key string
+		err string
+	}{
+		"not-armored": {
+			key: "fake-gpg-key",
+			err: "no armored block found",
+		},
+		"no-end": {
+			key: "-----BEGIN PGP PUBLIC KEY BLOCK-----\nabc\n",
+			err: "armored block without end line",
+		},
+		"corrupt": {
+			key: "-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-----END PGP PUBLIC KEY BLOCK-----\n",
+			err: "cannot decode armored block: EOF",
+		},
+		"private": {
+			key: armorTestOpenPGPKey(t, valid, true),
+			err: "private keys are not allowed",
+		},
+		"expired": {
+			key: armorTestOpenPGPKey(t, expired, false),
+			err: "key " + fingerprint(expired) + " expired on " + now.Add(-24*time.Hour).Format(time.DateOnly),
+		},
+		"revoked": {
+			key: armorTestOpenPGPKey(t, revoked, false),
+			err: "key " + fingerprint(revoked) + " is revoked",
+		},
+		"one-bad-key": {
+			key: armorTestOpenPGPKey(t, valid, false) + armorTestOpenPGPKey(t, revoked, false),
+			err: "key " + fingerprint(revoked) + " is revoked",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseOpenPGPPublicKeys(tc.key)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func fingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+func TestRepositoryInlineGPGKeys(t *testing.T) {
+	now := time.Now()
+	key := armorTestOpenPGPKey(t, generateTestOpenPGPKey(t, "repo", now.Add(-time.Hour), 0), false)
+	expired := armorTestOpenPGPKey(t, generateTestOpenPGPKey(t, "expired", now.Add(-48*time.Hour), time.Hour), false)
+
+	repo := RepositoryCustomization{
+		Id:       "example",
+		BaseURLs: []string{"http://example.com"},
+		GPGKeys:  []string{"https://example.com/key.asc", key},
+	}
+	assert.NoError(t, validateCustomRepository(&repo))
+	info, err := repo.InlineGPGKeyInfo()
+	require.NoError(t, err)
+	require.Len(t, info, 1)
+	assert.Equal(t, []string{"repo <repo@example.com>"}, info[0].UserIDs)
+
+	repo.GPGKeys = []string{expired}
+	assert.ErrorContains(t, validateCustomRepository(&repo), "Repository gpg key is not a valid gpg key: key ")
+	_, err = repo.InlineGPGKeyInfo()
+	assert.ErrorContains(t, err, `repository "example": key `)
+
+	repo.GPGKeys = []string{"invalid"}
+	assert.EqualError(t, validateCustomRepository(&repo), "Repository gpg key is not a valid URL or a valid gpg key")
+}
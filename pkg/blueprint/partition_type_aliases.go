@@ -0,0 +1,120 @@
+package blueprint
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// partitionTypeAlias maps a well-known partition type name to the GPT
+// partition type GUID and the DOS partition type ID. An empty DOS ID means
+// that the alias can only be used on GPT partition tables.
+//
+// See also
+//   - https://uapi-group.org/specifications/specs/discoverable_partitions_specification/
+//   - https://en.wikipedia.org/wiki/Partition_type
+type partitionTypeAlias struct {
+	GPT string
+	DOS string
+}
+
+// DOS partition tables have no dedicated types for the different kinds of
+// Linux filesystems, they all use the native Linux type.
+const linuxDOSPartitionType = "83"
+
+var partitionTypeAliases = map[string]partitionTypeAlias{
+	"linux":     {GPT: "0FC63DAF-8483-4772-8E79-3D69D8477DE4", DOS: linuxDOSPartitionType},
+	"esp":       {GPT: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", DOS: "ef"},
+	"bios-boot": {GPT: "21686148-6449-6E6F-744E-656564454649"},
+	"swap":      {GPT: "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F", DOS: "82"},
+	"home":      {GPT: "933AC7E1-2EB4-4F13-B844-0E14E2AEF915", DOS: linuxDOSPartitionType},
+	"srv":       {GPT: "3B8F8425-20E0-4F3B-907F-1A25A76F98E8", DOS: linuxDOSPartitionType},
+	"var":       {GPT: "4D21B016-B534-45C2-A9FB-5C16E091FD2D", DOS: linuxDOSPartitionType},
+	"xbootldr":  {GPT: "BC13C2FF-59E6-4262-A352-B275FD6F7172", DOS: "ea"},
+	"lvm":       {GPT: "E6D6D379-F507-44C2-A23C-238F2A3DF928", DOS: "8e"},
+
+	// root partition types per architecture
+	"root-x86_64":  {GPT: "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709", DOS: linuxDOSPartitionType},
+	"root-aarch64": {GPT: "B921B045-1DF0-41C3-AF44-4C6F280D3FAE", DOS: linuxDOSPartitionType},
+	"root-ppc64le": {GPT: "C31C45E6-3F39-412E-80FB-4809C4980599", DOS: linuxDOSPartitionType},
+	"root-s390x":   {GPT: "5EEAD9A9-FE09-4A1E-A1D7-520D00531306", DOS: linuxDOSPartitionType},
+	"root-riscv64": {GPT: "72EC70A6-CF74-40E6-BD49-4BDA08E8F224", DOS: linuxDOSPartitionType},
+}
+
+// Aliases are lowercase names. Values that do not look like a name (e.g.
+// "0x83" or a mistyped GUID) are reported as invalid IDs, not as unknown
+// aliases.
+var partitionTypeAliasName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// PartitionTypeAliases returns the sorted list of partition type aliases that
+// can be used in place of a GUID or DOS ID in
+// [PartitionCustomization.PartType].
+func PartitionTypeAliases() []string {
+	return slices.Sorted(maps.Keys(partitionTypeAliases))
+}
+
+// isPartitionTypeAlias returns true if the partition type is neither a GUID
+// nor a DOS ID but has the form of an alias. The alias is not necessarily
+// known.
+func isPartitionTypeAlias(partType string) bool {
+	if validDosPartitionType.MatchString(partType) {
+		return false
+	}
+	return partitionTypeAliasName.MatchString(partType)
+}
+
+// lookupPartitionTypeAlias returns the partition type IDs for the alias or
+// an error listing all valid aliases.
+func lookupPartitionTypeAlias(alias string) (partitionTypeAlias, error) {
+	ids, ok := partitionTypeAliases[alias]
+	if !ok {
+		return partitionTypeAlias{}, fmt.Errorf("unknown partition part_type alias %q (valid aliases: %s)", alias, strings.Join(PartitionTypeAliases(), ", "))
+	}
+	return ids, nil
+}
+
+// ResolvePartitionTypeID returns the partition type ID to use for the
+// partition on a partition table of type ptType (gpt or dos). Aliases are
+// resolved to the GUID or DOS ID of the partition table type, all other
+// values are returned unchanged. An empty ptType can only be used when
+// PartType is not an alias.
+func (p *PartitionCustomization) ResolvePartitionTypeID(ptType string) (string, error) {
+	if !isPartitionTypeAlias(p.PartType) {
+		return p.PartType, nil
+	}
+
+	ids, err := lookupPartitionTypeAlias(p.PartType)
+	if err != nil {
+		return "", err
+	}
+
+	switch ptType {
+	case "gpt":
+		return ids.GPT, nil
+	case "dos":
+		if ids.DOS == "" {
+			return "", fmt.Errorf("partition part_type alias %q is not supported for partition table type %q", p.PartType, ptType)
+		}
+		return ids.DOS, nil
+	default:
+		return "", fmt.Errorf("cannot resolve partition part_type alias %q for partition table type %q", p.PartType, ptType)
+	}
+}
+
+// resolvePartitionTypeIDs returns a copy of the disk customization with all
+// partition type aliases resolved for the partition table type.
+func (dc *DiskCustomization) resolvePartitionTypeIDs() (*DiskCustomization, error) {
+	resolved := *dc
+	resolved.Partitions = slices.Clone(dc.Partitions)
+	for idx := range resolved.Partitions {
+		part := &resolved.Partitions[idx]
+		partType, err := part.ResolvePartitionTypeID(dc.Type)
+		if err != nil {
+			return nil, err
+		}
+		part.PartType = partType
+	}
+	return &resolved, nil
+}
@@ -0,0 +1,164 @@
+package blueprint_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/pkg/blueprint"
+)
+
+func TestResolvePartitionTypeID(t *testing.T) {
+	type testCase struct {
+		partType    string
+		ptType      string
+		expected    string
+		expectedErr string
+	}
+
+	testCases := map[string]testCase{
+		"empty": {
+			partType: "",
+			ptType:   "gpt",
+			expected: "",
+		},
+		"guid": {
+			partType: "12345678-1234-1234-1234-1234567890ab",
+			ptType:   "gpt",
+			expected: "12345678-1234-1234-1234-1234567890ab",
+		},
+		"dos-id": {
+			partType: "ef",
+			ptType:   "dos",
+			expected: "ef",
+		},
+		"dos-id-unknown-table-type": {
+			partType: "8e",
+			ptType:   "",
+			expected: "8e",
+		},
+		"linux-gpt": {
+			partType: "linux",
+			ptType:   "gpt",
+			expected: "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+		},
+		"linux-dos": {
+			partType: "linux",
+			ptType:   "dos",
+			expected: "83",
+		},
+		"esp-gpt": {
+			partType: "esp",
+			ptType:   "gpt",
+			expected: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+		},
+		"esp-dos": {
+			partType: "esp",
+			ptType:   "dos",
+			expected: "ef",
+		},
+		"lvm-dos": {
+			partType: "lvm",
+			ptType:   "dos",
+			expected: "8e",
+		},
+		"root-aarch64-gpt": {
+			partType: "root-aarch64",
+			ptType:   "gpt",
+			expected: "B921B045-1DF0-41C3-AF44-4C6F280D3FAE",
+		},
+		"var-dos": {
+			partType: "var",
+			ptType:   "dos",
+			expected: "83",
+		},
+		"bios-boot-dos": {
+			partType:    "bios-boot",
+			ptType:      "dos",
+			expectedErr: `partition part_type alias "bios-boot" is not supported for partition table type "dos"`,
+		},
+		"alias-unknown-table-type": {
+			partType:    "swap",
+			ptType:      "",
+			expectedErr: `cannot resolve partition part_type alias "swap" for partition table type ""`,
+		},
+		"unknown-alias": {
+			partType:    "usr",
+			ptType:      "gpt",
+			expectedErr: `unknown partition part_type alias "usr" (valid aliases: bios-boot, esp, home, linux, lvm, root-aarch64, root-ppc64le, root-riscv64, root-s390x, root-x86_64, srv, swap, var, xbootldr)`,
+		},
+	}
+
+	for name := range testCases {
+		tc := testCases[name]
+		t.Run(name, func(t *testing.T) {
+			part := blueprint.PartitionCustomization{PartType: tc.partType}
+			partType, err := part.ResolvePartitionTypeID(tc.ptType)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, partType)
+		})
+	}
+}
+
+func TestPartitionTypeAliases(t *testing.T) {
+	aliases := blueprint.PartitionTypeAliases()
+	assert.IsIncreasing(t, aliases)
+
+	// every alias must be a valid partition type for gpt partition tables
+	for _, alias := range aliases {
+		part := blueprint.PartitionCustomization{PartType: alias}
+		assert.NoError(t, part.ValidatePartitionTypeID("gpt"), alias)
+		assert.EqualError(t, part.ValidatePartitionTypeID(""), fmt.Sprintf("partition part_type alias %q requires the partition table type to be set", alias))
+
+		guid, err := part.ResolvePartitionTypeID("gpt")
+		assert.NoError(t, err)
+		resolved := blueprint.PartitionCustomization{PartType: guid}
+		assert.NoError(t, resolved.ValidatePartitionTypeID("gpt"), alias)
+	}
+}
+
+func TestGetPartitioningResolvesAliases(t *testing.T) {
+	disk := &blueprint.DiskCustomization{
+		Type: "dos",
+		Partitions: []blueprint.PartitionCustomization{
+			{
+				PartType: "swap",
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					FSType: "swap",
+				},
+			},
+			{
+				PartType: "83",
+				FilesystemTypedCustomization: blueprint.FilesystemTypedCustomization{
+					FSType:     "xfs",
+					Mountpoint: "/",
+				},
+			},
+		},
+	}
+	c := &blueprint.Customizations{Disk: disk}
+
+	resolved, err := c.GetPartitioning()
+	require.NoError(t, err)
+	assert.Equal(t, "82", resolved.Partitions[0].PartType)
+	assert.Equal(t, "83", resolved.Partitions[1].PartType)
+	// the customization itself keeps the alias
+	assert.Equal(t, "swap", disk.Partitions[0].PartType)
+
+	disk.Type = "gpt"
+	disk.Partitions[1].PartType = "root-x86_64"
+	resolved, err = c.GetPartitioning()
+	require.NoError(t, err)
+	assert.Equal(t, "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F", resolved.Partitions[0].PartType)
+	assert.Equal(t, "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709", resolved.Partitions[1].PartType)
+
+	resolved, err = (&blueprint.Customizations{}).GetPartitioning()
+	assert.NoError(t, err)
+	assert.Nil(t, resolved)
+}
@@ -0,0 +1,224 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+// RemoveMaskTarget is the target of the symlinks that mask paths, the
+// convention of systemd, udev and others for disabling a file shipped in a
+// lower priority directory.
+const RemoveMaskTarget = "/dev/null"
+
+// RemoveCustomization represents a path installed by packages that is
+// removed from the image. Removals are applied before the Directory, File
+// and Symlink customizations are created, so a customization can replace a
+// removed path, whether it is removed by its exact path or by a glob.
+type RemoveCustomization struct {
+	// Absolute path or glob pattern (see path.Match) of the paths to remove
+	Path string `json:"path" toml:"path"`
+	// Mask replaces the path with a symlink to /dev/null instead of
+	// removing it, which also survives package updates. Only supported
+	// for paths without glob characters.
+	Mask bool `json:"mask,omitempty" toml:"mask,omitempty"`
+}
+
+func (r *RemoveCustomization) UnmarshalTOML(data any) error {
+	return unmarshalTOMLviaJSON(r, data)
+}
+
+// Custom JSON unmarshalling for RemoveCustomization with validation
+func (r *RemoveCustomization) UnmarshalJSON(data []byte) error {
+	type removeCustomization RemoveCustomization
+
+	var removePrivate removeCustomization
+	if err := json.Unmarshal(data, &removePrivate); err != nil {
+		return err
+	}
+
+	remove := RemoveCustomization(removePrivate)
+	if err := remove.Validate(); err != nil {
+		return err
+	}
+
+	*r = remove
+	return nil
+}
+
+// IsGlob returns true if the path is a glob pattern.
+func (r RemoveCustomization) IsGlob() bool {
+	return strings.ContainsAny(r.Path, `*?[\`)
+}
+
+// Validate checks that the path is an absolute, canonical path or glob.
+func (r RemoveCustomization) Validate() error {
+	if r.Path == "" || r.Path[0] != '/' {
+		return fmt.Errorf("invalid remove path %q: path must be absolute", r.Path)
+	}
+	if r.Path == "/" || r.Path != path.Clean(r.Path) {
+		return fmt.Errorf("invalid remove path %q: path must be canonical and not the root directory", r.Path)
+	}
+	if _, err := path.Match(r.Path, ""); err != nil {
+		return fmt.Errorf("invalid remove path %q: invalid glob pattern", r.Path)
+	}
+	if r.Mask && r.IsGlob() {
+		return fmt.Errorf("invalid remove path %q: glob patterns cannot be masked", r.Path)
+	}
+	return nil
+}
+
+// Matches returns true if the customization removes p.
+func (r RemoveCustomization) Matches(p string) bool {
+	if !r.IsGlob() {
+		return r.Path == p
+	}
+	ok, _ := path.Match(r.Path, p)
+	return ok
+}
+
+// staticPrefix returns the leading directory of the path that contains no
+// glob characters.
+func (r RemoveCustomization) staticPrefix() string {
+	prefix := r.Path
+	for strings.ContainsAny(prefix, `*?[\`) {
+		prefix = path.Dir(prefix)
+	}
+	return prefix
+}
+
+// ToSymlinkCustomization returns the symlink that masks the path.
+func (r RemoveCustomization) ToSymlinkCustomization() (*SymlinkCustomization, error) {
+	if !r.Mask {
+		return nil, fmt.Errorf("remove path %q is not masked", r.Path)
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return &SymlinkCustomization{Path: r.Path, Target: RemoveMaskTarget}, nil
+}
+
+// ValidateRemoveCustomizations validates the given Remove customizations
+// together with the Directory, File and Symlink customizations. In addition
+// to the checks of ValidateDirFileCustomizations it ensures that:
+// - Every remove path is valid and not listed twice
+// - No masked path is also a customized directory, file or symlink, the
+// mask is a symlink itself
+// - No removed path is a parent of a customized path, unless it is created
+// again as a customized directory
+//
+// Removed paths, exact or matched by a glob, can be replaced by a
+// customization of the same path.
+func ValidateRemoveCustomizations(removes []RemoveCustomization, dirs []DirectoryCustomization, files []FileCustomization, symlinks []SymlinkCustomization) error {
+	if err := ValidateDirFileCustomizations(dirs, files); err != nil {
+		return err
+	}
+
+	customized := make(map[string]string, len(dirs)+len(files)+len(symlinks))
+	for _, dir := range dirs {
+		customized[dir.Path] = "directory"
+	}
+	for _, file := range files {
+		customized[file.Path] = "file"
+	}
+	for _, link := range symlinks {
+		customized[link.Path] = "symlink"
+	}
+
+	var errs []error
+	seen := make(map[string]bool, len(removes))
+	var valid []RemoveCustomization
+	for _, remove := range removes {
+		if err := remove.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if seen[remove.Path] {
+			errs = append(errs, fmt.Errorf("duplicate remove path %q", remove.Path))
+			continue
+		}
+		seen[remove.Path] = true
+		if kind, ok := customized[remove.Path]; ok && remove.Mask {
+			errs = append(errs, fmt.Errorf("masked remove path %q conflicts with the %s customization of the same path", remove.Path, kind))
+			continue
+		}
+		valid = append(valid, remove)
+	}
+
+	// removals happen first, the parents of the customized paths must
+	// survive them or be created again
+	nodePaths := append(dirPaths(dirs), filePaths(files)...)
+	for _, link := range symlinks {
+		nodePaths = append(nodePaths, link.Path)
+	}
+	for _, nodePath := range nodePaths {
+		for parent := path.Dir(nodePath); parent != "/"; parent = path.Dir(parent) {
+			if customized[parent] == "directory" {
+				break
+			}
+			if idx := removedBy(valid, parent); idx != -1 {
+				errs = append(errs, fmt.Errorf("remove path %q removes %q, the parent of the customized path %q", valid[idx].Path, parent, nodePath))
+				break
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid remove customizations:\n%w", err)
+	}
+	return nil
+}
+
+func dirPaths(dirs []DirectoryCustomization) []string {
+	paths := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		paths = append(paths, dir.Path)
+	}
+	return paths
+}
+
+func filePaths(files []FileCustomization) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+// removedBy returns the index of the first customization removing p or -1.
+func removedBy(removes []RemoveCustomization, p string) int {
+	for idx, remove := range removes {
+		if remove.Matches(p) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// CheckRemoveCustomizationsPolicy checks if the given Remove customizations are allowed by the path policy.
+// For glob patterns both the pattern and its leading directory without glob characters are checked.
+// If any of the customizations are not allowed by the path policy, an error is returned. Otherwise, nil is returned.
+func CheckRemoveCustomizationsPolicy(removes []RemoveCustomization, pathPolicy *pathpolicy.PathPolicies) error {
+	var invalidPaths []string
+	for _, remove := range removes {
+		if err := pathPolicy.Check(remove.Path); err != nil {
+			invalidPaths = append(invalidPaths, remove.Path)
+			continue
+		}
+		if remove.IsGlob() {
+			if err := pathPolicy.Check(remove.staticPrefix()); err != nil {
+				invalidPaths = append(invalidPaths, remove.Path)
+			}
+		}
+	}
+
+	if len(invalidPaths) > 0 {
+		return fmt.Errorf("the following remove paths are not allowed: %+q", invalidPaths)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+func TestRemoveCustomizationValidate(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Remove RemoveCustomization
+		Error  string
+	}{
+		{
+			Name:   "path",
+			Remove: RemoveCustomization{Path: "/etc/motd.d/cockpit"},
+		},
+		{
+			Name:   "glob",
+			Remove: RemoveCustomization{Path: "/etc/yum.repos.d/*.repo"},
+		},
+		{
+			Name:   "mask",
+			Remove: RemoveCustomization{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Mask: true},
+		},
+		{
+			Name:   "relative",
+			Remove: RemoveCustomization{Path: "etc/motd"},
+			Error:  `invalid remove path "etc/motd": path must be absolute`,
+		},
+		{
+			Name:   "root",
+			Remove: RemoveCustomization{Path: "/"},
+			Error:  `invalid remove path "/": path must be canonical and not the root directory`,
+		},
+		{
+			Name:   "not-canonical",
+			Remove: RemoveCustomization{Path: "/etc/../motd"},
+			Error:  `invalid remove path "/etc/../motd": path must be canonical and not the root directory`,
+		},
+		{
+			Name:   "bad-glob",
+			Remove: RemoveCustomization{Path: "/etc/[a-"},
+			Error:  `invalid remove path "/etc/[a-": invalid glob pattern`,
+		},
+		{
+			Name:   "masked-glob",
+			Remove: RemoveCustomization{Path: "/etc/udev/rules.d/*.rules", Mask: true},
+			Error:  `invalid remove path "/etc/udev/rules.d/*.rules": glob patterns cannot be masked`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Remove.Validate()
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRemoveCustomizationMatches(t *testing.T) {
+	glob := RemoveCustomization{Path: "/etc/yum.repos.d/*.repo"}
+	assert.True(t, glob.Matches("/etc/yum.repos.d/fedora.repo"))
+	assert.False(t, glob.Matches("/etc/yum.repos.d/sub/fedora.repo"))
+	assert.False(t, glob.Matches("/etc/yum.repos.d"))
+
+	exact := RemoveCustomization{Path: "/etc/motd"}
+	assert.True(t, exact.Matches("/etc/motd"))
+	assert.False(t, exact.Matches("/etc/motd.d"))
+}
+
+func TestRemoveCustomizationToSymlinkCustomization(t *testing.T) {
+	link, err := RemoveCustomization{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Mask: true}.ToSymlinkCustomization()
+	require.NoError(t, err)
+	assert.Equal(t, &SymlinkCustomization{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Target: "/dev/null"}, link)
+	assert.NoError(t, link.Validate())
+
+	_, err = RemoveCustomization{Path: "/etc/motd"}.ToSymlinkCustomization()
+	assert.EqualError(t, err, `remove path "/etc/motd" is not masked`)
+}
+
+func TestValidateRemoveCustomizations(t *testing.T) {
+	dirs := []DirectoryCustomization{
+		{Path: "/etc/yum.repos.d"},
+	}
+	files := []FileCustomization{
+		{Path: "/etc/yum.repos.d/custom.repo"},
+		{Path: "/etc/motd.d/custom"},
+	}
+	symlinks := []SymlinkCustomization{
+		{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Target: "/etc/udev/custom.rules"},
+	}
+
+	// the custom repo file replaces the removed ones
+	assert.NoError(t, ValidateRemoveCustomizations([]RemoveCustomization{
+		{Path: "/etc/yum.repos.d/*.repo"},
+		{Path: "/etc/yum.repos.d"},
+		{Path: "/etc/motd.d/cockpit"},
+	}, nil, files[1:], nil))
+	assert.NoError(t, ValidateRemoveCustomizations([]RemoveCustomization{
+		{Path: "/etc/yum.repos.d/*.repo"},
+	}, dirs, files, symlinks))
+	assert.NoError(t, ValidateRemoveCustomizations(nil, nil, nil, nil))
+
+	// exact paths are replaced the same way as globs
+	assert.NoError(t, ValidateRemoveCustomizations([]RemoveCustomization{
+		{Path: "/etc/yum.repos.d"},
+		{Path: "/etc/yum.repos.d/custom.repo"},
+		{Path: "/etc/udev/rules.d/80-net-setup-link.rules"},
+	}, dirs, files, symlinks))
+
+	// errors of the directories and files are reported as before
+	assert.EqualError(t, ValidateRemoveCustomizations(nil, dirs, append(files, FileCustomization{Path: "/etc/motd.d/custom"}), nil),
+		"duplicate files / directory customization paths: [/etc/motd.d/custom]")
+
+	err := ValidateRemoveCustomizations([]RemoveCustomization{
+		{Path: "/etc/yum.repos.d", Mask: true},
+		{Path: "/etc/yum.repos.d/custom.repo", Mask: true},
+		{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Mask: true},
+		{Path: "/etc/motd.d/cockpit"},
+		{Path: "/etc/motd.d/cockpit"},
+		{Path: "/etc/motd*"},
+		{Path: "/etc/udev"},
+		{Path: "motd"},
+	}, dirs, files, symlinks)
+	assert.EqualError(t, err, `invalid remove customizations:
+masked remove path "/etc/yum.repos.d" conflicts with the directory customization of the same path
+masked remove path "/etc/yum.repos.d/custom.repo" conflicts with the file customization of the same path
+masked remove path "/etc/udev/rules.d/80-net-setup-link.rules" conflicts with the symlink customization of the same path
+duplicate remove path "/etc/motd.d/cockpit"
+invalid remove path "motd": path must be absolute
+remove path "/etc/motd*" removes "/etc/motd.d", the parent of the customized path "/etc/motd.d/custom"
+remove path "/etc/udev" removes "/etc/udev", the parent of the customized path "/etc/udev/rules.d/80-net-setup-link.rules"`)
+}
+
+func TestCheckRemoveCustomizationsPolicy(t *testing.T) {
+	pathPolicy := pathpolicy.NewPathPolicies(map[string]pathpolicy.PathPolicy{
+		"/":           {Deny: true},
+		"/etc":        {},
+		"/etc/shadow": {Deny: true},
+	})
+
+	assert.NoError(t, CheckRemoveCustomizationsPolicy([]RemoveCustomization{
+		{Path: "/etc/motd"},
+		{Path: "/etc/yum.repos.d/*.repo"},
+	}, pathPolicy))
+	assert.EqualError(t, CheckRemoveCustomizationsPolicy([]RemoveCustomization{
+		{Path: "/etc/shadow"},
+		{Path: "/usr/lib/udev/rules.d/*.rules"},
+		{Path: "/*/motd"},
+	}, pathPolicy), `the following remove paths are not allowed: ["/etc/shadow" "/usr/lib/udev/rules.d/*.rules" "/*/motd"]`)
+}
+
+func TestRemoveCustomizationUnmarshal(t *testing.T) {
+	var bp struct {
+		Remove []RemoveCustomization `json:"remove" toml:"remove"`
+	}
+
+	_, err := toml.Decode(`
+[[remove]]
+path = "/etc/yum.repos.d/*.repo"
+
+[[remove]]
+path = "/etc/udev/rules.d/80-net-setup-link.rules"
+mask = true
+`, &bp)
+	require.NoError(t, err)
+	assert.Equal(t, []RemoveCustomization{
+		{Path: "/etc/yum.repos.d/*.repo"},
+		{Path: "/etc/udev/rules.d/80-net-setup-link.rules", Mask: true},
+	}, bp.Remove)
+
+	err = json.Unmarshal([]byte(`{"remove": [{"path": "/etc/*.conf", "mask": true}]}`), &bp)
+	assert.EqualError(t, err, `invalid remove path "/etc/*.conf": glob patterns cannot be masked`)
+}
@@ -1,12 +1,20 @@
 package blueprint
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/osbuild/blueprint/internal/common"
+	"github.com/osbuild/images/pkg/cert"
 	"github.com/osbuild/images/pkg/customizations/fsnode"
 	"github.com/osbuild/images/pkg/rpmmd"
 )
@@ -26,6 +34,13 @@ type RepositoryCustomization struct {
 	ModuleHotfixes *bool    `json:"module_hotfixes,omitempty" toml:"module_hotfixes,omitempty"`
 	Filename       string   `json:"filename,omitempty" toml:"filename,omitempty"`
 
+	// TLS settings for repositories that require client certificates.
+	// Each can be an absolute path in the image or inline PEM content,
+	// which is written to a file under /etc/pki/.
+	SSLCACert     string `json:"sslcacert,omitempty" toml:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty" toml:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty" toml:"sslclientkey,omitempty"`
+
 	// When set the repository will be used during the depsolve of
 	// payload repositories to install packages from it.
 	InstallFrom bool `json:"install_from" toml:"install_from"`
@@ -51,25 +66,176 @@ func validateCustomRepository(repo *RepositoryCustomization) error {
 		return fmt.Errorf("Repository gpg check is set to true but no gpg keys are provided")
 	}
 
+	if err := validateRepoTLS(repo); err != nil {
+		return err
+	}
+
 	for _, key := range repo.GPGKeys {
-		// check for a valid GPG key prefix & contains GPG suffix
-		keyIsGPGKey := strings.HasPrefix(key, "-----BEGIN PGP PUBLIC KEY BLOCK-----") && strings.Contains(key, "-----END PGP PUBLIC KEY BLOCK-----")
+		if !isInlineGPGKey(key) {
+			continue
+		}
+		if !strings.Contains(key, "-----BEGIN PGP ") {
+			return fmt.Errorf("Repository gpg key is not a valid URL or a valid gpg key")
+		}
+		if _, err := ParseOpenPGPPublicKeys(key); err != nil {
+			return fmt.Errorf("Repository gpg key is not a valid gpg key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateRepositorySet validates the repositories individually and as a
+// set: repository IDs must be unique and must not collide with the IDs of
+// the base repositories of the distribution given in reservedIDs, the names
+// of the .repo files must not differ only in case and repositories sharing a
+// .repo file must not use the same base URL, mirrorlist or metalink. All
+// conflicts are returned at once.
+func ValidateRepositorySet(repos []RepositoryCustomization, reservedIDs []string) error {
+	var errs []error
 
-		// check for a valid URL
-		keyIsURL := false
-		_, err := url.ParseRequestURI(key)
-		if err == nil {
-			keyIsURL = true
+	ids := make(map[string]int)
+	filenames := make(map[string]string)
+	// the sources of the repositories, per .repo file
+	sources := make(map[string]map[string]string)
+	for idx := range repos {
+		repo := &repos[idx]
+		if err := validateCustomRepository(repo); err != nil {
+			errs = append(errs, fmt.Errorf("repository %d (%q): %w", idx, repo.Id, err))
+		}
+		if repo.Id == "" {
+			continue
 		}
 
-		if !keyIsGPGKey && !keyIsURL {
-			return fmt.Errorf("Repository gpg key is not a valid URL or a valid gpg key")
+		if prev, ok := ids[repo.Id]; ok {
+			errs = append(errs, fmt.Errorf("repository %d: duplicate repository ID %q (also used by repository %d)", idx, repo.Id, prev))
+		} else {
+			ids[repo.Id] = idx
+		}
+		if slices.Contains(reservedIDs, repo.Id) {
+			errs = append(errs, fmt.Errorf("repository %q collides with a base repository of the distribution", repo.Id))
+		}
+
+		filename := repo.getFilename()
+		if prev, ok := filenames[strings.ToLower(filename)]; ok && prev != filename {
+			errs = append(errs, fmt.Errorf("repository %q: filename %q differs from %q only in case", repo.Id, filename, prev))
+		} else {
+			filenames[strings.ToLower(filename)] = filename
+		}
+
+		if sources[filename] == nil {
+			sources[filename] = make(map[string]string)
+		}
+		for _, source := range repo.sources() {
+			if prev, ok := sources[filename][source]; ok && prev != repo.Id {
+				errs = append(errs, fmt.Errorf("repositories %q and %q in %q both use %q", prev, repo.Id, filename, source))
+				continue
+			}
+			sources[filename][source] = repo.Id
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid repository customizations:\n%w", err)
+	}
+	return nil
+}
+
+// sources returns the base URLs, mirrorlist and metalink of the repository.
+func (rc *RepositoryCustomization) sources() []string {
+	sources := slices.Clone(rc.BaseURLs)
+	if rc.Mirrorlist != "" {
+		sources = append(sources, rc.Mirrorlist)
+	}
+	if rc.Metalink != "" {
+		sources = append(sources, rc.Metalink)
+	}
+	return sources
+}
+
+// isInlineGPGKey returns true if key is not a URL, so it must be the armored
+// key itself.
+func isInlineGPGKey(key string) bool {
+	_, err := url.ParseRequestURI(key)
+	return err != nil
+}
+
+// isInlinePEM returns true if s is PEM content rather than a path.
+func isInlinePEM(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN ")
+}
+
+func validateRepoTLSPath(name, path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("Repository %s %q is not an absolute path or inline PEM content", name, path)
+	}
+	return nil
+}
+
+// validateRepoTLS checks that inline TLS certificates and keys can be
+// parsed and that an inline client certificate matches its inline key.
+func validateRepoTLS(repo *RepositoryCustomization) error {
+	if repo.SSLCACert != "" {
+		if isInlinePEM(repo.SSLCACert) {
+			if _, err := cert.ParseCerts(repo.SSLCACert); err != nil {
+				return fmt.Errorf("Repository sslcacert is not a valid PEM certificate: %w", err)
+			}
+		} else if err := validateRepoTLSPath("sslcacert", repo.SSLCACert); err != nil {
+			return err
+		}
+	}
+
+	if (repo.SSLClientCert == "") != (repo.SSLClientKey == "") {
+		return fmt.Errorf("Repository sslclientcert and sslclientkey must be set together")
+	}
+	if repo.SSLClientCert == "" {
+		return nil
+	}
+
+	if isInlinePEM(repo.SSLClientCert) {
+		if _, err := cert.ParseCerts(repo.SSLClientCert); err != nil {
+			return fmt.Errorf("Repository sslclientcert is not a valid PEM certificate: %w", err)
+		}
+	} else if err := validateRepoTLSPath("sslclientcert", repo.SSLClientCert); err != nil {
+		return err
+	}
+
+	if isInlinePEM(repo.SSLClientKey) {
+		if err := validatePEMPrivateKey(repo.SSLClientKey); err != nil {
+			return fmt.Errorf("Repository sslclientkey is not a valid PEM private key: %w", err)
+		}
+	} else if err := validateRepoTLSPath("sslclientkey", repo.SSLClientKey); err != nil {
+		return err
+	}
+
+	if isInlinePEM(repo.SSLClientCert) && isInlinePEM(repo.SSLClientKey) {
+		if _, err := tls.X509KeyPair([]byte(repo.SSLClientCert), []byte(repo.SSLClientKey)); err != nil {
+			return fmt.Errorf("Repository sslclientcert does not match sslclientkey: %w", err)
 		}
 	}
 
 	return nil
 }
 
+func validatePEMPrivateKey(data string) error {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(data)))
+	if block == nil {
+		return fmt.Errorf("no PEM block found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		_, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		return err
+	case "RSA PRIVATE KEY":
+		_, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return err
+	case "EC PRIVATE KEY":
+		_, err := x509.ParseECPrivateKey(block.Bytes)
+		return err
+	}
+	return fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
 func (rc *RepositoryCustomization) getFilename() string {
 	if rc.Filename == "" {
 		return fmt.Sprintf("%s.repo", rc.Id)
@@ -80,6 +246,74 @@ func (rc *RepositoryCustomization) getFilename() string {
 	return rc.Filename
 }
 
+// inlineGPGKeyPath returns the path of the file for an inline GPG key of a
+// repository.
+func inlineGPGKeyPath(repoID string, idx int) string {
+	return fmt.Sprintf("/etc/pki/rpm-gpg/RPM-GPG-KEY-%s-%d", repoID, idx)
+}
+
+// inlineGPGKeyPaths returns the file paths of the inline GPG keys of the
+// repositories, indexed like repos and their GPGKeys. Keys that are URLs have
+// an empty path. Identical keys used by more than one repository share the
+// path of their first use.
+func inlineGPGKeyPaths(repos []RepositoryCustomization) [][]string {
+	paths := make([][]string, len(repos))
+	keyPaths := make(map[string]string)
+	for i, repo := range repos {
+		paths[i] = make([]string, len(repo.GPGKeys))
+		for idx, gpgkey := range repo.GPGKeys {
+			if !isInlineGPGKey(gpgkey) {
+				continue
+			}
+			key := strings.TrimSpace(gpgkey)
+			path, ok := keyPaths[key]
+			if !ok {
+				path = inlineGPGKeyPath(repo.Id, idx)
+				keyPaths[key] = path
+			}
+			paths[i][idx] = path
+		}
+	}
+	return paths
+}
+
+// repoTLSFile is a TLS setting of a repository that can hold inline PEM
+// content.
+type repoTLSFile struct {
+	value *string
+	path  string
+	mode  os.FileMode
+}
+
+// tlsFiles returns the TLS settings of the repository with the paths used
+// for inline content. Private keys are only readable by root.
+func (rc *RepositoryCustomization) tlsFiles() []repoTLSFile {
+	return []repoTLSFile{
+		{&rc.SSLCACert, fmt.Sprintf("/etc/pki/tls/certs/%s-ca.pem", rc.Id), 0644},
+		{&rc.SSLClientCert, fmt.Sprintf("/etc/pki/tls/certs/%s-client.pem", rc.Id), 0644},
+		{&rc.SSLClientKey, fmt.Sprintf("/etc/pki/tls/private/%s-client.key", rc.Id), 0600},
+	}
+}
+
+// inlineTLSToPaths replaces inline TLS content with the paths of the files
+// the content is written to and returns the files.
+func (rc *RepositoryCustomization) inlineTLSToPaths() ([]*fsnode.File, error) {
+	var files []*fsnode.File
+	for _, f := range rc.tlsFiles() {
+		if !isInlinePEM(*f.value) {
+			continue
+		}
+		mode := f.mode
+		file, err := fsnode.NewFile(f.path, &mode, nil, nil, []byte(*f.value))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		*f.value = f.path
+	}
+	return files, nil
+}
+
 func RepoCustomizationsInstallFromOnly(repos []RepositoryCustomization) []rpmmd.RepoConfig {
 	var res []rpmmd.RepoConfig
 	for _, repo := range repos {
@@ -91,6 +325,11 @@ func RepoCustomizationsInstallFromOnly(repos []RepositoryCustomization) []rpmmd.
 	return res
 }
 
+// RepoCustomizationsToRepoConfigAndGPGKeyFiles converts the repositories to
+// repository configs, grouped by the name of their .repo file. Inline GPG
+// keys and inline TLS certificates and keys are returned as files and the
+// repository configs reference them by path. Inline GPG keys used by more
+// than one repository are written once, to the path of their first use.
 func RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos []RepositoryCustomization) (map[string][]rpmmd.RepoConfig, []*fsnode.File, error) {
 	if len(repos) == 0 {
 		return nil, nil, nil
@@ -98,25 +337,42 @@ func RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos []RepositoryCustomizatio
 
 	repoMap := make(map[string][]rpmmd.RepoConfig, len(repos))
 	var gpgKeyFiles []*fsnode.File
-	for _, repo := range repos {
+	keyPaths := inlineGPGKeyPaths(repos)
+	written := make(map[string]bool)
+	for i, repo := range repos {
 		filename := repo.getFilename()
+
+		// convert any inline TLS certificates and keys to fsnode.File
+		// and replace them with the file paths
+		tlsFiles, err := repo.inlineTLSToPaths()
+		if err != nil {
+			return nil, nil, err
+		}
+		gpgKeyFiles = append(gpgKeyFiles, tlsFiles...)
+
 		convertedRepo := repo.customRepoToRepoConfig()
 
 		// convert any inline gpgkeys to fsnode.File and
 		// replace the gpgkey with the file path
 		for idx, gpgkey := range repo.GPGKeys {
-			if _, ok := url.ParseRequestURI(gpgkey); ok != nil {
-				// create the file path
-				path := fmt.Sprintf("/etc/pki/rpm-gpg/RPM-GPG-KEY-%s-%d", repo.Id, idx)
-				// replace the gpgkey with the file path
-				convertedRepo.GPGKeys[idx] = fmt.Sprintf("file://%s", path)
-				// create the fsnode for the gpgkey keyFile
-				keyFile, err := fsnode.NewFile(path, nil, nil, nil, []byte(gpgkey))
-				if err != nil {
-					return nil, nil, err
-				}
-				gpgKeyFiles = append(gpgKeyFiles, keyFile)
+			path := keyPaths[i][idx]
+			if path == "" {
+				continue
+			}
+			// replace the gpgkey with the file path
+			convertedRepo.GPGKeys[idx] = fmt.Sprintf("file://%s", path)
+			// identical keys shared by several repositories are
+			// written only once
+			if written[path] {
+				continue
+			}
+			written[path] = true
+			// create the fsnode for the gpgkey keyFile
+			keyFile, err := fsnode.NewFile(path, nil, nil, nil, []byte(gpgkey))
+			if err != nil {
+				return nil, nil, err
 			}
+			gpgKeyFiles = append(gpgKeyFiles, keyFile)
 		}
 
 		repoMap[filename] = append(repoMap[filename], convertedRepo)
@@ -144,6 +400,9 @@ func (repo RepositoryCustomization) customRepoToRepoConfig() rpmmd.RepoConfig {
 		Priority:       repo.Priority,
 		ModuleHotfixes: repo.ModuleHotfixes,
 		Enabled:        repo.Enabled,
+		SSLCACert:      repo.SSLCACert,
+		SSLClientCert:  repo.SSLClientCert,
+		SSLClientKey:   repo.SSLClientKey,
 	}
 
 	if repo.SSLVerify != nil {
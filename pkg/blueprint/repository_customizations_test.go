@@ -1,13 +1,23 @@
 package blueprint
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/osbuild/blueprint/internal/common"
 	"github.com/osbuild/images/pkg/customizations/fsnode"
 	"github.com/osbuild/images/pkg/rpmmd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetCustomRepositories(t *testing.T) {
@@ -305,7 +315,7 @@ func TestCustomRepoToRepoConfigAndGPGKeys(t *testing.T) {
 			},
 			WantGPGKeys: []*fsnode.File{
 				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-1-----END PGP PUBLIC KEY BLOCK-----\n"))),
-				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-0", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-1-----END PGP PUBLIC KEY BLOCK-----\n"))),
+				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-0", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-2-----END PGP PUBLIC KEY BLOCK-----\n"))),
 			},
 		},
 		{
@@ -343,7 +353,7 @@ func TestCustomRepoToRepoConfigAndGPGKeys(t *testing.T) {
 					{
 						Id:       "example-2",
 						BaseURLs: []string{"http://example-2.com"},
-						GPGKeys:  []string{"file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-0", "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-1"},
+						GPGKeys:  []string{"file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0", "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-1"},
 						CheckGPG: common.ToPtr(true),
 					},
 				},
@@ -351,17 +361,207 @@ func TestCustomRepoToRepoConfigAndGPGKeys(t *testing.T) {
 			WantGPGKeys: []*fsnode.File{
 				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-1-----END PGP PUBLIC KEY BLOCK-----\n"))),
 				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-1", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-2-----END PGP PUBLIC KEY BLOCK-----\n"))),
-				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-0", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-1-----END PGP PUBLIC KEY BLOCK-----\n"))),
-				ensureFileCreation(fsnode.NewFile("/etc/pki/rpm-gpg/RPM-GPG-KEY-example-2-1", nil, nil, nil, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-2-----END PGP PUBLIC KEY BLOCK-----\n"))),
 			},
 		},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.Name, func(t *testing.T) {
-			got, _, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(tt.Repos)
+			got, gotGPGKeys, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(tt.Repos)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.WantRepoConfig, got)
+			assert.Equal(t, tt.WantGPGKeys, gotGPGKeys)
+		})
+	}
+}
+
+// generateTestCertAndKey returns a self-signed PEM certificate and its PEM
+// encoded private key.
+func generateTestCertAndKey(t *testing.T, cn string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestValidateRepoTLS(t *testing.T) {
+	caCert, _ := generateTestCertAndKey(t, "ca")
+	clientCert, clientKey := generateTestCertAndKey(t, "client")
+	_, otherKey := generateTestCertAndKey(t, "other")
+
+	testCases := map[string]struct {
+		repo RepositoryCustomization
+		err  string
+	}{
+		"inline": {
+			repo: RepositoryCustomization{SSLCACert: caCert, SSLClientCert: clientCert, SSLClientKey: clientKey},
+		},
+		"paths": {
+			repo: RepositoryCustomization{SSLCACert: "/etc/pki/ca.pem", SSLClientCert: "/etc/pki/client.pem", SSLClientKey: "/etc/pki/client.key"},
+		},
+		"mixed": {
+			repo: RepositoryCustomization{SSLClientCert: clientCert, SSLClientKey: "/etc/pki/client.key"},
+		},
+		"relative-path": {
+			repo: RepositoryCustomization{SSLCACert: "ca.pem"},
+			err:  `Repository sslcacert "ca.pem" is not an absolute path or inline PEM content`,
+		},
+		"bad-ca": {
+			repo: RepositoryCustomization{SSLCACert: "-----BEGIN CERTIFICATE-----\ngarbage\n-----END CERTIFICATE-----\n"},
+			err:  "Repository sslcacert is not a valid PEM certificate",
+		},
+		"cert-without-key": {
+			repo: RepositoryCustomization{SSLClientCert: clientCert},
+			err:  "Repository sslclientcert and sslclientkey must be set together",
+		},
+		"key-is-cert": {
+			repo: RepositoryCustomization{SSLClientCert: clientCert, SSLClientKey: caCert},
+			err:  `Repository sslclientkey is not a valid PEM private key: unsupported PEM block type "CERTIFICATE"`,
+		},
+		"mismatch": {
+			repo: RepositoryCustomization{SSLClientCert: clientCert, SSLClientKey: otherKey},
+			err:  "Repository sslclientcert does not match sslclientkey",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateRepoTLS(&tc.repo)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
 		})
 	}
 }
+
+func TestCustomRepoToRepoConfigTLSFiles(t *testing.T) {
+	caCert, _ := generateTestCertAndKey(t, "ca")
+	clientCert, clientKey := generateTestCertAndKey(t, "client")
+
+	repos := []RepositoryCustomization{
+		{
+			Id:            "internal",
+			BaseURLs:      []string{"https://mirror.internal/repo"},
+			SSLCACert:     caCert,
+			SSLClientCert: clientCert,
+			SSLClientKey:  clientKey,
+		},
+		{
+			Id:           "paths",
+			BaseURLs:     []string{"https://mirror.internal/other"},
+			SSLCACert:    "/etc/pki/tls/certs/ca-bundle.crt",
+			SSLClientKey: "/etc/pki/client.key",
+		},
+	}
+	require.NoError(t, validateCustomRepository(&repos[0]))
+
+	repoMap, files, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos)
+	require.NoError(t, err)
+
+	assert.Equal(t, rpmmd.RepoConfig{
+		Id:            "internal",
+		BaseURLs:      []string{"https://mirror.internal/repo"},
+		GPGKeys:       []string{},
+		SSLCACert:     "/etc/pki/tls/certs/internal-ca.pem",
+		SSLClientCert: "/etc/pki/tls/certs/internal-client.pem",
+		SSLClientKey:  "/etc/pki/tls/private/internal-client.key",
+	}, repoMap["internal.repo"][0])
+	assert.Equal(t, "/etc/pki/tls/certs/ca-bundle.crt", repoMap["paths.repo"][0].SSLCACert)
+	assert.Equal(t, "/etc/pki/client.key", repoMap["paths.repo"][0].SSLClientKey)
+
+	require.Len(t, files, 3)
+	assert.Equal(t, "/etc/pki/tls/certs/internal-ca.pem", files[0].Path())
+	assert.Equal(t, caCert, string(files[0].Data()))
+	assert.Equal(t, os.FileMode(0644), *files[0].Mode())
+	assert.Equal(t, "/etc/pki/tls/certs/internal-client.pem", files[1].Path())
+	assert.Equal(t, os.FileMode(0644), *files[1].Mode())
+	assert.Equal(t, "/etc/pki/tls/private/internal-client.key", files[2].Path())
+	assert.Equal(t, clientKey, string(files[2].Data()))
+	// private keys must only be readable by root
+	assert.Equal(t, os.FileMode(0600), *files[2].Mode())
+
+	// the input is not modified
+	assert.Equal(t, caCert, repos[0].SSLCACert)
+
+	contents := RepoCustomizationsToRepoFileContents(repos)
+	assert.Equal(t, `[internal]
+baseurl=https://mirror.internal/repo
+sslcacert=/etc/pki/tls/certs/internal-ca.pem
+sslclientcert=/etc/pki/tls/certs/internal-client.pem
+sslclientkey=/etc/pki/tls/private/internal-client.key
+`, contents["internal.repo"])
+}
+
+func TestValidateRepositorySet(t *testing.T) {
+	repos := []RepositoryCustomization{
+		{
+			Id:       "example-1",
+			BaseURLs: []string{"http://example.com/1", "http://mirror.example.com/1"},
+			Filename: "shared.repo",
+		},
+		{
+			Id:       "example-2",
+			BaseURLs: []string{"http://example.com/2"},
+			Filename: "shared",
+		},
+		{
+			Id:       "example-3",
+			Metalink: "http://example.com/metalink",
+		},
+	}
+	assert.NoError(t, ValidateRepositorySet(repos, []string{"baseos", "appstream"}))
+	assert.NoError(t, ValidateRepositorySet(nil, nil))
+
+	repos = append(repos,
+		RepositoryCustomization{
+			Id:       "example-1",
+			BaseURLs: []string{"http://example.com/other"},
+		},
+		RepositoryCustomization{
+			Id:       "baseos",
+			BaseURLs: []string{"http://example.com/baseos"},
+		},
+		RepositoryCustomization{
+			Id:       "example-4",
+			BaseURLs: []string{"http://mirror.example.com/1"},
+			Filename: "shared.repo",
+		},
+		RepositoryCustomization{
+			Id:       "example-5",
+			Metalink: "http://example.com/metalink",
+			Filename: "Shared.repo",
+		},
+		RepositoryCustomization{
+			Id: "example-6",
+		},
+	)
+	err := ValidateRepositorySet(repos, []string{"baseos", "appstream"})
+	assert.EqualError(t, err, `invalid repository customizations:
+repository 3: duplicate repository ID "example-1" (also used by repository 0)
+repository "baseos" collides with a base repository of the distribution
+repositories "example-1" and "example-4" in "shared.repo" both use "http://mirror.example.com/1"
+repository "example-5": filename "Shared.repo" differs from "shared.repo" only in case
+repository 7 ("example-6"): Repository base URL, mirrorlist or metalink is required`)
+
+	c := &Customizations{Repositories: repos[:3]}
+	assert.NoError(t, c.CheckRepositories())
+	assert.ErrorContains(t, c.CheckRepositories("example-3"), `repository "example-3" collides with a base repository of the distribution`)
+	assert.NoError(t, (*Customizations)(nil).CheckRepositories("baseos"))
+}
@@ -0,0 +1,385 @@
+package blueprint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+// Variables dnf substitutes in repository definitions without additional
+// configuration in /etc/dnf/vars.
+var knownRepoVariables = []string{"arch", "basearch", "releasever", "releasever_major", "releasever_minor"}
+
+// $name or ${name}
+var repoVariableRegex = regexp.MustCompile(`\$(\{[^}]*\}?|[a-zA-Z0-9_]*)`)
+
+var repoVariableNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// repo file keys that are lists of URLs
+var repoFileListKeys = []string{"baseurl", "gpgkey"}
+
+// ParseRepoFile parses a yum/dnf .repo file, see dnf.conf(5), into
+// repository customizations, one per section. Keys that have no equivalent
+// in [RepositoryCustomization] are ignored, use [ParseRepoFileWithWarnings]
+// to get a list of them.
+func ParseRepoFile(r io.Reader) ([]RepositoryCustomization, error) {
+	repos, _, err := ParseRepoFileWithWarnings(r)
+	return repos, err
+}
+
+// ParseRepoFileWithWarnings is like [ParseRepoFile] but also returns a
+// warning for every ignored key and for variables other than the ones dnf
+// knows without additional configuration.
+func ParseRepoFileWithWarnings(r io.Reader) ([]RepositoryCustomization, []string, error) {
+	var repos []RepositoryCustomization
+	var warnings []string
+
+	// the section currently parsed, nil outside of a repository section
+	var repo *RepositoryCustomization
+	var section string
+	// the key of the last value, for continuation lines
+	var lastKey string
+
+	setValue := func(lineno int, key, value string) error {
+		if repo == nil {
+			if section == "" {
+				return fmt.Errorf("line %d: key %q outside of a section", lineno, key)
+			}
+			// [main] has no repository
+			return nil
+		}
+
+		parseBool := func() (*bool, error) {
+			switch strings.ToLower(value) {
+			case "1", "yes", "true", "on":
+				return common.ToPtr(true), nil
+			case "0", "no", "false", "off":
+				return common.ToPtr(false), nil
+			}
+			return nil, fmt.Errorf("line %d: invalid boolean %q for %q", lineno, value, key)
+		}
+
+		var err error
+		switch key {
+		case "name":
+			repo.Name = value
+		case "baseurl":
+			repo.BaseURLs = append(repo.BaseURLs, splitRepoFileList(value)...)
+		case "gpgkey":
+			repo.GPGKeys = append(repo.GPGKeys, splitRepoFileList(value)...)
+		case "metalink":
+			repo.Metalink = value
+		case "mirrorlist":
+			repo.Mirrorlist = value
+		case "priority":
+			var priority int
+			priority, err = strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid priority %q", lineno, value)
+			}
+			repo.Priority = &priority
+		case "enabled":
+			repo.Enabled, err = parseBool()
+		case "gpgcheck":
+			repo.GPGCheck, err = parseBool()
+		case "repo_gpgcheck":
+			repo.RepoGPGCheck, err = parseBool()
+		case "sslverify":
+			repo.SSLVerify, err = parseBool()
+		case "module_hotfixes":
+			repo.ModuleHotfixes, err = parseBool()
+		case "sslcacert":
+			repo.SSLCACert = value
+		case "sslclientcert":
+			repo.SSLClientCert = value
+		case "sslclientkey":
+			repo.SSLClientKey = value
+		default:
+			warnings = append(warnings, fmt.Sprintf("line %d: unsupported key %q in repository %q ignored", lineno, key, repo.Id))
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		// continuation of a list value
+		if raw[0] == ' ' || raw[0] == '\t' {
+			if lastKey == "" || !slices.Contains(repoFileListKeys, lastKey) {
+				return nil, nil, fmt.Errorf("line %d: unexpected continuation line", lineno)
+			}
+			if err := setValue(lineno, lastKey, line); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, nil, fmt.Errorf("line %d: invalid section header %q", lineno, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			lastKey = ""
+			if section == "" {
+				return nil, nil, fmt.Errorf("line %d: empty section name", lineno)
+			}
+			if section == "main" {
+				warnings = append(warnings, fmt.Sprintf("line %d: [main] section ignored", lineno))
+				repo = nil
+				continue
+			}
+			if slices.ContainsFunc(repos, func(r RepositoryCustomization) bool { return r.Id == section }) {
+				return nil, nil, fmt.Errorf("line %d: duplicate repository %q", lineno, section)
+			}
+			repos = append(repos, RepositoryCustomization{Id: section})
+			repo = &repos[len(repos)-1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("line %d: expected key=value, got %q", lineno, line)
+		}
+		lastKey = strings.ToLower(strings.TrimSpace(key))
+		if err := setValue(lineno, lastKey, strings.TrimSpace(value)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for idx := range repos {
+		if err := validateCustomRepository(&repos[idx]); err != nil {
+			return nil, nil, fmt.Errorf("repository %q: %w", repos[idx].Id, err)
+		}
+		names, err := repos[idx].variables()
+		if err != nil {
+			return nil, nil, fmt.Errorf("repository %q: %w", repos[idx].Id, err)
+		}
+		for _, name := range names {
+			if !slices.Contains(knownRepoVariables, name) {
+				warnings = append(warnings, fmt.Sprintf("repository %q uses variable %q that requires configuration in /etc/dnf/vars", repos[idx].Id, name))
+			}
+		}
+	}
+
+	return repos, warnings, nil
+}
+
+// splitRepoFileList splits a list value, dnf accepts whitespace and commas
+// as separators.
+func splitRepoFileList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// repoVariables returns the names of the variables used in s.
+func repoVariables(s string) ([]string, error) {
+	var names []string
+	for _, match := range repoVariableRegex.FindAllString(s, -1) {
+		name := strings.TrimPrefix(match, "$")
+		if strings.HasPrefix(name, "{") {
+			if !strings.HasSuffix(name, "}") {
+				return nil, fmt.Errorf("unterminated variable %q in %q", match, s)
+			}
+			name = name[1 : len(name)-1]
+		}
+		if !repoVariableNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable %q in %q", match, s)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// repoVariableFields returns pointers to the fields in which dnf
+// substitutes variables.
+func (rc *RepositoryCustomization) repoVariableFields() []*string {
+	fields := []*string{&rc.Name, &rc.Metalink, &rc.Mirrorlist}
+	for idx := range rc.BaseURLs {
+		fields = append(fields, &rc.BaseURLs[idx])
+	}
+	for idx := range rc.GPGKeys {
+		if _, err := url.ParseRequestURI(rc.GPGKeys[idx]); err == nil {
+			fields = append(fields, &rc.GPGKeys[idx])
+		}
+	}
+	return fields
+}
+
+// variables returns the sorted names of the variables used by the
+// repository.
+func (rc *RepositoryCustomization) variables() ([]string, error) {
+	var names []string
+	for _, field := range rc.repoVariableFields() {
+		fieldNames, err := repoVariables(*field)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fieldNames...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// ExpandRepoVariables replaces the dnf variables ($name or ${name}) in s
+// with their values. The values of releasever_major and releasever_minor
+// are derived from releasever if they are not set. It is an error if s uses
+// a variable that has no value.
+func ExpandRepoVariables(s string, vars map[string]string) (string, error) {
+	names, err := repoVariables(s)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return s, nil
+	}
+
+	values := make(map[string]string, len(vars)+2)
+	if releasever, ok := vars["releasever"]; ok {
+		major, minor, _ := strings.Cut(releasever, ".")
+		values["releasever_major"] = major
+		values["releasever_minor"] = minor
+	}
+	for name, value := range vars {
+		values[name] = value
+	}
+
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return "", fmt.Errorf("undefined variable %q in %q", name, s)
+		}
+	}
+
+	return repoVariableRegex.ReplaceAllStringFunc(s, func(match string) string {
+		name := strings.Trim(strings.TrimPrefix(match, "$"), "{}")
+		return values[name]
+	}), nil
+}
+
+// ExpandVariables returns a copy of the repository with the dnf variables
+// in its name, URLs and GPG key URLs replaced, see [ExpandRepoVariables].
+func (rc RepositoryCustomization) ExpandVariables(vars map[string]string) (RepositoryCustomization, error) {
+	rc.BaseURLs = slices.Clone(rc.BaseURLs)
+	rc.GPGKeys = slices.Clone(rc.GPGKeys)
+	for _, field := range rc.repoVariableFields() {
+		expanded, err := ExpandRepoVariables(*field, vars)
+		if err != nil {
+			return RepositoryCustomization{}, fmt.Errorf("repository %q: %w", rc.Id, err)
+		}
+		*field = expanded
+	}
+	return rc, nil
+}
+
+// RenderRepoFile renders the repositories in the .repo file format, the
+// inverse of [ParseRepoFile]. List values are written one item per line.
+func RenderRepoFile(repos []RepositoryCustomization) string {
+	var b strings.Builder
+
+	writeList := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s=%s\n", key, strings.Join(values, "\n\t"))
+	}
+	writeBool := func(key string, value *bool) {
+		if value == nil {
+			return
+		}
+		if *value {
+			fmt.Fprintf(&b, "%s=1\n", key)
+		} else {
+			fmt.Fprintf(&b, "%s=0\n", key)
+		}
+	}
+
+	for idx, repo := range repos {
+		if idx > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", repo.Id)
+		if repo.Name != "" {
+			fmt.Fprintf(&b, "name=%s\n", repo.Name)
+		}
+		writeList("baseurl", repo.BaseURLs)
+		if repo.Metalink != "" {
+			fmt.Fprintf(&b, "metalink=%s\n", repo.Metalink)
+		}
+		if repo.Mirrorlist != "" {
+			fmt.Fprintf(&b, "mirrorlist=%s\n", repo.Mirrorlist)
+		}
+		writeBool("enabled", repo.Enabled)
+		writeBool("gpgcheck", repo.GPGCheck)
+		writeBool("repo_gpgcheck", repo.RepoGPGCheck)
+		writeList("gpgkey", repo.GPGKeys)
+		if repo.Priority != nil {
+			fmt.Fprintf(&b, "priority=%d\n", *repo.Priority)
+		}
+		writeBool("sslverify", repo.SSLVerify)
+		writeBool("module_hotfixes", repo.ModuleHotfixes)
+		if repo.SSLCACert != "" {
+			fmt.Fprintf(&b, "sslcacert=%s\n", repo.SSLCACert)
+		}
+		if repo.SSLClientCert != "" {
+			fmt.Fprintf(&b, "sslclientcert=%s\n", repo.SSLClientCert)
+		}
+		if repo.SSLClientKey != "" {
+			fmt.Fprintf(&b, "sslclientkey=%s\n", repo.SSLClientKey)
+		}
+	}
+
+	return b.String()
+}
+
+// RepoCustomizationsToRepoFileContents returns the content of the .repo
+// files for the repositories, indexed by the same file names as the map
+// returned by [RepoCustomizationsToRepoConfigAndGPGKeyFiles]. Inline GPG
+// keys and TLS files are referenced by the paths of the files created by
+// that function.
+func RepoCustomizationsToRepoFileContents(repos []RepositoryCustomization) map[string]string {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	byFilename := make(map[string][]RepositoryCustomization)
+	keyPaths := inlineGPGKeyPaths(repos)
+	for i, repo := range repos {
+		repo.GPGKeys = slices.Clone(repo.GPGKeys)
+		for idx, path := range keyPaths[i] {
+			if path != "" {
+				repo.GPGKeys[idx] = "file://" + path
+			}
+		}
+		for _, f := range repo.tlsFiles() {
+			if isInlinePEM(*f.value) {
+				*f.value = f.path
+			}
+		}
+		filename := repo.getFilename()
+		byFilename[filename] = append(byFilename[filename], repo)
+	}
+
+	contents := make(map[string]string, len(byFilename))
+	for filename, fileRepos := range byFilename {
+		contents[filename] = RenderRepoFile(fileRepos)
+	}
+	return contents
+}
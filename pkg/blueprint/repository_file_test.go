@@ -0,0 +1,302 @@
+package blueprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+const testRepoFile = `# comment
+[main]
+gpgcheck=1
+
+[fedora]
+name=Fedora $releasever - $basearch
+metalink=https://mirrors.fedoraproject.org/metalink?repo=fedora-$releasever&arch=$basearch
+enabled=1
+countme=1
+metadata_expire=7d
+repo_gpgcheck=0
+type=rpm
+gpgcheck=1
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-$releasever-$basearch
+skip_if_unavailable=False
+
+; another comment
+[custom]
+name = Custom repository
+baseurl = https://example.com/repo/${releasever}/$basearch/,
+    https://mirror.example.com/repo/$releasever/$basearch/
+  https://other.example.com/repo/$releasever_major/$basearch/
+gpgkey=https://example.com/key1.asc https://example.com/key2.asc
+gpgcheck=yes
+priority=10
+sslverify=off
+module_hotfixes=True
+Enabled=no
+`
+
+func TestParseRepoFile(t *testing.T) {
+	repos, warnings, err := ParseRepoFileWithWarnings(strings.NewReader(testRepoFile))
+	require.NoError(t, err)
+	assert.Equal(t, []RepositoryCustomization{
+		{
+			Id:           "fedora",
+			Name:         "Fedora $releasever - $basearch",
+			Metalink:     "https://mirrors.fedoraproject.org/metalink?repo=fedora-$releasever&arch=$basearch",
+			Enabled:      common.ToPtr(true),
+			RepoGPGCheck: common.ToPtr(false),
+			GPGCheck:     common.ToPtr(true),
+			GPGKeys:      []string{"file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-$releasever-$basearch"},
+		},
+		{
+			Id:   "custom",
+			Name: "Custom repository",
+			BaseURLs: []string{
+				"https://example.com/repo/${releasever}/$basearch/",
+				"https://mirror.example.com/repo/$releasever/$basearch/",
+				"https://other.example.com/repo/$releasever_major/$basearch/",
+			},
+			GPGKeys:        []string{"https://example.com/key1.asc", "https://example.com/key2.asc"},
+			GPGCheck:       common.ToPtr(true),
+			Priority:       common.ToPtr(10),
+			SSLVerify:      common.ToPtr(false),
+			ModuleHotfixes: common.ToPtr(true),
+			Enabled:        common.ToPtr(false),
+		},
+	}, repos)
+	assert.Equal(t, []string{
+		"line 2: [main] section ignored",
+		`line 9: unsupported key "countme" in repository "fedora" ignored`,
+		`line 10: unsupported key "metadata_expire" in repository "fedora" ignored`,
+		`line 12: unsupported key "type" in repository "fedora" ignored`,
+		`line 15: unsupported key "skip_if_unavailable" in repository "fedora" ignored`,
+	}, warnings)
+
+	plain, err := ParseRepoFile(strings.NewReader(testRepoFile))
+	require.NoError(t, err)
+	assert.Equal(t, repos, plain)
+}
+
+func TestParseRepoFileErrors(t *testing.T) {
+	testCases := map[string]struct {
+		data string
+		err  string
+	}{
+		"outside-section": {
+			data: "baseurl=https://example.com\n",
+			err:  `line 1: key "baseurl" outside of a section`,
+		},
+		"bad-header": {
+			data: "[repo\n",
+			err:  `line 1: invalid section header "[repo"`,
+		},
+		"empty-header": {
+			data: "[ ]\n",
+			err:  "line 1: empty section name",
+		},
+		"duplicate": {
+			data: "[a]\nbaseurl=https://a\n[a]\n",
+			err:  `line 3: duplicate repository "a"`,
+		},
+		"no-equals": {
+			data: "[a]\nbaseurl\n",
+			err:  `line 2: expected key=value, got "baseurl"`,
+		},
+		"bad-continuation": {
+			data: "[a]\nname=A\n  more\n",
+			err:  "line 3: unexpected continuation line",
+		},
+		"bad-bool": {
+			data: "[a]\ngpgcheck=maybe\n",
+			err:  `line 2: invalid boolean "maybe" for "gpgcheck"`,
+		},
+		"bad-priority": {
+			data: "[a]\npriority=high\n",
+			err:  `line 2: invalid priority "high"`,
+		},
+		"invalid-repo": {
+			data: "[a]\nname=A\n",
+			err:  `repository "a": Repository base URL, mirrorlist or metalink is required`,
+		},
+		"bad-variable": {
+			data: "[a]\nbaseurl=https://example.com/${releasever/\n",
+			err:  `repository "a": unterminated variable "${releasever/" in "https://example.com/${releasever/"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRepoFile(strings.NewReader(tc.data))
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestParseRepoFileCustomVariables(t *testing.T) {
+	_, warnings, err := ParseRepoFileWithWarnings(strings.NewReader("[a]\nbaseurl=https://example.com/$stream/$basearch\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{`repository "a" uses variable "stream" that requires configuration in /etc/dnf/vars`}, warnings)
+}
+
+func TestExpandRepoVariables(t *testing.T) {
+	vars := map[string]string{"releasever": "9.4", "basearch": "x86_64"}
+
+	expanded, err := ExpandRepoVariables("https://example.com/$releasever/${basearch}/$releasever_major/$releasever_minor", vars)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/9.4/x86_64/9/4", expanded)
+
+	expanded, err = ExpandRepoVariables("https://example.com/plain", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/plain", expanded)
+
+	_, err = ExpandRepoVariables("https://example.com/$arch", vars)
+	assert.EqualError(t, err, `undefined variable "arch" in "https://example.com/$arch"`)
+
+	_, err = ExpandRepoVariables("https://example.com/$/", vars)
+	assert.EqualError(t, err, `invalid variable "$" in "https://example.com/$/"`)
+
+	repo := RepositoryCustomization{
+		Id:       "custom",
+		Name:     "Custom $releasever",
+		BaseURLs: []string{"https://example.com/$releasever/$basearch"},
+		GPGKeys:  []string{"https://example.com/$releasever_major.asc", "-----BEGIN PGP PUBLIC KEY BLOCK-----$notavar-----END PGP PUBLIC KEY BLOCK-----"},
+	}
+	expandedRepo, err := repo.ExpandVariables(vars)
+	require.NoError(t, err)
+	assert.Equal(t, "Custom 9.4", expandedRepo.Name)
+	assert.Equal(t, []string{"https://example.com/9.4/x86_64"}, expandedRepo.BaseURLs)
+	assert.Equal(t, []string{"https://example.com/9.asc", "-----BEGIN PGP PUBLIC KEY BLOCK-----$notavar-----END PGP PUBLIC KEY BLOCK-----"}, expandedRepo.GPGKeys)
+	// the original is not modified
+	assert.Equal(t, []string{"https://example.com/$releasever/$basearch"}, repo.BaseURLs)
+
+	_, err = repo.ExpandVariables(map[string]string{"basearch": "aarch64"})
+	assert.EqualError(t, err, `repository "custom": undefined variable "releasever" in "Custom $releasever"`)
+}
+
+func TestRenderRepoFile(t *testing.T) {
+	repos, err := ParseRepoFile(strings.NewReader(testRepoFile))
+	require.NoError(t, err)
+
+	rendered := RenderRepoFile(repos)
+	assert.Equal(t, `[fedora]
+name=Fedora $releasever - $basearch
+metalink=https://mirrors.fedoraproject.org/metalink?repo=fedora-$releasever&arch=$basearch
+enabled=1
+gpgcheck=1
+repo_gpgcheck=0
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-$releasever-$basearch
+
+[custom]
+name=Custom repository
+baseurl=https://example.com/repo/${releasever}/$basearch/
+	https://mirror.example.com/repo/$releasever/$basearch/
+	https://other.example.com/repo/$releasever_major/$basearch/
+enabled=0
+gpgcheck=1
+gpgkey=https://example.com/key1.asc
+	https://example.com/key2.asc
+priority=10
+sslverify=0
+module_hotfixes=1
+`, rendered)
+
+	// rendering is the inverse of parsing
+	reparsed, warnings, err := ParseRepoFileWithWarnings(strings.NewReader(rendered))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, repos, reparsed)
+}
+
+func TestRepoCustomizationsToRepoFileContents(t *testing.T) {
+	repos := []RepositoryCustomization{
+		{
+			Id:       "example-1",
+			BaseURLs: []string{"http://example-1.com"},
+			GPGKeys:  []string{"-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-1-----END PGP PUBLIC KEY BLOCK-----\n", "https://example-1.com/key.asc"},
+			GPGCheck: common.ToPtr(true),
+			Filename: "shared.repo",
+		},
+		{
+			Id:       "example-2",
+			BaseURLs: []string{"http://example-2.com"},
+			Filename: "shared",
+		},
+		{
+			Id:       "example-3",
+			Metalink: "http://example-3.com/metalink",
+		},
+	}
+
+	contents := RepoCustomizationsToRepoFileContents(repos)
+	assert.Equal(t, map[string]string{
+		"shared.repo": `[example-1]
+baseurl=http://example-1.com
+gpgcheck=1
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0
+	https://example-1.com/key.asc
+
+[example-2]
+baseurl=http://example-2.com
+`,
+		"example-3.repo": `[example-3]
+metalink=http://example-3.com/metalink
+`,
+	}, contents)
+
+	// the same files as the repo configs
+	repoMap, _, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos)
+	require.NoError(t, err)
+	for filename := range repoMap {
+		assert.Contains(t, contents, filename)
+	}
+	assert.Len(t, contents, len(repoMap))
+
+	// inline keys are not modified in the input
+	assert.True(t, strings.HasPrefix(repos[0].GPGKeys[0], "-----BEGIN"))
+
+	assert.Nil(t, RepoCustomizationsToRepoFileContents(nil))
+}
+
+func TestRepoCustomizationsToRepoFileContentsSharedInlineGPGKey(t *testing.T) {
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----fake-gpg-key-----END PGP PUBLIC KEY BLOCK-----\n"
+	repos := []RepositoryCustomization{
+		{
+			Id:       "example-1",
+			BaseURLs: []string{"http://example-1.com"},
+			GPGKeys:  []string{key},
+		},
+		{
+			Id:       "example-2",
+			BaseURLs: []string{"http://example-2.com"},
+			GPGKeys:  []string{"https://example-2.com/key.asc", key},
+		},
+	}
+
+	contents := RepoCustomizationsToRepoFileContents(repos)
+	assert.Equal(t, map[string]string{
+		"example-1.repo": `[example-1]
+baseurl=http://example-1.com
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0
+`,
+		"example-2.repo": `[example-2]
+baseurl=http://example-2.com
+gpgkey=https://example-2.com/key.asc
+	file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0
+`,
+	}, contents)
+
+	// the .repo files reference exactly the generated key files
+	_, keyFiles, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos)
+	require.NoError(t, err)
+	require.Len(t, keyFiles, 1)
+	assert.Equal(t, "/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1-0", keyFiles[0].Path())
+	assert.Equal(t, []byte(key), keyFiles[0].Data())
+	for _, content := range contents {
+		assert.Contains(t, content, "file://"+keyFiles[0].Path())
+	}
+}
@@ -1,10 +1,145 @@
 package blueprint
 
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
 type RPMImportKeys struct {
 	// File paths in the image to import keys from
 	Files []string `json:"files,omitempty" toml:"files,omitempty"`
+
+	// ASCII armored public keys to write to the image and import
+	Keys []string `json:"keys,omitempty" toml:"keys,omitempty"`
 }
 
 type RPMCustomization struct {
 	ImportKeys *RPMImportKeys `json:"import_keys,omitempty" toml:"import_keys,omitempty"`
 }
+
+const (
+	// rpmGPGKeyDir is where inline repository keys and inline import keys
+	// are written to.
+	rpmGPGKeyDir = "/etc/pki/rpm-gpg"
+
+	rpmImportKeyPathPrefix = rpmGPGKeyDir + "/RPM-GPG-KEY-blueprint-import-"
+)
+
+// rpmImportKeyPath returns the path of the file for an inline import key.
+func rpmImportKeyPath(idx int) string {
+	return fmt.Sprintf("%s%d", rpmImportKeyPathPrefix, idx)
+}
+
+// Validate checks that the files are absolute paths and that the inline
+// keys are valid public keys.
+func (k *RPMImportKeys) Validate() error {
+	if k == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, file := range k.Files {
+		if !filepath.IsAbs(file) || filepath.Clean(file) != file {
+			errs = append(errs, fmt.Errorf("import key file %q must be a clean absolute path", file))
+		}
+	}
+	for idx, key := range k.Keys {
+		if _, err := ParseOpenPGPPublicKeys(key); err != nil {
+			errs = append(errs, fmt.Errorf("import key %d: %w", idx, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid rpm import keys:\n%w", err)
+	}
+	return nil
+}
+
+// KeyInfo returns the fingerprints and user IDs of the inline keys.
+func (k *RPMImportKeys) KeyInfo() ([]OpenPGPKeyInfo, error) {
+	if k == nil {
+		return nil, nil
+	}
+	var keys []OpenPGPKeyInfo
+	for idx, key := range k.Keys {
+		info, err := ParseOpenPGPPublicKeys(key)
+		if err != nil {
+			return nil, fmt.Errorf("import key %d: %w", idx, err)
+		}
+		keys = append(keys, info...)
+	}
+	return keys, nil
+}
+
+// ImportKeyFiles returns the paths of the keys to import and the files for
+// the inline keys. Inline keys identical to an inline key of a repository
+// reuse the file written for the repository. Files pointing to the location
+// of an inline repository key must match a key that is actually generated
+// for one of the repositories.
+func (k *RPMImportKeys) ImportKeyFiles(repos []RepositoryCustomization) ([]string, []*fsnode.File, error) {
+	if k == nil {
+		return nil, nil, nil
+	}
+	if err := k.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	// the inline keys of the repositories, by content and by path
+	repoKeyPaths := make(map[string]string)
+	generated := make(map[string]bool)
+	_, repoFiles, err := RepoCustomizationsToRepoConfigAndGPGKeyFiles(repos)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range repoFiles {
+		if filepath.Dir(file.Path()) != rpmGPGKeyDir {
+			continue
+		}
+		generated[file.Path()] = true
+		if _, ok := repoKeyPaths[strings.TrimSpace(string(file.Data()))]; !ok {
+			repoKeyPaths[strings.TrimSpace(string(file.Data()))] = file.Path()
+		}
+	}
+
+	var errs []error
+	var paths []string
+	for _, file := range k.Files {
+		for _, repo := range repos {
+			if strings.HasPrefix(file, fmt.Sprintf("%s/RPM-GPG-KEY-%s-", rpmGPGKeyDir, repo.Id)) && !generated[file] {
+				errs = append(errs, fmt.Errorf("import key file %q does not match an inline gpg key of repository %q", file, repo.Id))
+				break
+			}
+		}
+		if strings.HasPrefix(file, rpmImportKeyPathPrefix) {
+			errs = append(errs, fmt.Errorf("import key file %q is reserved for inline import keys", file))
+		}
+		paths = append(paths, file)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, fmt.Errorf("invalid rpm import keys:\n%w", err)
+	}
+
+	var files []*fsnode.File
+	for idx, key := range k.Keys {
+		path, ok := repoKeyPaths[strings.TrimSpace(key)]
+		if !ok {
+			path = rpmImportKeyPath(idx)
+			file, err := fsnode.NewFile(path, nil, nil, nil, []byte(key))
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, file)
+			repoKeyPaths[strings.TrimSpace(key)] = path
+		}
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, files, nil
+}
@@ -0,0 +1,80 @@
+package blueprint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPMImportKeysValidate(t *testing.T) {
+	key := armorTestOpenPGPKey(t, generateTestOpenPGPKey(t, "import", time.Now().Add(-time.Hour), 0), false)
+
+	assert.NoError(t, (*RPMImportKeys)(nil).Validate())
+	assert.NoError(t, (&RPMImportKeys{Files: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY"}, Keys: []string{key}}).Validate())
+
+	err := (&RPMImportKeys{
+		Files: []string{"RPM-GPG-KEY", "/etc/pki/../RPM-GPG-KEY"},
+		Keys:  []string{"fake-gpg-key"},
+	}).Validate()
+	assert.EqualError(t, err, `invalid rpm import keys:
+import key file "RPM-GPG-KEY" must be a clean absolute path
+import key file "/etc/pki/../RPM-GPG-KEY" must be a clean absolute path
+import key 0: no armored block found`)
+}
+
+func TestRPMImportKeysImportKeyFiles(t *testing.T) {
+	now := time.Now()
+	repoKey := armorTestOpenPGPKey(t, generateTestOpenPGPKey(t, "repo", now.Add(-time.Hour), 0), false)
+	otherKey := armorTestOpenPGPKey(t, generateTestOpenPGPKey(t, "other", now.Add(-time.Hour), 0), false)
+
+	repos := []RepositoryCustomization{
+		{
+			Id:       "example",
+			BaseURLs: []string{"http://example.com"},
+			GPGKeys:  []string{"https://example.com/key.asc", repoKey},
+		},
+	}
+
+	c := Customizations{
+		Repositories: repos,
+		RPM: &RPMCustomization{
+			ImportKeys: &RPMImportKeys{
+				Files: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1", "/etc/pki/rpm-gpg/RPM-GPG-KEY-fedora"},
+				Keys:  []string{repoKey, otherKey},
+			},
+		},
+	}
+	paths, files, err := c.GetRPMImportKeys()
+	require.NoError(t, err)
+	// the inline key identical to the key of the repository is not
+	// written twice
+	assert.Equal(t, []string{
+		"/etc/pki/rpm-gpg/RPM-GPG-KEY-example-1",
+		"/etc/pki/rpm-gpg/RPM-GPG-KEY-fedora",
+		"/etc/pki/rpm-gpg/RPM-GPG-KEY-blueprint-import-1",
+	}, paths)
+	require.Len(t, files, 1)
+	assert.Equal(t, "/etc/pki/rpm-gpg/RPM-GPG-KEY-blueprint-import-1", files[0].Path())
+	assert.Equal(t, otherKey, string(files[0].Data()))
+
+	info, err := c.RPM.ImportKeys.KeyInfo()
+	require.NoError(t, err)
+	require.Len(t, info, 2)
+	assert.Equal(t, []string{"other <other@example.com>"}, info[1].UserIDs)
+
+	// the repository key is written to RPM-GPG-KEY-example-1, not -0
+	c.RPM.ImportKeys = &RPMImportKeys{
+		Files: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-example-0", "/etc/pki/rpm-gpg/RPM-GPG-KEY-blueprint-import-0"},
+	}
+	_, _, err = c.GetRPMImportKeys()
+	assert.EqualError(t, err, `invalid rpm import keys:
+import key file "/etc/pki/rpm-gpg/RPM-GPG-KEY-example-0" does not match an inline gpg key of repository "example"
+import key file "/etc/pki/rpm-gpg/RPM-GPG-KEY-blueprint-import-0" is reserved for inline import keys`)
+
+	paths, files, err = (&Customizations{}).GetRPMImportKeys()
+	assert.NoError(t, err)
+	assert.Nil(t, paths)
+	assert.Nil(t, files)
+}
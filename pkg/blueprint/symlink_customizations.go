@@ -0,0 +1,174 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+// SymlinkCustomization represents a symbolic link to be created in the image
+type SymlinkCustomization struct {
+	// Absolute path of the link
+	Path string `json:"path" toml:"path"`
+	// Target of the link, absolute or relative to the directory of the
+	// link. The target does not need to exist.
+	Target string `json:"target" toml:"target"`
+	// Owner of the link specified as a string (user name), int64 (UID) or nil
+	User any `json:"user,omitempty" toml:"user,omitempty"`
+	// Owner of the link specified as a string (group name), int64 (GID) or nil
+	Group any `json:"group,omitempty" toml:"group,omitempty"`
+	// EnsureParents ensures that all parent directories of the link exist
+	EnsureParents bool `json:"ensure_parents,omitempty" toml:"ensure_parents,omitempty"`
+}
+
+func (s *SymlinkCustomization) UnmarshalTOML(data any) error {
+	return unmarshalTOMLviaJSON(s, data)
+}
+
+// Custom JSON unmarshalling for SymlinkCustomization with validation
+func (s *SymlinkCustomization) UnmarshalJSON(data []byte) error {
+	type symlinkCustomization SymlinkCustomization
+
+	var linkPrivate symlinkCustomization
+	if err := json.Unmarshal(data, &linkPrivate); err != nil {
+		return err
+	}
+
+	link := SymlinkCustomization(linkPrivate)
+	if uid, ok := link.User.(float64); ok {
+		// check if uid can be converted to int64
+		if uid != float64(int64(uid)) {
+			return fmt.Errorf("invalid user %f: must be an integer", uid)
+		}
+		link.User = int64(uid)
+	}
+	if gid, ok := link.Group.(float64); ok {
+		// check if gid can be converted to int64
+		if gid != float64(int64(gid)) {
+			return fmt.Errorf("invalid group %f: must be an integer", gid)
+		}
+		link.Group = int64(gid)
+	}
+	if err := link.Validate(); err != nil {
+		return err
+	}
+
+	*s = link
+	return nil
+}
+
+// Validate checks the path, target and owner of the link.
+func (s SymlinkCustomization) Validate() error {
+	// fsnode has no symlink node, the path and owner rules are the same
+	// as for files
+	if _, err := fsnode.NewFile(s.Path, nil, s.User, s.Group, nil); err != nil {
+		return fmt.Errorf("invalid symlink %q: %w", s.Path, err)
+	}
+	if s.Target == "" {
+		return fmt.Errorf("invalid symlink %q: target must not be empty", s.Path)
+	}
+	if strings.ContainsRune(s.Target, 0) {
+		return fmt.Errorf("invalid symlink %q: target must not contain NUL characters", s.Path)
+	}
+	if s.ResolvedTarget() == s.Path {
+		return fmt.Errorf("invalid symlink %q: link points to itself", s.Path)
+	}
+	return nil
+}
+
+// ResolvedTarget returns the absolute, cleaned target of the link.
+func (s SymlinkCustomization) ResolvedTarget() string {
+	if path.IsAbs(s.Target) {
+		return path.Clean(s.Target)
+	}
+	return path.Join(path.Dir(s.Path), s.Target)
+}
+
+// ValidateSymlinkCustomizations validates the given Symlink customizations
+// together with the Directory and File customizations. In addition to the
+// checks of ValidateDirFileCustomizations it ensures that:
+// - Every link is valid
+// - No link path is used by another link, directory or file
+// - No link path is a parent of another link, directory or file, the link
+// would shadow the customized directory
+func ValidateSymlinkCustomizations(symlinks []SymlinkCustomization, dirs []DirectoryCustomization, files []FileCustomization) error {
+	if err := ValidateDirFileCustomizations(dirs, files); err != nil {
+		return err
+	}
+
+	paths := make(map[string]string, len(dirs)+len(files))
+	for _, dir := range dirs {
+		paths[dir.Path] = "directory"
+	}
+	for _, file := range files {
+		paths[file.Path] = "file"
+	}
+
+	var errs []error
+	links := make(map[string]bool, len(symlinks))
+	for _, link := range symlinks {
+		if err := link.Validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if links[link.Path] {
+			errs = append(errs, fmt.Errorf("duplicate symlink %q", link.Path))
+			continue
+		}
+		if kind, ok := paths[link.Path]; ok {
+			errs = append(errs, fmt.Errorf("symlink %q conflicts with the %s customization of the same path", link.Path, kind))
+		}
+		links[link.Path] = true
+	}
+
+	// a link can't be the parent of another node, its children would be
+	// created in the target
+	nodePaths := make([]string, 0, len(paths)+len(links))
+	for nodePath := range paths {
+		nodePaths = append(nodePaths, nodePath)
+	}
+	for nodePath := range links {
+		nodePaths = append(nodePaths, nodePath)
+	}
+	slices.Sort(nodePaths)
+	for _, nodePath := range nodePaths {
+		for parent := path.Dir(nodePath); parent != "/"; parent = path.Dir(parent) {
+			if links[parent] {
+				errs = append(errs, fmt.Errorf("symlink %q is a parent of the customized path %q", parent, nodePath))
+				break
+			}
+			if paths[parent] == "file" && links[nodePath] {
+				errs = append(errs, fmt.Errorf("file %q is a parent of the symlink %q", parent, nodePath))
+				break
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid symlink customizations:\n%w", err)
+	}
+	return nil
+}
+
+// CheckSymlinkCustomizationsPolicy checks if the given Symlink customizations are allowed by the path policy.
+// If any of the customizations are not allowed by the path policy, an error is returned. Otherwise, nil is returned.
+func CheckSymlinkCustomizationsPolicy(symlinks []SymlinkCustomization, pathPolicy *pathpolicy.PathPolicies) error {
+	var invalidPaths []string
+	for _, link := range symlinks {
+		if err := pathPolicy.Check(link.Path); err != nil {
+			invalidPaths = append(invalidPaths, link.Path)
+		}
+	}
+
+	if len(invalidPaths) > 0 {
+		return fmt.Errorf("the following custom symlinks are not allowed: %+q", invalidPaths)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/pathpolicy"
+)
+
+func TestSymlinkCustomizationValidate(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Link  SymlinkCustomization
+		Error string
+	}{
+		{
+			Name: "absolute-target",
+			Link: SymlinkCustomization{Path: "/etc/localtime", Target: "/usr/share/zoneinfo/Europe/Prague"},
+		},
+		{
+			Name: "relative-target",
+			Link: SymlinkCustomization{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC", User: "root", Group: int64(0)},
+		},
+		{
+			Name:  "relative-path",
+			Link:  SymlinkCustomization{Path: "etc/localtime", Target: "/usr/share/zoneinfo/UTC"},
+			Error: `invalid symlink "etc/localtime": path must be absolute`,
+		},
+		{
+			Name:  "root",
+			Link:  SymlinkCustomization{Path: "/", Target: "/usr"},
+			Error: `invalid symlink "/": path must not end with a slash`,
+		},
+		{
+			Name:  "no-target",
+			Link:  SymlinkCustomization{Path: "/etc/localtime"},
+			Error: `invalid symlink "/etc/localtime": target must not be empty`,
+		},
+		{
+			Name:  "nul-target",
+			Link:  SymlinkCustomization{Path: "/etc/localtime", Target: "/usr\x00"},
+			Error: `invalid symlink "/etc/localtime": target must not contain NUL characters`,
+		},
+		{
+			Name:  "self",
+			Link:  SymlinkCustomization{Path: "/etc/alternatives/java", Target: "./java"},
+			Error: `invalid symlink "/etc/alternatives/java": link points to itself`,
+		},
+		{
+			Name:  "bad-user",
+			Link:  SymlinkCustomization{Path: "/etc/localtime", Target: "/usr", User: int64(-1)},
+			Error: `invalid symlink "/etc/localtime": user ID must be non-negative`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Link.Validate()
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSymlinkCustomizationResolvedTarget(t *testing.T) {
+	assert.Equal(t, "/usr/share/zoneinfo/UTC", SymlinkCustomization{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC"}.ResolvedTarget())
+	assert.Equal(t, "/usr/bin/java", SymlinkCustomization{Path: "/etc/alternatives/java", Target: "/usr/bin//java"}.ResolvedTarget())
+}
+
+func TestSymlinkCustomizationUnmarshal(t *testing.T) {
+	var bp struct {
+		Symlinks []SymlinkCustomization `json:"symlinks" toml:"symlinks"`
+	}
+
+	err := json.Unmarshal([]byte(`{"symlinks": [{"path": "/etc/localtime", "target": "../usr/share/zoneinfo/UTC", "user": 0, "group": "root", "ensure_parents": true}]}`), &bp)
+	require.NoError(t, err)
+	assert.Equal(t, []SymlinkCustomization{
+		{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC", User: int64(0), Group: "root", EnsureParents: true},
+	}, bp.Symlinks)
+
+	bp.Symlinks = nil
+	_, err = toml.Decode(`
+[[symlinks]]
+path = "/etc/localtime"
+target = "../usr/share/zoneinfo/UTC"
+user = 0
+group = "root"
+ensure_parents = true
+`, &bp)
+	require.NoError(t, err)
+	assert.Equal(t, []SymlinkCustomization{
+		{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC", User: int64(0), Group: "root", EnsureParents: true},
+	}, bp.Symlinks)
+
+	err = json.Unmarshal([]byte(`{"symlinks": [{"path": "/etc/localtime", "target": "/usr", "user": 1.5}]}`), &bp)
+	assert.EqualError(t, err, "invalid user 1.500000: must be an integer")
+
+	_, err = toml.Decode(`
+[[symlinks]]
+path = "etc/localtime"
+target = "/usr"
+`, &bp)
+	assert.ErrorContains(t, err, `invalid symlink "etc/localtime": path must be absolute`)
+}
+
+func TestValidateSymlinkCustomizations(t *testing.T) {
+	dirs := []DirectoryCustomization{
+		{Path: "/etc/myapp"},
+		{Path: "/etc/myapp/conf.d"},
+	}
+	files := []FileCustomization{
+		{Path: "/etc/myapp/conf.d/main.conf"},
+		{Path: "/etc/motd"},
+	}
+
+	assert.NoError(t, ValidateSymlinkCustomizations([]SymlinkCustomization{
+		{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC"},
+		{Path: "/etc/myapp/current", Target: "conf.d"},
+		{Path: "/usr/share/myapp", Target: "/etc/myapp"},
+	}, dirs, files))
+	assert.NoError(t, ValidateSymlinkCustomizations(nil, nil, nil))
+
+	// errors of the directories and files are reported as before
+	assert.EqualError(t, ValidateSymlinkCustomizations(nil, dirs, append(files, FileCustomization{Path: "/etc/motd"})),
+		"duplicate files / directory customization paths: [/etc/motd]")
+
+	err := ValidateSymlinkCustomizations([]SymlinkCustomization{
+		{Path: "/etc/myapp/conf.d", Target: "/usr/share/myapp"},
+		{Path: "/etc/motd", Target: "/usr/share/motd"},
+		{Path: "/etc/localtime", Target: "/usr/share/zoneinfo/UTC"},
+		{Path: "/etc/localtime", Target: "/usr/share/zoneinfo/CET"},
+		{Path: "/etc/motd/link", Target: "/usr"},
+		{Path: "/etc/relative", Target: ""},
+	}, dirs, files)
+	assert.EqualError(t, err, `invalid symlink customizations:
+symlink "/etc/myapp/conf.d" conflicts with the directory customization of the same path
+symlink "/etc/motd" conflicts with the file customization of the same path
+duplicate symlink "/etc/localtime"
+invalid symlink "/etc/relative": target must not be empty
+symlink "/etc/motd" is a parent of the customized path "/etc/motd/link"
+symlink "/etc/myapp/conf.d" is a parent of the customized path "/etc/myapp/conf.d/main.conf"`)
+}
+
+func TestCheckSymlinkCustomizationsPolicy(t *testing.T) {
+	pathPolicy := pathpolicy.NewPathPolicies(map[string]pathpolicy.PathPolicy{
+		"/":    {Deny: true},
+		"/etc": {},
+	})
+
+	assert.NoError(t, CheckSymlinkCustomizationsPolicy([]SymlinkCustomization{{Path: "/etc/localtime", Target: "/usr/share/zoneinfo/UTC"}}, pathPolicy))
+	assert.EqualError(t, CheckSymlinkCustomizationsPolicy([]SymlinkCustomization{
+		{Path: "/etc/localtime", Target: "/usr/share/zoneinfo/UTC"},
+		{Path: "/usr/share/foo", Target: "/etc/foo"},
+	}, pathPolicy), `the following custom symlinks are not allowed: ["/usr/share/foo"]`)
+}
@@ -0,0 +1,309 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// SysctlCustomization maps kernel parameters to their values, e.g.
+// "net.ipv4.ip_forward" = 1. Keys can use the dotted or the slash syntax of
+// sysctl.d(5) and may be prefixed with "-" to ignore errors when the
+// parameter does not exist. Values can be strings, integers, booleans
+// (written as 1 or 0) or lists of integers (written space separated).
+type SysctlCustomization map[string]any
+
+// UnmarshalTOML flattens the tables TOML creates for unquoted dotted keys,
+// so `net.ipv4.ip_forward = 1` is the same as `"net.ipv4.ip_forward" = 1`.
+func (s *SysctlCustomization) UnmarshalTOML(data any) error {
+	table, ok := data.(map[string]any)
+	if !ok {
+		return fmt.Errorf("UnmarshalTOML: sysctl must be a table")
+	}
+	flat := make(SysctlCustomization, len(table))
+	if err := flattenSysctlTable(flat, "", table); err != nil {
+		return fmt.Errorf("UnmarshalTOML: %w", err)
+	}
+	*s = flat
+	return nil
+}
+
+func flattenSysctlTable(flat SysctlCustomization, prefix string, table map[string]any) error {
+	for key, value := range table {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			if err := flattenSysctlTable(flat, key, nested); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := flat[key]; ok {
+			return fmt.Errorf("duplicate sysctl key %q", key)
+		}
+		flat[key] = value
+	}
+	return nil
+}
+
+// SysctlConfPath is the location of the rendered sysctl settings in the
+// image.
+const SysctlConfPath = "/etc/sysctl.d/90-blueprint.conf"
+
+// characters allowed in a component of a sysctl key, including the glob
+// characters supported by sysctl.d
+var sysctlKeyComponentRegex = regexp.MustCompile(`^[a-zA-Z0-9_.:@+*?-]+$`)
+
+// The SCAP Security Guide names its sysctl rules after the key, e.g.
+// xccdf_org.ssgproject.content_rule_sysctl_net_ipv4_ip_forward
+const openSCAPSysctlRulePrefix = "xccdf_org.ssgproject.content_rule_sysctl_"
+
+// The SCAP Security Guide profile IDs share this prefix, e.g.
+// xccdf_org.ssgproject.content_profile_cis
+const openSCAPProfilePrefix = "xccdf_org.ssgproject.content_profile_"
+
+// Values the CIS and STIG hardening profiles of the SCAP Security Guide
+// expect for common kernel parameters, indexed by normalized key. A different
+// value in the blueprint would make the image fail its own scan.
+var openSCAPHardeningSysctls = map[string]string{
+	"fs/protected_hardlinks":                    "1",
+	"fs/protected_symlinks":                     "1",
+	"fs/suid_dumpable":                          "0",
+	"kernel/dmesg_restrict":                     "1",
+	"kernel/kptr_restrict":                      "1",
+	"kernel/randomize_va_space":                 "2",
+	"kernel/yama/ptrace_scope":                  "1",
+	"net/ipv4/conf/all/accept_redirects":        "0",
+	"net/ipv4/conf/all/accept_source_route":     "0",
+	"net/ipv4/conf/all/log_martians":            "1",
+	"net/ipv4/conf/all/rp_filter":               "1",
+	"net/ipv4/conf/all/secure_redirects":        "0",
+	"net/ipv4/conf/all/send_redirects":          "0",
+	"net/ipv4/conf/default/accept_redirects":    "0",
+	"net/ipv4/conf/default/accept_source_route": "0",
+	"net/ipv4/conf/default/send_redirects":      "0",
+	"net/ipv4/icmp_echo_ignore_broadcasts":      "1",
+	"net/ipv4/ip_forward":                       "0",
+	"net/ipv4/tcp_syncookies":                   "1",
+	"net/ipv6/conf/all/accept_ra":               "0",
+	"net/ipv6/conf/all/accept_redirects":        "0",
+	"net/ipv6/conf/default/accept_ra":           "0",
+	"net/ipv6/conf/default/accept_redirects":    "0",
+}
+
+// Expected sysctl values indexed by the profile ID without
+// openSCAPProfilePrefix. Profiles that are not listed are not checked.
+var openSCAPSysctlExpectations = map[string]map[string]string{
+	"cis":                openSCAPHardeningSysctls,
+	"cis_server_l1":      openSCAPHardeningSysctls,
+	"cis_workstation_l2": openSCAPHardeningSysctls,
+	"stig":               openSCAPHardeningSysctls,
+	"stig_gui":           openSCAPHardeningSysctls,
+}
+
+// normalizeSysctlKey returns the key in slash syntax without the "-"
+// prefix. In the dotted syntax dots separate the components, in the slash
+// syntax (detected by the first separator being a slash) dots are part of
+// the component, e.g. in "net/ipv4/conf/eth0.100/forwarding".
+func normalizeSysctlKey(key string) string {
+	key = strings.TrimPrefix(key, "-")
+	if idx := strings.IndexAny(key, "./"); idx != -1 && key[idx] == '/' {
+		return key
+	}
+	return strings.ReplaceAll(key, ".", "/")
+}
+
+func validateSysctlKey(key string) error {
+	normalized := normalizeSysctlKey(key)
+	if normalized == "" {
+		return fmt.Errorf("invalid sysctl key %q: empty key", key)
+	}
+	for _, component := range strings.Split(normalized, "/") {
+		if !sysctlKeyComponentRegex.MatchString(component) {
+			return fmt.Errorf("invalid sysctl key %q", key)
+		}
+	}
+	return nil
+}
+
+// sysctlInteger returns the integer for values decoded from TOML (int64)
+// or JSON (float64).
+func sysctlInteger(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// formatSysctlValue returns the value as it is written to sysctl.d.
+func formatSysctlValue(value any) (string, error) {
+	if i, ok := sysctlInteger(value); ok {
+		return strconv.FormatInt(i, 10), nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("empty value")
+		}
+		if strings.ContainsAny(v, "\n\r") {
+			return "", fmt.Errorf("value %q contains a line break", v)
+		}
+		return v, nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case []any:
+		if len(v) == 0 {
+			return "", fmt.Errorf("empty list")
+		}
+		fields := make([]string, 0, len(v))
+		for _, item := range v {
+			i, ok := sysctlInteger(item)
+			if !ok {
+				return "", fmt.Errorf("list items must be integers, got %v", item)
+			}
+			fields = append(fields, strconv.FormatInt(i, 10))
+		}
+		return strings.Join(fields, " "), nil
+	case []int64:
+		return formatSysctlValue(anySlice(v))
+	case []int:
+		return formatSysctlValue(anySlice(v))
+	case map[string]any:
+		return "", fmt.Errorf("nested tables are not supported, quote the full key, e.g. \"net.ipv4.ip_forward\"")
+	}
+	return "", fmt.Errorf("unsupported value type %T", value)
+}
+
+func anySlice[T any](s []T) []any {
+	result := make([]any, 0, len(s))
+	for _, item := range s {
+		result = append(result, item)
+	}
+	return result
+}
+
+// keys returns the keys in a stable order
+func (s SysctlCustomization) keys() []string {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Validate checks that all keys and values are valid and that no parameter
+// is set more than once using different spellings of its key.
+func (s SysctlCustomization) Validate() error {
+	seen := make(map[string]string)
+
+	var errs []error
+	for _, key := range s.keys() {
+		if err := validateSysctlKey(key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := formatSysctlValue(s[key]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for sysctl key %q: %w", key, err))
+		}
+
+		normalized := normalizeSysctlKey(key)
+		if prev, ok := seen[normalized]; ok {
+			errs = append(errs, fmt.Errorf("duplicate sysctl key %q (also set as %q)", key, prev))
+		}
+		seen[normalized] = key
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid sysctl customizations:\n%w", err)
+	}
+	return nil
+}
+
+// CheckOpenSCAP is an optional consistency check that returns an error for
+// every parameter whose value conflicts with the value expected by the
+// OpenSCAP profile (currently the CIS and STIG profiles of the SCAP Security
+// Guide, other profiles are not checked). Rules unselected in the tailoring of
+// the OpenSCAP customization are not checked either.
+func (s SysctlCustomization) CheckOpenSCAP(oscap *OpenSCAPCustomization) error {
+	if oscap == nil {
+		return nil
+	}
+	expectations, ok := openSCAPSysctlExpectations[strings.TrimPrefix(oscap.ProfileID, openSCAPProfilePrefix)]
+	if !ok {
+		return nil
+	}
+
+	var unselected []string
+	if oscap.Tailoring != nil {
+		unselected = oscap.Tailoring.Unselected
+	}
+
+	var errs []error
+	for _, key := range s.keys() {
+		normalized := normalizeSysctlKey(key)
+		expected, ok := expectations[normalized]
+		if !ok {
+			continue
+		}
+
+		rule := strings.ReplaceAll(normalized, "/", "_")
+		if slices.Contains(unselected, rule) || slices.Contains(unselected, "sysctl_"+rule) || slices.Contains(unselected, openSCAPSysctlRulePrefix+rule) {
+			continue
+		}
+
+		value, err := formatSysctlValue(s[key])
+		if err != nil {
+			// reported by Validate
+			continue
+		}
+		if value != expected {
+			errs = append(errs, fmt.Errorf("sysctl %q is set to %q but the OpenSCAP profile %q expects %q", key, value, oscap.ProfileID, expected))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("sysctl customizations conflict with OpenSCAP:\n%w", err)
+	}
+	return nil
+}
+
+// Conf renders the settings in sysctl.d(5) format, sorted by key.
+func (s SysctlCustomization) Conf() string {
+	var b strings.Builder
+	for _, key := range s.keys() {
+		value, _ := formatSysctlValue(s[key])
+		fmt.Fprintf(&b, "%s = %s\n", key, value)
+	}
+	return b.String()
+}
+
+// ToFsNodeFile validates the settings and returns them as a single sysctl.d
+// file.
+func (s SysctlCustomization) ToFsNodeFile() (*fsnode.File, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return fsnode.NewFile(SysctlConfPath, nil, nil, nil, []byte(s.Conf()))
+}
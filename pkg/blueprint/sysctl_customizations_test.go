@@ -0,0 +1,216 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSysctlKey(t *testing.T) {
+	assert.Equal(t, "net/ipv4/ip_forward", normalizeSysctlKey("net.ipv4.ip_forward"))
+	assert.Equal(t, "net/ipv4/ip_forward", normalizeSysctlKey("net/ipv4/ip_forward"))
+	assert.Equal(t, "net/ipv4/ip_forward", normalizeSysctlKey("-net.ipv4.ip_forward"))
+	assert.Equal(t, "net/ipv4/conf/eth0.100/forwarding", normalizeSysctlKey("net/ipv4/conf/eth0.100/forwarding"))
+	assert.Equal(t, "vm/swappiness", normalizeSysctlKey("vm.swappiness"))
+}
+
+func TestSysctlCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		sysctl SysctlCustomization
+		err    string
+	}{
+		"nil": {},
+		"happy": {
+			sysctl: SysctlCustomization{
+				"net.ipv4.ip_forward":                1,
+				"net/ipv4/conf/eth0.100/forwarding":  int64(0),
+				"-net.ipv4.conf.*.rp_filter":         float64(2),
+				"kernel.core_pattern":                "|/usr/lib/systemd/systemd-coredump %P %u %g",
+				"kernel.sysrq":                       false,
+				"net.ipv4.tcp_rmem":                  []any{int64(4096), int64(87380), int64(6291456)},
+				"net.ipv4.ip_local_port_range":       []int{32768, 60999},
+				"net.ipv4.conf.default.log_martians": true,
+			},
+		},
+		"bad-keys": {
+			sysctl: SysctlCustomization{
+				"":              1,
+				"net..ipv4":     1,
+				"net.ipv4 .foo": 1,
+			},
+			err: "invalid sysctl customizations:\n" +
+				`invalid sysctl key "": empty key` + "\n" +
+				`invalid sysctl key "net..ipv4"` + "\n" +
+				`invalid sysctl key "net.ipv4 .foo"`,
+		},
+		"bad-values": {
+			sysctl: SysctlCustomization{
+				"a.empty":    "",
+				"b.newline":  "1\nkernel.sysrq = 1",
+				"c.float":    1.5,
+				"d.list":     []any{int64(1), "two"},
+				"e.table":    map[string]any{"x": 1},
+				"f.emptylst": []any{},
+			},
+			err: "invalid sysctl customizations:\n" +
+				`invalid value for sysctl key "a.empty": empty value` + "\n" +
+				`invalid value for sysctl key "b.newline": value "1\nkernel.sysrq = 1" contains a line break` + "\n" +
+				`invalid value for sysctl key "c.float": unsupported value type float64` + "\n" +
+				`invalid value for sysctl key "d.list": list items must be integers, got two` + "\n" +
+				`invalid value for sysctl key "e.table": nested tables are not supported, quote the full key, e.g. "net.ipv4.ip_forward"` + "\n" +
+				`invalid value for sysctl key "f.emptylst": empty list`,
+		},
+		"duplicates": {
+			sysctl: SysctlCustomization{
+				"net.ipv4.ip_forward":  1,
+				"net/ipv4/ip_forward":  1,
+				"-vm.swappiness":       10,
+				"vm.swappiness":        10,
+				"net.ipv4.conf.all.rp": 1,
+			},
+			err: "invalid sysctl customizations:\n" +
+				`duplicate sysctl key "net/ipv4/ip_forward" (also set as "net.ipv4.ip_forward")` + "\n" +
+				`duplicate sysctl key "vm.swappiness" (also set as "-vm.swappiness")`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.sysctl.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSysctlCustomizationCheckOpenSCAP(t *testing.T) {
+	sysctl := SysctlCustomization{
+		"net.ipv4.ip_forward":       1,
+		"kernel/randomize_va_space": 2,
+		"kernel.kptr_restrict":      "0",
+		"vm.swappiness":             10,
+	}
+
+	assert.NoError(t, sysctl.CheckOpenSCAP(nil))
+
+	oscap := &OpenSCAPCustomization{ProfileID: "xccdf_org.ssgproject.content_profile_cis"}
+	assert.EqualError(t, sysctl.CheckOpenSCAP(oscap), "sysctl customizations conflict with OpenSCAP:\n"+
+		`sysctl "kernel.kptr_restrict" is set to "0" but the OpenSCAP profile "xccdf_org.ssgproject.content_profile_cis" expects "1"`+"\n"+
+		`sysctl "net.ipv4.ip_forward" is set to "1" but the OpenSCAP profile "xccdf_org.ssgproject.content_profile_cis" expects "0"`)
+
+	oscap.Tailoring = &OpenSCAPTailoringCustomizations{
+		Unselected: []string{"sysctl_kernel_kptr_restrict", "xccdf_org.ssgproject.content_rule_sysctl_net_ipv4_ip_forward"},
+	}
+	assert.NoError(t, sysctl.CheckOpenSCAP(oscap))
+
+	// profiles without known expectations are not checked
+	assert.NoError(t, sysctl.CheckOpenSCAP(&OpenSCAPCustomization{ProfileID: "xccdf_org.ssgproject.content_profile_hipaa"}))
+	assert.NoError(t, sysctl.CheckOpenSCAP(&OpenSCAPCustomization{ProfileID: "custom"}))
+	assert.Error(t, sysctl.CheckOpenSCAP(&OpenSCAPCustomization{ProfileID: "xccdf_org.ssgproject.content_profile_stig"}))
+}
+
+func TestSysctlCustomizationToFsNodeFile(t *testing.T) {
+	sysctl := SysctlCustomization{
+		"vm.swappiness":                     10,
+		"net.ipv4.tcp_rmem":                 []any{4096, 87380, 6291456},
+		"-net.ipv4.conf.*.rp_filter":        2,
+		"kernel.sysrq":                      true,
+		"net/ipv4/conf/eth0.100/forwarding": "1",
+	}
+
+	file, err := sysctl.ToFsNodeFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/sysctl.d/90-blueprint.conf", file.Path())
+	assert.Equal(t, `-net.ipv4.conf.*.rp_filter = 2
+kernel.sysrq = 1
+net.ipv4.tcp_rmem = 4096 87380 6291456
+net/ipv4/conf/eth0.100/forwarding = 1
+vm.swappiness = 10
+`, string(file.Data()))
+
+	file, err = SysctlCustomization(nil).ToFsNodeFile()
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+
+	_, err = SysctlCustomization{"vm.swappiness": 0.5}.ToFsNodeFile()
+	assert.Error(t, err)
+}
+
+func TestGetSysctl(t *testing.T) {
+	tomlData := `
+[customizations.sysctl]
+"vm.swappiness" = 10
+"net.ipv4.tcp_rmem" = [4096, 87380, 6291456]
+"kernel.sysrq" = false
+"net.ipv4.ip_forward" = 1
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	sysctl, err := bp.Customizations.GetSysctl()
+	require.NoError(t, err)
+	assert.Equal(t, `kernel.sysrq = 0
+net.ipv4.ip_forward = 1
+net.ipv4.tcp_rmem = 4096 87380 6291456
+vm.swappiness = 10
+`, sysctl.Conf())
+
+	// the same blueprint as JSON renders the same file
+	data, err := json.Marshal(bp)
+	require.NoError(t, err)
+	var jsonBP Blueprint
+	require.NoError(t, json.Unmarshal(data, &jsonBP))
+	jsonSysctl, err := jsonBP.Customizations.GetSysctl()
+	require.NoError(t, err)
+	assert.Equal(t, sysctl.Conf(), jsonSysctl.Conf())
+
+	// conflicts with the OpenSCAP profile are an opt-in check and do not
+	// make GetSysctl fail
+	bp.Customizations.OpenSCAP = &OpenSCAPCustomization{ProfileID: "xccdf_org.ssgproject.content_profile_stig"}
+	sysctl, err = bp.Customizations.GetSysctl()
+	assert.NoError(t, err)
+	assert.Error(t, sysctl.CheckOpenSCAP(bp.Customizations.OpenSCAP))
+
+	bp.Customizations.OpenSCAP = nil
+	bp.Customizations.Sysctl["vm/swappiness"] = 10
+	_, err = bp.Customizations.GetSysctl()
+	assert.Error(t, err)
+
+	assert.Error(t, bp.Customizations.CheckAllowed())
+	assert.NoError(t, bp.Customizations.CheckAllowed("Sysctl"))
+
+	// unquoted dotted keys are TOML tables, they are flattened
+	var dottedBP Blueprint
+	_, err = toml.Decode(`
+[customizations.sysctl]
+net.ipv4.ip_forward = 1
+net.ipv4.tcp_rmem = [4096, 87380, 6291456]
+vm.swappiness = 10
+"kernel.sysrq" = false
+`, &dottedBP)
+	require.NoError(t, err)
+	dottedSysctl, err := dottedBP.Customizations.GetSysctl()
+	require.NoError(t, err)
+	assert.Equal(t, `kernel.sysrq = 0
+net.ipv4.ip_forward = 1
+net.ipv4.tcp_rmem = 4096 87380 6291456
+vm.swappiness = 10
+`, dottedSysctl.Conf())
+
+	// nested objects in JSON are reported with a hint
+	require.NoError(t, json.Unmarshal([]byte(`{"customizations": {"sysctl": {"net": {"ipv4": {"ip_forward": 1}}}}}`), &jsonBP))
+	_, err = jsonBP.Customizations.GetSysctl()
+	assert.EqualError(t, err, "invalid sysctl customizations:\n"+`invalid value for sysctl key "net": nested tables are not supported, quote the full key, e.g. "net.ipv4.ip_forward"`)
+
+	var nilCustomizations *Customizations
+	sysctl, err = nilCustomizations.GetSysctl()
+	assert.NoError(t, err)
+	assert.Nil(t, sysctl)
+}
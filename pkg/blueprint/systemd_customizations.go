@@ -0,0 +1,454 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+type ServicesCustomization struct {
+	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
+	Masked   []string `json:"masked,omitempty" toml:"masked,omitempty"`
+}
+
+// SystemdCustomization defines custom systemd units, drop-ins, timers and
+// presets. All of them are written to the image as files, see
+// [SystemdCustomization.ToFsNodeFiles].
+type SystemdCustomization struct {
+	Units   []SystemdUnitCustomization   `json:"units,omitempty" toml:"units,omitempty"`
+	Dropins []SystemdDropinCustomization `json:"dropins,omitempty" toml:"dropins,omitempty"`
+	Timers  []SystemdTimerCustomization  `json:"timers,omitempty" toml:"timers,omitempty"`
+	Presets []SystemdPresetCustomization `json:"presets,omitempty" toml:"presets,omitempty"`
+}
+
+// SystemdUnitCustomization is a unit file written to /etc/systemd/system.
+type SystemdUnitCustomization struct {
+	// Full unit name including the type suffix, e.g. "foo.service" or
+	// "foo@.service" for templates (required).
+	Name string `json:"name" toml:"name"`
+
+	// Contents of the unit file (required)
+	Contents string `json:"contents" toml:"contents"`
+
+	// Enable the unit
+	Enable bool `json:"enable,omitempty" toml:"enable,omitempty"`
+}
+
+// SystemdDropinCustomization is a drop-in configuration file for a unit,
+// written to /etc/systemd/system/<unit>.d/<name>.
+type SystemdDropinCustomization struct {
+	// Full name of the unit the drop-in applies to, e.g. "sshd.service"
+	// (required).
+	Unit string `json:"unit" toml:"unit"`
+
+	// File name of the drop-in, must end in ".conf" (required).
+	Name string `json:"name" toml:"name"`
+
+	// Contents of the drop-in file (required)
+	Contents string `json:"contents" toml:"contents"`
+}
+
+// SystemdTimerCustomization is a timer unit generated from its settings.
+// At least one of OnCalendar, OnBootSec or OnUnitActiveSec is required.
+type SystemdTimerCustomization struct {
+	// Full unit name of the timer, e.g. "backup.timer" (required).
+	Name string `json:"name" toml:"name"`
+
+	Description string `json:"description,omitempty" toml:"description,omitempty"`
+
+	// Unit to activate when the timer elapses. Defaults to the unit with the
+	// same name as the timer and the ".service" suffix.
+	Unit string `json:"unit,omitempty" toml:"unit,omitempty"`
+
+	OnCalendar      []string `json:"on_calendar,omitempty" toml:"on_calendar,omitempty"`
+	OnBootSec       string   `json:"on_boot_sec,omitempty" toml:"on_boot_sec,omitempty"`
+	OnUnitActiveSec string   `json:"on_unit_active_sec,omitempty" toml:"on_unit_active_sec,omitempty"`
+	Persistent      *bool    `json:"persistent,omitempty" toml:"persistent,omitempty"`
+
+	// Enable the timer
+	Enable bool `json:"enable,omitempty" toml:"enable,omitempty"`
+}
+
+// SystemdPresetCustomization is a single line in the preset file generated
+// by the customization, see systemd.preset(5).
+type SystemdPresetCustomization struct {
+	// Full unit name or "*" to match all units (required)
+	Unit string `json:"unit" toml:"unit"`
+
+	// "enable" or "disable" (required)
+	Action string `json:"action" toml:"action"`
+}
+
+const (
+	systemdSystemDir = "/etc/systemd/system"
+
+	// Preset files are applied in lexicographic order and the first match
+	// wins, so this needs to sort before the distribution defaults.
+	SystemdPresetFilePath = "/etc/systemd/system-preset/10-blueprint.preset"
+
+	// systemd limits unit names to 255 characters
+	systemdUnitNameMax = 255
+)
+
+var validSystemdUnitTypes = []string{
+	"service",
+	"socket",
+	"device",
+	"mount",
+	"automount",
+	"swap",
+	"target",
+	"path",
+	"timer",
+	"slice",
+	"scope",
+}
+
+// systemdUnitName is the parsed form of a unit name, see systemd.unit(5).
+type systemdUnitName struct {
+	// Part before the "@" for templates and instances, the full name
+	// without type otherwise.
+	Prefix string
+	// Instance name, empty for templates and plain units
+	Instance string
+	// Unit type without the leading dot, e.g. "service"
+	Type string
+	// Template is true for template units ("getty@.service")
+	Template bool
+}
+
+func isValidUnitNameChar(r rune, allowAt bool) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune(":-_.\\", r):
+		return true
+	case r == '@':
+		return allowAt
+	default:
+		return false
+	}
+}
+
+// parseUnitName parses and validates a full systemd unit name including the
+// type suffix. It accepts plain units ("sshd.service"), templates
+// ("getty@.service") and instances ("getty@tty1.service").
+func parseUnitName(name string) (*systemdUnitName, error) {
+	if name == "" {
+		return nil, fmt.Errorf("unit name is empty")
+	}
+	if len(name) > systemdUnitNameMax {
+		return nil, fmt.Errorf("unit name %q is longer than %d characters", name, systemdUnitNameMax)
+	}
+
+	dot := strings.LastIndex(name, ".")
+	if dot == -1 {
+		return nil, fmt.Errorf("unit name %q has no type suffix (e.g. \".service\")", name)
+	}
+	unit := systemdUnitName{Type: name[dot+1:]}
+	if !slices.Contains(validSystemdUnitTypes, unit.Type) {
+		return nil, fmt.Errorf("unit name %q has unknown type %q", name, unit.Type)
+	}
+
+	base := name[:dot]
+	prefix, instance, isTemplate := strings.Cut(base, "@")
+	if prefix == "" {
+		return nil, fmt.Errorf("unit name %q has an empty prefix", name)
+	}
+	for _, r := range prefix {
+		if !isValidUnitNameChar(r, false) {
+			return nil, fmt.Errorf("unit name %q contains invalid character %q", name, r)
+		}
+	}
+	for _, r := range instance {
+		if !isValidUnitNameChar(r, true) {
+			return nil, fmt.Errorf("unit name %q contains invalid character %q", name, r)
+		}
+	}
+
+	unit.Prefix = prefix
+	unit.Instance = instance
+	unit.Template = isTemplate && instance == ""
+	return &unit, nil
+}
+
+// normalizeServiceName returns the full unit name for names as used in
+// ServicesCustomization, which, like systemctl, default to the ".service"
+// type when no valid type suffix is given.
+func normalizeServiceName(name string) string {
+	if dot := strings.LastIndex(name, "."); dot != -1 && slices.Contains(validSystemdUnitTypes, name[dot+1:]) {
+		return name
+	}
+	return name + ".service"
+}
+
+// Validate checks that all unit names are valid and that no unit is listed
+// in more than one of enabled, disabled and masked.
+func (s *ServicesCustomization) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	var errs []error
+	states := make(map[string]string)
+	check := func(state string, units []string) {
+		for _, name := range units {
+			unit := normalizeServiceName(name)
+			if _, err := parseUnitName(unit); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if prev, ok := states[unit]; ok && prev != state {
+				errs = append(errs, fmt.Errorf("unit %q is both %s and %s", unit, prev, state))
+				continue
+			}
+			states[unit] = state
+		}
+	}
+	check("enabled", s.Enabled)
+	check("disabled", s.Disabled)
+	check("masked", s.Masked)
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid services customizations:\n%w", err)
+	}
+	return nil
+}
+
+// Validate checks that all units, drop-ins, timers and presets are valid and
+// do not conflict with each other.
+func (s *SystemdCustomization) Validate() error {
+	if s == nil {
+		return nil
+	}
+
+	var errs []error
+	unitNames := make(map[string]bool)
+	addUnitName := func(name string) {
+		if unitNames[name] {
+			errs = append(errs, fmt.Errorf("duplicate systemd unit %q", name))
+		}
+		unitNames[name] = true
+	}
+
+	for _, u := range s.Units {
+		if err := u.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addUnitName(u.Name)
+	}
+
+	for _, t := range s.Timers {
+		if err := t.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addUnitName(t.Name)
+	}
+
+	dropinPaths := make(map[string]bool)
+	for _, d := range s.Dropins {
+		if err := d.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if dropinPaths[d.path()] {
+			errs = append(errs, fmt.Errorf("duplicate drop-in %q for unit %q", d.Name, d.Unit))
+		}
+		dropinPaths[d.path()] = true
+	}
+
+	presets := make(map[string]string)
+	for _, p := range s.Presets {
+		if err := p.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if prev, ok := presets[p.Unit]; ok && prev != p.Action {
+			errs = append(errs, fmt.Errorf("conflicting presets for unit %q: %s and %s", p.Unit, prev, p.Action))
+		}
+		presets[p.Unit] = p.Action
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid systemd customizations:\n%w", err)
+	}
+	return nil
+}
+
+func (u *SystemdUnitCustomization) validate() error {
+	unit, err := parseUnitName(u.Name)
+	if err != nil {
+		return err
+	}
+	if unit.Instance != "" {
+		return fmt.Errorf("unit %q is an instance, only plain and template units can be defined", u.Name)
+	}
+	if u.Contents == "" {
+		return fmt.Errorf("unit %q has no contents", u.Name)
+	}
+	return nil
+}
+
+func (d *SystemdDropinCustomization) validate() error {
+	if _, err := parseUnitName(d.Unit); err != nil {
+		return fmt.Errorf("invalid drop-in unit: %w", err)
+	}
+	if !strings.HasSuffix(d.Name, ".conf") || len(d.Name) == len(".conf") || strings.Contains(d.Name, "/") {
+		return fmt.Errorf("invalid drop-in name %q for unit %q: must be a file name ending in \".conf\"", d.Name, d.Unit)
+	}
+	if d.Contents == "" {
+		return fmt.Errorf("drop-in %q for unit %q has no contents", d.Name, d.Unit)
+	}
+	return nil
+}
+
+func (d *SystemdDropinCustomization) path() string {
+	return path.Join(systemdSystemDir, d.Unit+".d", d.Name)
+}
+
+func (t *SystemdTimerCustomization) validate() error {
+	timer, err := parseUnitName(t.Name)
+	if err != nil {
+		return err
+	}
+	if timer.Type != "timer" {
+		return fmt.Errorf("timer %q must have the \".timer\" suffix", t.Name)
+	}
+	if timer.Instance != "" {
+		return fmt.Errorf("timer %q is an instance, only plain and template units can be defined", t.Name)
+	}
+	if t.Unit != "" {
+		unit, err := parseUnitName(t.Unit)
+		if err != nil {
+			return fmt.Errorf("invalid unit for timer %q: %w", t.Name, err)
+		}
+		if unit.Type == "timer" {
+			return fmt.Errorf("timer %q cannot activate another timer %q", t.Name, t.Unit)
+		}
+	}
+	if len(t.OnCalendar) == 0 && t.OnBootSec == "" && t.OnUnitActiveSec == "" {
+		return fmt.Errorf("timer %q requires at least one of on_calendar, on_boot_sec or on_unit_active_sec", t.Name)
+	}
+	return nil
+}
+
+func (p *SystemdPresetCustomization) validate() error {
+	if p.Unit != "*" {
+		if _, err := parseUnitName(p.Unit); err != nil {
+			return fmt.Errorf("invalid preset unit: %w", err)
+		}
+	}
+	switch p.Action {
+	case "enable", "disable":
+	default:
+		return fmt.Errorf("invalid preset action %q for unit %q (valid: enable, disable)", p.Action, p.Unit)
+	}
+	return nil
+}
+
+// render returns the contents of the timer unit file.
+func (t *SystemdTimerCustomization) render() string {
+	var b strings.Builder
+	if t.Description != "" {
+		fmt.Fprintf(&b, "[Unit]\nDescription=%s\n\n", t.Description)
+	}
+
+	b.WriteString("[Timer]\n")
+	for _, c := range t.OnCalendar {
+		fmt.Fprintf(&b, "OnCalendar=%s\n", c)
+	}
+	if t.OnBootSec != "" {
+		fmt.Fprintf(&b, "OnBootSec=%s\n", t.OnBootSec)
+	}
+	if t.OnUnitActiveSec != "" {
+		fmt.Fprintf(&b, "OnUnitActiveSec=%s\n", t.OnUnitActiveSec)
+	}
+	if t.Persistent != nil {
+		fmt.Fprintf(&b, "Persistent=%t\n", *t.Persistent)
+	}
+	if t.Unit != "" {
+		fmt.Fprintf(&b, "Unit=%s\n", t.Unit)
+	}
+
+	b.WriteString("\n[Install]\nWantedBy=timers.target\n")
+	return b.String()
+}
+
+// EnabledUnits returns the names of all units and timers of the
+// customization that should be enabled.
+func (s *SystemdCustomization) EnabledUnits() []string {
+	if s == nil {
+		return nil
+	}
+
+	var units []string
+	for _, u := range s.Units {
+		if u.Enable {
+			units = append(units, u.Name)
+		}
+	}
+	for _, t := range s.Timers {
+		if t.Enable {
+			units = append(units, t.Name)
+		}
+	}
+	return units
+}
+
+// ToFsNodeFiles validates the customization and returns the unit, drop-in
+// and preset files it defines.
+func (s *SystemdCustomization) ToFsNodeFiles() ([]*fsnode.File, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	var files []*fsnode.File
+	addFile := func(path string, data string) error {
+		file, err := fsnode.NewFile(path, nil, nil, nil, []byte(data))
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	}
+
+	for _, u := range s.Units {
+		if err := addFile(path.Join(systemdSystemDir, u.Name), u.Contents); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range s.Timers {
+		if err := addFile(path.Join(systemdSystemDir, t.Name), t.render()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range s.Dropins {
+		if err := addFile(d.path(), d.Contents); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.Presets) > 0 {
+		var b strings.Builder
+		for _, p := range s.Presets {
+			fmt.Fprintf(&b, "%s %s\n", p.Action, p.Unit)
+		}
+		if err := addFile(SystemdPresetFilePath, b.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,376 @@
+package blueprint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestParseUnitName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected *systemdUnitName
+		err      string
+	}{
+		{
+			name:     "sshd.service",
+			expected: &systemdUnitName{Prefix: "sshd", Type: "service"},
+		},
+		{
+			name:     "getty@.service",
+			expected: &systemdUnitName{Prefix: "getty", Type: "service", Template: true},
+		},
+		{
+			name:     "getty@tty1.service",
+			expected: &systemdUnitName{Prefix: "getty", Instance: "tty1", Type: "service"},
+		},
+		{
+			name:     "systemd-fsck@dev-disk-by\\x2duuid-1234.service",
+			expected: &systemdUnitName{Prefix: "systemd-fsck", Instance: "dev-disk-by\\x2duuid-1234", Type: "service"},
+		},
+		{
+			name:     "var-lib.mount",
+			expected: &systemdUnitName{Prefix: "var-lib", Type: "mount"},
+		},
+		{
+			name: "",
+			err:  "unit name is empty",
+		},
+		{
+			name: "sshd",
+			err:  `unit name "sshd" has no type suffix (e.g. ".service")`,
+		},
+		{
+			name: "sshd.daemon",
+			err:  `unit name "sshd.daemon" has unknown type "daemon"`,
+		},
+		{
+			name: "@tty1.service",
+			err:  `unit name "@tty1.service" has an empty prefix`,
+		},
+		{
+			name: "my unit.service",
+			err:  `unit name "my unit.service" contains invalid character ' '`,
+		},
+		{
+			name: strings.Repeat("a", 256) + ".service",
+			err:  `unit name "` + strings.Repeat("a", 256) + `.service" is longer than 255 characters`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit, err := parseUnitName(tc.name)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, unit)
+		})
+	}
+}
+
+func TestServicesCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		services *ServicesCustomization
+		err      string
+	}{
+		"nil": {},
+		"happy": {
+			services: &ServicesCustomization{
+				Enabled:  []string{"cockpit.socket", "getty@tty1.service", "sshd"},
+				Disabled: []string{"kdump"},
+				Masked:   []string{"firewalld.service"},
+			},
+		},
+		"invalid-name": {
+			services: &ServicesCustomization{
+				Enabled: []string{"not valid"},
+			},
+			err: "invalid services customizations:\n" +
+				`unit name "not valid.service" contains invalid character ' '`,
+		},
+		"enabled-and-masked": {
+			services: &ServicesCustomization{
+				Enabled: []string{"sshd"},
+				Masked:  []string{"sshd.service"},
+			},
+			err: "invalid services customizations:\n" +
+				`unit "sshd.service" is both enabled and masked`,
+		},
+		"disabled-and-masked": {
+			services: &ServicesCustomization{
+				Disabled: []string{"kdump.service"},
+				Masked:   []string{"kdump"},
+			},
+			err: "invalid services customizations:\n" +
+				`unit "kdump.service" is both disabled and masked`,
+		},
+		"duplicate-in-same-list": {
+			services: &ServicesCustomization{
+				Enabled: []string{"sshd", "sshd.service"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.services.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSystemdCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		systemd *SystemdCustomization
+		err     string
+	}{
+		"nil": {},
+		"happy": {
+			systemd: &SystemdCustomization{
+				Units: []SystemdUnitCustomization{
+					{Name: "backup.service", Contents: "[Service]\nExecStart=/usr/bin/backup\n"},
+					{Name: "worker@.service", Contents: "[Service]\nExecStart=/usr/bin/worker %i\n"},
+				},
+				Dropins: []SystemdDropinCustomization{
+					{Unit: "sshd.service", Name: "override.conf", Contents: "[Service]\nRestart=always\n"},
+					{Unit: "getty@tty1.service", Name: "autologin.conf", Contents: "[Service]\nExecStart=\n"},
+				},
+				Timers: []SystemdTimerCustomization{
+					{Name: "backup.timer", OnCalendar: []string{"daily"}},
+				},
+				Presets: []SystemdPresetCustomization{
+					{Unit: "backup.timer", Action: "enable"},
+					{Unit: "*", Action: "disable"},
+				},
+			},
+		},
+		"unit-instance": {
+			systemd: &SystemdCustomization{
+				Units: []SystemdUnitCustomization{{Name: "worker@1.service", Contents: "[Service]"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`unit "worker@1.service" is an instance, only plain and template units can be defined`,
+		},
+		"unit-no-contents": {
+			systemd: &SystemdCustomization{
+				Units: []SystemdUnitCustomization{{Name: "foo.service"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`unit "foo.service" has no contents`,
+		},
+		"unit-duplicate": {
+			systemd: &SystemdCustomization{
+				Units: []SystemdUnitCustomization{
+					{Name: "foo.timer", Contents: "[Timer]"},
+				},
+				Timers: []SystemdTimerCustomization{
+					{Name: "foo.timer", OnBootSec: "5min"},
+				},
+			},
+			err: "invalid systemd customizations:\n" +
+				`duplicate systemd unit "foo.timer"`,
+		},
+		"dropin-bad-name": {
+			systemd: &SystemdCustomization{
+				Dropins: []SystemdDropinCustomization{{Unit: "sshd.service", Name: "override", Contents: "[Service]"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`invalid drop-in name "override" for unit "sshd.service": must be a file name ending in ".conf"`,
+		},
+		"dropin-bad-unit": {
+			systemd: &SystemdCustomization{
+				Dropins: []SystemdDropinCustomization{{Unit: "sshd", Name: "override.conf", Contents: "[Service]"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`invalid drop-in unit: unit name "sshd" has no type suffix (e.g. ".service")`,
+		},
+		"dropin-duplicate": {
+			systemd: &SystemdCustomization{
+				Dropins: []SystemdDropinCustomization{
+					{Unit: "sshd.service", Name: "override.conf", Contents: "a"},
+					{Unit: "sshd.service", Name: "override.conf", Contents: "b"},
+				},
+			},
+			err: "invalid systemd customizations:\n" +
+				`duplicate drop-in "override.conf" for unit "sshd.service"`,
+		},
+		"timer-wrong-suffix": {
+			systemd: &SystemdCustomization{
+				Timers: []SystemdTimerCustomization{{Name: "backup.service", OnBootSec: "1h"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`timer "backup.service" must have the ".timer" suffix`,
+		},
+		"timer-no-trigger": {
+			systemd: &SystemdCustomization{
+				Timers: []SystemdTimerCustomization{{Name: "backup.timer"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`timer "backup.timer" requires at least one of on_calendar, on_boot_sec or on_unit_active_sec`,
+		},
+		"timer-activates-timer": {
+			systemd: &SystemdCustomization{
+				Timers: []SystemdTimerCustomization{{Name: "a.timer", Unit: "b.timer", OnBootSec: "1h"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`timer "a.timer" cannot activate another timer "b.timer"`,
+		},
+		"preset-bad-action": {
+			systemd: &SystemdCustomization{
+				Presets: []SystemdPresetCustomization{{Unit: "sshd.service", Action: "mask"}},
+			},
+			err: "invalid systemd customizations:\n" +
+				`invalid preset action "mask" for unit "sshd.service" (valid: enable, disable)`,
+		},
+		"preset-conflict": {
+			systemd: &SystemdCustomization{
+				Presets: []SystemdPresetCustomization{
+					{Unit: "sshd.service", Action: "enable"},
+					{Unit: "sshd.service", Action: "disable"},
+				},
+			},
+			err: "invalid systemd customizations:\n" +
+				`conflicting presets for unit "sshd.service": enable and disable`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.systemd.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSystemdCustomizationToFsNodeFiles(t *testing.T) {
+	systemd := &SystemdCustomization{
+		Units: []SystemdUnitCustomization{
+			{Name: "backup.service", Contents: "[Service]\nExecStart=/usr/bin/backup\n"},
+		},
+		Dropins: []SystemdDropinCustomization{
+			{Unit: "sshd.service", Name: "override.conf", Contents: "[Service]\nRestart=always\n"},
+		},
+		Timers: []SystemdTimerCustomization{
+			{
+				Name:        "backup.timer",
+				Description: "Daily backup",
+				OnCalendar:  []string{"Mon..Fri 02:00", "Sat 04:00"},
+				OnBootSec:   "15min",
+				Persistent:  common.ToPtr(true),
+				Unit:        "backup.service",
+				Enable:      true,
+			},
+		},
+		Presets: []SystemdPresetCustomization{
+			{Unit: "backup.timer", Action: "enable"},
+			{Unit: "*", Action: "disable"},
+		},
+	}
+
+	files, err := systemd.ToFsNodeFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+
+	assert.Equal(t, "/etc/systemd/system/backup.service", files[0].Path())
+	assert.Equal(t, "[Service]\nExecStart=/usr/bin/backup\n", string(files[0].Data()))
+
+	assert.Equal(t, "/etc/systemd/system/backup.timer", files[1].Path())
+	assert.Equal(t, `[Unit]
+Description=Daily backup
+
+[Timer]
+OnCalendar=Mon..Fri 02:00
+OnCalendar=Sat 04:00
+OnBootSec=15min
+Persistent=true
+Unit=backup.service
+
+[Install]
+WantedBy=timers.target
+`, string(files[1].Data()))
+
+	assert.Equal(t, "/etc/systemd/system/sshd.service.d/override.conf", files[2].Path())
+	assert.Equal(t, "[Service]\nRestart=always\n", string(files[2].Data()))
+
+	assert.Equal(t, "/etc/systemd/system-preset/10-blueprint.preset", files[3].Path())
+	assert.Equal(t, "enable backup.timer\ndisable *\n", string(files[3].Data()))
+
+	assert.Equal(t, []string{"backup.timer"}, systemd.EnabledUnits())
+
+	var nilSystemd *SystemdCustomization
+	files, err = nilSystemd.ToFsNodeFiles()
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+	assert.Nil(t, nilSystemd.EnabledUnits())
+
+	_, err = (&SystemdCustomization{Units: []SystemdUnitCustomization{{Name: "foo"}}}).ToFsNodeFiles()
+	assert.Error(t, err)
+}
+
+func TestGetSystemd(t *testing.T) {
+	tomlData := `
+[customizations.systemd]
+[[customizations.systemd.units]]
+name = "hello.service"
+contents = "[Service]\nExecStart=/usr/bin/echo hello\n"
+enable = true
+
+[[customizations.systemd.dropins]]
+unit = "sshd.service"
+name = "override.conf"
+contents = "[Service]\nRestart=always\n"
+
+[[customizations.systemd.timers]]
+name = "hello.timer"
+on_boot_sec = "1h"
+
+[[customizations.systemd.presets]]
+unit = "hello.timer"
+action = "enable"
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+
+	systemd, err := bp.Customizations.GetSystemd()
+	require.NoError(t, err)
+	assert.Equal(t, &SystemdCustomization{
+		Units: []SystemdUnitCustomization{
+			{Name: "hello.service", Contents: "[Service]\nExecStart=/usr/bin/echo hello\n", Enable: true},
+		},
+		Dropins: []SystemdDropinCustomization{
+			{Unit: "sshd.service", Name: "override.conf", Contents: "[Service]\nRestart=always\n"},
+		},
+		Timers: []SystemdTimerCustomization{
+			{Name: "hello.timer", OnBootSec: "1h"},
+		},
+		Presets: []SystemdPresetCustomization{
+			{Unit: "hello.timer", Action: "enable"},
+		},
+	}, systemd)
+
+	bp.Customizations.Systemd.Timers[0].OnBootSec = ""
+	_, err = bp.Customizations.GetSystemd()
+	assert.Error(t, err)
+
+	var nilCustomizations *Customizations
+	systemd, err = nilCustomizations.GetSystemd()
+	assert.NoError(t, err)
+	assert.Nil(t, systemd)
+}
@@ -0,0 +1,172 @@
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embed the timezone database so timezones can be validated on hosts
+	// without a zoneinfo database (e.g. minimal containers).
+	_ "time/tzdata"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+type TimezoneCustomization struct {
+	Timezone   *string  `json:"timezone,omitempty" toml:"timezone,omitempty"`
+	NTPServers []string `json:"ntpservers,omitempty" toml:"ntpservers,omitempty"`
+
+	// Time sources with chrony specific options. Unlike NTPServers these
+	// can be pools and carry per-source options.
+	NTPSources []NTPSourceCustomization `json:"ntp_sources,omitempty" toml:"ntp_sources,omitempty"`
+
+	// Step the system clock instead of slewing it when the offset is larger
+	// than the threshold, see chrony.conf(5).
+	MakeStep *ChronyMakeStepCustomization `json:"makestep,omitempty" toml:"makestep,omitempty"`
+}
+
+// NTPSourceCustomization is a single time source in chrony.conf.
+type NTPSourceCustomization struct {
+	// Hostname or IP address of the source (required)
+	Hostname string `json:"hostname" toml:"hostname"`
+
+	// Use the source as a pool of servers ("pool" directive) instead of a
+	// single server ("server" directive).
+	Pool bool `json:"pool,omitempty" toml:"pool,omitempty"`
+
+	// Send a burst of requests on startup to speed up the initial
+	// synchronisation.
+	IBurst bool `json:"iburst,omitempty" toml:"iburst,omitempty"`
+
+	// Prefer this source over sources without the option.
+	Prefer bool `json:"prefer,omitempty" toml:"prefer,omitempty"`
+}
+
+// ChronyMakeStepCustomization configures the chrony "makestep" directive.
+type ChronyMakeStepCustomization struct {
+	// Offset in seconds above which the clock is stepped (required)
+	Threshold float64 `json:"threshold" toml:"threshold"`
+
+	// Number of clock updates for which stepping is allowed, -1 for no
+	// limit (required)
+	Limit int `json:"limit" toml:"limit"`
+}
+
+// ChronyDropInPath is the location of the chrony configuration drop-in in the
+// image. The distribution's /etc/chrony.conf is kept and includes the
+// drop-in directory.
+const ChronyDropInPath = "/etc/chrony.d/blueprint.conf"
+
+func validateTimezone(tz string) error {
+	// time.LoadLocation treats "" as UTC and "Local" as the timezone of
+	// the build host, neither of which is what the user asked for.
+	if tz == "" || tz == "Local" {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("unknown timezone %q", tz)
+	}
+	return nil
+}
+
+func validateNTPServer(server string) error {
+	if net.ParseIP(server) != nil || isValidDNSName(server) {
+		return nil
+	}
+	return fmt.Errorf("invalid NTP server %q: must be a hostname or an IP address", server)
+}
+
+// Validate checks that the timezone exists in the zoneinfo database and
+// that all NTP servers and sources are valid hostnames or IP addresses.
+func (t *TimezoneCustomization) Validate() error {
+	if t == nil {
+		return nil
+	}
+
+	var errs []error
+	if t.Timezone != nil {
+		errs = append(errs, validateTimezone(*t.Timezone))
+	}
+
+	servers := make(map[string]bool)
+	addServer := func(server string) {
+		if err := validateNTPServer(server); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if servers[server] {
+			errs = append(errs, fmt.Errorf("duplicate NTP server %q", server))
+		}
+		servers[server] = true
+	}
+	for _, server := range t.NTPServers {
+		addServer(server)
+	}
+	for _, source := range t.NTPSources {
+		addServer(source.Hostname)
+	}
+
+	if t.MakeStep != nil {
+		if t.MakeStep.Threshold <= 0 {
+			errs = append(errs, fmt.Errorf("makestep threshold must be greater than 0, got %v", t.MakeStep.Threshold))
+		}
+		if t.MakeStep.Limit == 0 || t.MakeStep.Limit < -1 {
+			errs = append(errs, fmt.Errorf("makestep limit must be greater than 0 or -1 for no limit, got %d", t.MakeStep.Limit))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid timezone customizations:\n%w", err)
+	}
+	return nil
+}
+
+// ChronyConf renders the NTP settings as a chrony configuration drop-in.
+// Plain NTPServers are written as servers with the "iburst" option. Only the
+// customized settings are written, everything else is left to the
+// distribution's chrony.conf.
+func (t *TimezoneCustomization) ChronyConf() string {
+	if t == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, server := range t.NTPServers {
+		fmt.Fprintf(&b, "server %s iburst\n", server)
+	}
+	for _, source := range t.NTPSources {
+		directive := "server"
+		if source.Pool {
+			directive = "pool"
+		}
+		b.WriteString(directive + " " + source.Hostname)
+		if source.IBurst {
+			b.WriteString(" iburst")
+		}
+		if source.Prefer {
+			b.WriteString(" prefer")
+		}
+		b.WriteString("\n")
+	}
+	if t.MakeStep != nil {
+		fmt.Fprintf(&b, "makestep %s %d\n", strconv.FormatFloat(t.MakeStep.Threshold, 'f', -1, 64), t.MakeStep.Limit)
+	}
+
+	return b.String()
+}
+
+// ToChronyConfFile validates the customization and returns the rendered
+// chrony configuration as a drop-in file in /etc/chrony.d. It returns nil if
+// no NTP settings are customized.
+func (t *TimezoneCustomization) ToChronyConfFile() (*fsnode.File, error) {
+	if t == nil || (len(t.NTPServers) == 0 && len(t.NTPSources) == 0 && t.MakeStep == nil) {
+		return nil, nil
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return fsnode.NewFile(ChronyDropInPath, nil, nil, nil, []byte(t.ChronyConf()))
+}
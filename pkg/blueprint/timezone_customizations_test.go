@@ -0,0 +1,179 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/blueprint/internal/common"
+)
+
+func TestTimezoneCustomizationValidate(t *testing.T) {
+	testCases := map[string]struct {
+		timezone *TimezoneCustomization
+		err      string
+	}{
+		"nil": {},
+		"happy": {
+			timezone: &TimezoneCustomization{
+				Timezone:   common.ToPtr("Europe/Berlin"),
+				NTPServers: []string{"0.fedora.pool.ntp.org", "192.168.0.1", "fe80::1"},
+				NTPSources: []NTPSourceCustomization{
+					{Hostname: "time.example.com.", Pool: true, IBurst: true},
+				},
+				MakeStep: &ChronyMakeStepCustomization{Threshold: 0.5, Limit: -1},
+			},
+		},
+		"utc": {
+			timezone: &TimezoneCustomization{
+				Timezone: common.ToPtr("UTC"),
+			},
+		},
+		"typo": {
+			timezone: &TimezoneCustomization{
+				Timezone: common.ToPtr("Europe/Berln"),
+			},
+			err: "invalid timezone customizations:\n" +
+				`unknown timezone "Europe/Berln"`,
+		},
+		"local": {
+			timezone: &TimezoneCustomization{
+				Timezone: common.ToPtr("Local"),
+			},
+			err: "invalid timezone customizations:\n" +
+				`invalid timezone "Local"`,
+		},
+		"empty": {
+			timezone: &TimezoneCustomization{
+				Timezone: common.ToPtr(""),
+			},
+			err: "invalid timezone customizations:\n" +
+				`invalid timezone ""`,
+		},
+		"bad-ntp-server": {
+			timezone: &TimezoneCustomization{
+				NTPServers: []string{"ntp_server.example.com"},
+			},
+			err: "invalid timezone customizations:\n" +
+				`invalid NTP server "ntp_server.example.com": must be a hostname or an IP address`,
+		},
+		"bad-ntp-source": {
+			timezone: &TimezoneCustomization{
+				NTPSources: []NTPSourceCustomization{{Hostname: "-bad.example.com"}},
+			},
+			err: "invalid timezone customizations:\n" +
+				`invalid NTP server "-bad.example.com": must be a hostname or an IP address`,
+		},
+		"duplicate-ntp-server": {
+			timezone: &TimezoneCustomization{
+				NTPServers: []string{"time.example.com"},
+				NTPSources: []NTPSourceCustomization{{Hostname: "time.example.com", Prefer: true}},
+			},
+			err: "invalid timezone customizations:\n" +
+				`duplicate NTP server "time.example.com"`,
+		},
+		"bad-makestep": {
+			timezone: &TimezoneCustomization{
+				MakeStep: &ChronyMakeStepCustomization{Threshold: 0, Limit: -2},
+			},
+			err: "invalid timezone customizations:\n" +
+				"makestep threshold must be greater than 0, got 0\n" +
+				"makestep limit must be greater than 0 or -1 for no limit, got -2",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.timezone.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestTimezoneCustomizationChronyConf(t *testing.T) {
+	tz := &TimezoneCustomization{
+		NTPServers: []string{"ntp1.example.com"},
+		NTPSources: []NTPSourceCustomization{
+			{Hostname: "2.fedora.pool.ntp.org", Pool: true, IBurst: true},
+			{Hostname: "10.0.0.1", Prefer: true},
+		},
+		MakeStep: &ChronyMakeStepCustomization{Threshold: 0.1, Limit: 5},
+	}
+
+	expected := `server ntp1.example.com iburst
+pool 2.fedora.pool.ntp.org iburst
+server 10.0.0.1 prefer
+makestep 0.1 5
+`
+	assert.Equal(t, expected, tz.ChronyConf())
+
+	file, err := tz.ToChronyConfFile()
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/chrony.d/blueprint.conf", file.Path())
+	assert.Equal(t, expected, string(file.Data()))
+
+	// only customized settings are written, the distribution defaults
+	// stay in /etc/chrony.conf
+	tz.MakeStep = nil
+	assert.Equal(t, "server ntp1.example.com iburst\npool 2.fedora.pool.ntp.org iburst\nserver 10.0.0.1 prefer\n", tz.ChronyConf())
+
+	// no NTP settings, no file
+	file, err = (&TimezoneCustomization{Timezone: common.ToPtr("UTC")}).ToChronyConfFile()
+	assert.NoError(t, err)
+	assert.Nil(t, file)
+
+	_, err = (&TimezoneCustomization{NTPServers: []string{"not valid"}}).ToChronyConfFile()
+	assert.Error(t, err)
+}
+
+func TestGetTimezone(t *testing.T) {
+	expected := &TimezoneCustomization{
+		Timezone:   common.ToPtr("America/New_York"),
+		NTPServers: []string{"time.example.com"},
+		NTPSources: []NTPSourceCustomization{
+			{Hostname: "pool.ntp.org", Pool: true, IBurst: true},
+		},
+		MakeStep: &ChronyMakeStepCustomization{Threshold: 1, Limit: 3},
+	}
+
+	tomlData := `
+[customizations.timezone]
+timezone = "America/New_York"
+ntpservers = ["time.example.com"]
+makestep = { threshold = 1.0, limit = 3 }
+
+[[customizations.timezone.ntp_sources]]
+hostname = "pool.ntp.org"
+pool = true
+iburst = true
+`
+	var bp Blueprint
+	_, err := toml.Decode(tomlData, &bp)
+	require.NoError(t, err)
+	tz, err := bp.Customizations.GetTimezone()
+	require.NoError(t, err)
+	assert.Equal(t, expected, tz)
+
+	jsonData := `{"customizations":{"timezone":{"timezone":"America/New_York","ntpservers":["time.example.com"],"ntp_sources":[{"hostname":"pool.ntp.org","pool":true,"iburst":true}],"makestep":{"threshold":1,"limit":3}}}}`
+	bp = Blueprint{}
+	require.NoError(t, json.Unmarshal([]byte(jsonData), &bp))
+	tz, err = bp.Customizations.GetTimezone()
+	require.NoError(t, err)
+	assert.Equal(t, expected, tz)
+
+	bp.Customizations.Timezone.Timezone = common.ToPtr("America/New_Yrok")
+	_, err = bp.Customizations.GetTimezone()
+	assert.Error(t, err)
+
+	var nilCustomizations *Customizations
+	tz, err = nilCustomizations.GetTimezone()
+	assert.NoError(t, err)
+	assert.Nil(t, tz)
+}